@@ -5,15 +5,19 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"runtime/debug"
+	"slices"
 	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/internaljwt"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/login"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/metrics"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/redirects"
@@ -140,11 +144,19 @@ func main() {
 		os.Exit(1)
 	}
 	// Initialize the token store
-	tokenStore, err := tokenstore.NewTokenStore(
-		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+	tokenStoreOptions := []tokenstore.TokenRefresherOption{
+		tokenstore.WithExpiryMargin(time.Duration(3) * time.Minute),
 		tokenstore.WithConfig(gwConfig.Login),
 		tokenstore.WithTokenRepository(dbAdapter),
-	)
+		tokenstore.WithRefreshLocker(dbAdapter),
+	}
+	if gwConfig.Login.TokenRefresher.Enabled {
+		tokenStoreOptions = append(tokenStoreOptions, tokenstore.WithRefreshSchedule(
+			dbAdapter,
+			time.Duration(gwConfig.Login.TokenRefresher.RefreshAheadSeconds)*time.Second,
+		))
+	}
+	tokenStore, err := tokenstore.NewTokenStore(tokenStoreOptions...)
 	if err != nil {
 		slog.Error("token store initialization failed", "error", err)
 		os.Exit(1)
@@ -161,11 +173,23 @@ func main() {
 		sessions.WithSessionRepository(dbAdapter),
 		sessions.WithTokenStore(tokenStore),
 		sessions.WithConfig(gwConfig.Sessions),
+		sessions.WithProviderIDs(slices.Collect(maps.Keys(gwConfig.Login.Providers))...),
 	)
 	if err != nil {
 		slog.Error("failed to initialize sessions", "error", err)
 		os.Exit(1)
 	}
+	// Start refreshing the tokens of active sessions in the background
+	if gwConfig.Login.TokenRefresher.Enabled {
+		refresher, err := tokenstore.NewBackgroundRefresher(tokenStore, gwConfig.Login.TokenRefresher, sessionStore.HasLiveSession)
+		if err != nil {
+			slog.Error("failed to initialize the background token refresher", "error", err)
+			os.Exit(1)
+		}
+		refresherCtx, stopRefresher := context.WithCancel(context.Background())
+		defer stopRefresher()
+		refresher.Start(refresherCtx)
+	}
 	// Add the session store to the common middlewares
 	gwMiddlewares := append(commonMiddlewares, sessionStore.Middleware())
 	// Create redirect store
@@ -177,11 +201,33 @@ func main() {
 		os.Exit(1)
 	}
 	// Initialize the reverse proxy
-	revproxy, err := revproxy.NewServer(revproxy.WithConfig(gwConfig.Revproxy), revproxy.WithSessionStore(sessionStore), revproxy.WithRedirectsStore(redirectStore))
+	revproxyOptions := []revproxy.RevproxyOption{
+		revproxy.WithConfig(gwConfig.Revproxy),
+		revproxy.WithSessionStore(sessionStore),
+		revproxy.WithRedirectsStore(redirectStore),
+		revproxy.WithLoginURL(gwConfig.Login.RenkuBaseURL.JoinPath(gwConfig.Login.LoginRoutesBasePath, "login")),
+	}
+	if gwConfig.Revproxy.InternalJWT.Enabled {
+		signer, err := internaljwt.NewSigner(
+			internaljwt.WithIssuer(gwConfig.Revproxy.RenkuBaseURL.String()),
+			internaljwt.WithConfig(gwConfig.Revproxy.InternalJWT),
+		)
+		if err != nil {
+			slog.Error("failed to initialize the internal JWT signer", "error", err)
+			os.Exit(1)
+		}
+		signerCtx, stopSigner := context.WithCancel(context.Background())
+		defer stopSigner()
+		signer.Start(signerCtx)
+		e.GET(path.Join(gwConfig.Login.LoginRoutesBasePath, "jwks"), signer.JWKSHandler)
+		revproxyOptions = append(revproxyOptions, revproxy.WithInternalJWTSigner(signer))
+	}
+	revproxy, err := revproxy.NewServer(revproxyOptions...)
 	if err != nil {
 		slog.Error("revproxy handlers initialization failed", "error", err)
 		os.Exit(1)
 	}
+	defer revproxy.Stop()
 	revproxy.RegisterHandlers(e, gwMiddlewares...)
 	// Initialize login server
 	metricsClient, err := metrics.NewPosthogClient(gwConfig.Posthog)
@@ -194,7 +240,9 @@ func main() {
 	}
 	loginOptions := []login.LoginServerOption{login.WithConfig(gwConfig.Login),
 		login.WithSessionStore(sessionStore),
-		login.WithTokenStore(tokenStore)}
+		login.WithTokenStore(tokenStore),
+		login.WithLoginFlowRepository(dbAdapter),
+		login.WithAuthenticator(authenticator)}
 	if metricsClient != nil {
 		loginOptions = append(loginOptions, login.WithMetricsClient(metricsClient))
 	}
@@ -218,7 +266,10 @@ func main() {
 	}
 	// CORS
 	if len(gwConfig.Server.AllowOrigin) > 0 {
-		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{AllowOrigins: gwConfig.Server.AllowOrigin}))
+		e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowOrigins:  gwConfig.Server.AllowOrigin,
+			ExposeHeaders: []string{sessions.SessionExpiresAtHeader, sessions.SessionMaxExpiresAtHeader},
+		}))
 	}
 	// Prometheus
 	if gwConfig.Monitoring.Prometheus.Enabled {
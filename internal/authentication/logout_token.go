@@ -0,0 +1,67 @@
+package authentication
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+const backchannelLogoutEvent string = "http://schemas.openid.net/event/backchannel-logout"
+
+// logoutTokenMaxAge limits how old a logout token can be when it is received
+const logoutTokenMaxAge time.Duration = 5 * time.Minute
+
+// VerifyLogoutToken verifies a logout token sent by an identity provider as specified in
+// OpenID Connect Back-Channel Logout 1.0. The token has to be signed by the issuer of one
+// of the configured verifiers and it has to be intended for its audience.
+func (a Authenticator) VerifyLogoutToken(ctx context.Context, logoutToken string) (oidc.LogoutTokenClaims, error) {
+	claims := new(oidc.LogoutTokenClaims)
+	payload, err := oidc.ParseToken(logoutToken, claims)
+	if err != nil {
+		return oidc.LogoutTokenClaims{}, err
+	}
+	for _, verifier := range a {
+		if verifier.issuer == claims.Issuer && slices.Contains(claims.Audience, verifier.audience) {
+			return verifier.verifyLogoutToken(ctx, logoutToken, payload, claims)
+		}
+	}
+	return oidc.LogoutTokenClaims{}, fmt.Errorf("logout token has an unrecognized issuer %s or audience %v", claims.Issuer, claims.Audience)
+}
+
+// signedLogoutToken allows checking the signature of logout tokens with oidc.CheckSignature
+type signedLogoutToken struct {
+	*oidc.LogoutTokenClaims
+}
+
+func (signedLogoutToken) SetSignatureAlgorithm(jose.SignatureAlgorithm) {}
+
+func (tv tokenVerifier) verifyLogoutToken(ctx context.Context, logoutToken string, payload []byte, claims *oidc.LogoutTokenClaims) (oidc.LogoutTokenClaims, error) {
+	if err := oidc.CheckSignature(ctx, logoutToken, payload, signedLogoutToken{claims}, []string{}, tv.keyset); err != nil {
+		return oidc.LogoutTokenClaims{}, err
+	}
+
+	now := time.Now()
+	if !now.Add(-verifierOffset).Before(claims.Expiration.AsTime()) {
+		return oidc.LogoutTokenClaims{}, oidc.ErrExpired
+	}
+	issuedAt := claims.IssuedAt.AsTime()
+	if issuedAt.IsZero() || issuedAt.After(now.Add(verifierOffset)) || issuedAt.Before(now.Add(-logoutTokenMaxAge)) {
+		return oidc.LogoutTokenClaims{}, fmt.Errorf("logout token has an invalid issued at time %s", issuedAt)
+	}
+
+	if _, found := claims.Events[backchannelLogoutEvent]; !found {
+		return oidc.LogoutTokenClaims{}, fmt.Errorf("logout token is missing the back-channel logout event")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return oidc.LogoutTokenClaims{}, fmt.Errorf("logout token has to contain a subject or a session ID")
+	}
+	if _, found := claims.Claims["nonce"]; found {
+		return oidc.LogoutTokenClaims{}, fmt.Errorf("logout token cannot contain a nonce")
+	}
+
+	return *claims, nil
+}
@@ -18,8 +18,6 @@ login:
   providers:
     renku:
       clientSecret: client-secret-from-secret-file
-      cookieEncodingKey: enc-key-from-secret-file
-      cookieHashKey: hash-key-from-secret-file
 `
 	err := os.WriteFile(fpath, []byte(contents), 0666)
 	return err
@@ -39,8 +37,6 @@ func TestReadConfig(t *testing.T) {
 	assert.Equal(t, "https://renkulab.io", config.Revproxy.RenkuBaseURL.String())
 	assert.Equal(t, RedactedString("secret-key-from-secret-file"), config.Login.TokenEncryption.SecretKey)
 	assert.Equal(t, RedactedString("client-secret-from-secret-file"), config.Login.Providers[providerID].ClientSecret)
-	assert.Equal(t, RedactedString("enc-key-from-secret-file"), config.Login.Providers[providerID].CookieEncodingKey)
-	assert.Equal(t, RedactedString("hash-key-from-secret-file"), config.Login.Providers[providerID].CookieHashKey)
 }
 
 func TestReadConfigWithEnvVars(t *testing.T) {
@@ -59,8 +55,6 @@ func TestReadConfigWithEnvVars(t *testing.T) {
 	assert.Len(t, config.Login.Providers, 1)
 	assert.Equal(t, "https://dev.renku.ch", config.Revproxy.RenkuBaseURL.String())
 	assert.Equal(t, RedactedString("env-var-secret"), config.Login.Providers[providerID].ClientSecret)
-	assert.Equal(t, RedactedString("enc-key-from-secret-file"), config.Login.Providers[providerID].CookieEncodingKey)
-	assert.Equal(t, RedactedString("hash-key-from-secret-file"), config.Login.Providers[providerID].CookieHashKey)
 	assert.Equal(t, RedactedString("token-encryption-key-12345678910"), config.Login.TokenEncryption.SecretKey)
 }
 
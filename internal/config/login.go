@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"regexp"
 )
 
 type TokenEncryptionConfig struct {
@@ -18,22 +19,79 @@ type LoginConfig struct {
 	Providers                   map[string]OIDCClient
 	OldGitLabLogout             bool
 	LogoutGitLabUponRenkuLogout bool
+	RedirectAllowlist           RedirectAllowlistConfig
+	TokenRefresher              TokenRefresherConfig
+	// How long users have to complete a login flow, defaults to 600 seconds
+	LoginFlowTTLSeconds int
 }
 
+// TokenRefresherConfig describes the background worker that refreshes the tokens of active sessions
+// before they expire, so that requests do not wait for the identity provider and refresh tokens
+// of idle sessions do not lapse
+type TokenRefresherConfig struct {
+	Enabled bool
+	// How often the worker looks for tokens to refresh, defaults to 30 seconds
+	IntervalSeconds int
+	// How long before the access or refresh token expires the tokens are refreshed, defaults to 300 seconds.
+	// At most half of the lifetime of the tokens is used, so that tokens with a short lifetime are not
+	// refreshed on every run.
+	RefreshAheadSeconds int
+	// The maximum number of refreshes running at the same time in one replica, defaults to 4
+	Concurrency int
+	// The maximum number of tokens refreshed in one run, defaults to 100
+	BatchSize int
+}
+
+// RedirectAllowlistConfig restricts the URLs that users are sent to after logging in or out
+type RedirectAllowlistConfig struct {
+	// The allowed origins (i.e. https://renkulab.io), the origin of RenkuBaseURL is always allowed
+	Origins []string
+	// Regular expressions that the path of a redirect URL has to fully match, all paths are allowed if empty
+	PathPatterns []string
+}
+
+const ProviderTypeOIDC string = "oidc"
+const ProviderTypeOAuth2 string = "oauth2"
+
 type OIDCClient struct {
-	Issuer            string
-	ClientID          string
-	ClientSecret      RedactedString
-	Scopes            []string
-	CallbackURI       string
-	UsePKCE           bool
-	CookieEncodingKey RedactedString
-	CookieHashKey     RedactedString
-	// NOTE: UnsafeNoCookieHandler should only be used for testing, in production this has to be false/unset
-	// without this there is no CSRF protection on the oauth callback endpoint
-	UnsafeNoCookieHandler bool
+	// The type of the provider, either oidc (the default) or oauth2 for providers without OpenID Connect support
+	Type         string
+	Issuer       string
+	ClientID     string
+	ClientSecret RedactedString
+	Scopes       []string
+	CallbackURI  string
+	UsePKCE      bool
+	// The endpoints of oauth2 providers, oidc providers discover them from the issuer
+	AuthorizationURL string
+	TokenURL         string
+	// Optional endpoint of oauth2 providers returning the profile of the user, used to get the subject of the tokens
+	UserInfoURL string
+	// The field of the user info response holding the subject, defaults to sub
+	SubjectClaim string
+	// Allow users to get fresh access tokens of this provider from the /tokens/:providerId endpoint
+	ExposeAccessTokens bool
 }
 
+func (c *OIDCClient) Validate(providerID string) error {
+	switch c.Type {
+	case "", ProviderTypeOIDC:
+		return nil
+	case ProviderTypeOAuth2:
+		if providerID == "renku" {
+			return fmt.Errorf("the renku provider has to be an oidc provider")
+		}
+		if c.AuthorizationURL == "" || c.TokenURL == "" {
+			return fmt.Errorf("the oauth2 provider %s needs an authorizationURL and a tokenURL", providerID)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid type %s for provider %s (must be one of %s or %s)", c.Type, providerID, ProviderTypeOIDC, ProviderTypeOAuth2)
+}
+
+// providerIDPattern restricts provider IDs to values which are safe to use in token IDs and URLs
+var providerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 func (c LoginConfig) Validate(e RunningEnvironment) error {
 	// Fix the login config when EnableInternalGitlab is false
 	if !c.EnableInternalGitlab {
@@ -45,18 +103,52 @@ func (c LoginConfig) Validate(e RunningEnvironment) error {
 			len(c.TokenEncryption.SecretKey),
 		)
 	}
+	for k, v := range c.Providers {
+		if !providerIDPattern.MatchString(k) {
+			return fmt.Errorf("invalid provider id %s (must only contain lowercase letters, digits, '-' and '_')", k)
+		}
+		err := v.Validate(k)
+		if err != nil {
+			return err
+		}
+	}
 	if e != Development {
-		for k, v := range c.Providers {
-			if k != "renku" && k != "gitlab" {
-				return fmt.Errorf("unknown provider id %s (must be one of renku or gitlab)", k)
-			}
-			if v.UnsafeNoCookieHandler {
-				return fmt.Errorf("provider %s cannot be configured without a cookie handler in production", k)
-			}
+		if _, found := c.Providers["renku"]; len(c.Providers) > 0 && !found {
+			return fmt.Errorf("the renku provider has to be configured, the other providers are linked to its users")
 		}
 	}
+	if c.LoginFlowTTLSeconds < 0 {
+		return fmt.Errorf("the login flow TTL seconds (%d) cannot be negative", c.LoginFlowTTLSeconds)
+	}
 	if c.RenkuBaseURL == nil {
 		return fmt.Errorf("the renkuBaseURL cannot be null or ''")
 	}
+	err := c.TokenRefresher.Validate()
+	if err != nil {
+		return err
+	}
+	return c.RedirectAllowlist.Validate()
+}
+
+func (t *TokenRefresherConfig) Validate() error {
+	if t.IntervalSeconds < 0 || t.RefreshAheadSeconds < 0 || t.Concurrency < 0 || t.BatchSize < 0 {
+		return fmt.Errorf("the token refresher cannot have negative values")
+	}
+	return nil
+}
+
+func (r *RedirectAllowlistConfig) Validate() error {
+	for _, origin := range r.Origins {
+		originURL, err := url.Parse(origin)
+		if err != nil || (originURL.Scheme != "http" && originURL.Scheme != "https") || originURL.Host == "" || (originURL.Path != "" && originURL.Path != "/") {
+			return fmt.Errorf("the allowed redirect origin %s has to be a http or https URL without a path", origin)
+		}
+	}
+	for _, pattern := range r.PathPatterns {
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("the allowed redirect path pattern %s is invalid: %w", pattern, err)
+		}
+	}
 	return nil
 }
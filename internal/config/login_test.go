@@ -41,23 +41,116 @@ func TestInvalidTokenEncryptionSecretKey(t *testing.T) {
 func TestInvalidProviderName(t *testing.T) {
 	config := getValidLoginConfig(t)
 	config.Providers = map[string]OIDCClient{
-		"invalid-name": OIDCClient{},
+		"renku":        OIDCClient{},
+		"invalid:name": OIDCClient{},
 	}
 
 	err := config.Validate(Production)
 
-	assert.ErrorContains(t, err, "unknown provider id invalid-name (must be one of renku or gitlab)")
+	assert.ErrorContains(t, err, "invalid provider id invalid:name (must only contain lowercase letters, digits, '-' and '_')")
 }
 
-func TestInvalidProviderUnsafeNoCookieHandler(t *testing.T) {
+func TestValidAdditionalProviders(t *testing.T) {
 	config := getValidLoginConfig(t)
 	config.Providers = map[string]OIDCClient{
-		"renku": OIDCClient{
-			UnsafeNoCookieHandler: true,
+		"renku":         OIDCClient{},
+		"github":        OIDCClient{},
+		"zenodo":        OIDCClient{},
+		"gitlab-ethz_2": OIDCClient{},
+	}
+
+	err := config.Validate(Production)
+
+	assert.NoError(t, err)
+}
+
+func TestMissingPrimaryProvider(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.Providers = map[string]OIDCClient{
+		"github": OIDCClient{},
+	}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the renku provider has to be configured")
+}
+
+func TestValidRedirectAllowlist(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.RedirectAllowlist = RedirectAllowlistConfig{
+		Origins:      []string{"https://docs.example.org"},
+		PathPatterns: []string{"/", "/projects/.*"},
+	}
+
+	err := config.Validate(Production)
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidRedirectAllowlistOrigin(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.RedirectAllowlist.Origins = []string{"https://docs.example.org/some/path"}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the allowed redirect origin https://docs.example.org/some/path has to be a http or https URL without a path")
+}
+
+func TestInvalidRedirectAllowlistPathPattern(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.RedirectAllowlist.PathPatterns = []string{"/projects/(.*"}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the allowed redirect path pattern /projects/(.* is invalid")
+}
+
+func TestInvalidTokenRefresher(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.TokenRefresher = TokenRefresherConfig{Enabled: true, Concurrency: -1}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the token refresher cannot have negative values")
+}
+
+func TestValidOAuth2Provider(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.Providers = map[string]OIDCClient{
+		"renku": OIDCClient{},
+		"github": OIDCClient{
+			Type:             ProviderTypeOAuth2,
+			AuthorizationURL: "https://github.com/login/oauth/authorize",
+			TokenURL:         "https://github.com/login/oauth/access_token",
+			UserInfoURL:      "https://api.github.com/user",
+			SubjectClaim:     "id",
 		},
 	}
 
 	err := config.Validate(Production)
 
-	assert.ErrorContains(t, err, "provider renku cannot be configured without a cookie handler in production")
+	assert.NoError(t, err)
+}
+
+func TestInvalidOAuth2Provider(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.Providers = map[string]OIDCClient{
+		"renku":  OIDCClient{},
+		"github": OIDCClient{Type: ProviderTypeOAuth2},
+	}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the oauth2 provider github needs an authorizationURL and a tokenURL")
+}
+
+func TestInvalidProviderType(t *testing.T) {
+	config := getValidLoginConfig(t)
+	config.Providers = map[string]OIDCClient{
+		"renku": OIDCClient{Type: "saml"},
+	}
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "invalid type saml for provider renku (must be one of oidc or oauth2)")
 }
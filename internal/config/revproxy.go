@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 )
 
 type RenkuServicesConfig struct {
@@ -11,12 +14,191 @@ type RenkuServicesConfig struct {
 	UIServer    *url.URL
 }
 
+// RewriteRule rewrites the path of a request matching the regular expression in Match with Replace.
+// Capture groups from Match can be referenced in Replace with $1, $2, etc.
+type RewriteRule struct {
+	Match   string
+	Replace string
+}
+
+// RouteConfig describes a group of routes in the reverse proxy that share the same path prefix,
+// upstream and authentication.
+type RouteConfig struct {
+	// The path prefix that the route matches, i.e. /api/data
+	PathPrefix string
+	// The URL of the service where the requests are proxied to
+	Upstream *url.URL
+	// Remove the path prefix from the request path before proxying
+	StripPrefix bool
+	// Path rewrite rules applied before proxying
+	Rewrites []RewriteRule
+	// Set the host of the request to the host of the upstream, needed for services outside of the cluster
+	SetHost bool
+	// The ordered list of authentication middlewares (i.e. renkuAccessToken) that run before proxying
+	Auth []string
+	// The audience of the tokens sent to the upstream, i.e. of internal JWTs or exchanged tokens,
+	// defaults to the host name of the upstream
+	Audience string
+	// Exchange the Renku access token for a token with the audience of the route before it is injected
+	TokenExchange TokenExchangeConfig
+}
+
+// TokenExchangeConfig describes how the Renku access token is exchanged at Keycloak for a token which is
+// only valid for one upstream (RFC 8693), it applies to the renkuAccessToken authentication of a route
+type TokenExchangeConfig struct {
+	Enabled bool
+	// The scopes requested for the exchanged token, the scopes of the access token are kept when empty
+	Scopes []string
+}
+
+// IdentityHeadersConfig describes which headers are removed from the requests sent by clients before
+// the gateway injects its own credentials, so that clients cannot impersonate a user
+type IdentityHeadersConfig struct {
+	// The headers that are removed, a trailing '*' matches all headers with the given prefix.
+	// Defaults to the headers injected by the gateway.
+	Strip []string
+	// The headers that are forwarded as sent by the client even if they match Strip,
+	// i.e. Authorization to forward a client's own bearer token
+	Allow []string
+}
+
+// StepUpRule requires a recent or a stronger authentication of the user for the requests matching a path prefix.
+// Users who do not meet the requirements have to log in again before they can use the routes.
+type StepUpRule struct {
+	// The path prefix that the rule matches, i.e. /api/data/user/secret_key
+	PathPrefix string
+	// The login of the user has to be at most this old (auth_time claim), 0 does not check the login time
+	MaxAgeSeconds int
+	// The authentication context class (acr claim) that the login has to have, empty does not check it
+	ACR string
+}
+
+// InternalJWTConfig describes the short-lived JWTs that the gateway signs for the backend services,
+// so that the tokens of the identity provider do not have to leave the gateway
+type InternalJWTConfig struct {
+	Enabled bool
+	// The issuer of the JWTs (iss claim), defaults to the Renku base URL
+	Issuer string
+	// Files with PEM encoded RSA, EC or Ed25519 private keys. The first key signs the JWTs, the other keys
+	// are only published so that the JWTs signed before a key rotation can still be verified.
+	SigningKeyFiles []string
+	// How often the key files are read again to pick up rotated keys, 0 disables reloading
+	KeyReloadSeconds int
+	// The lifetime of the JWTs, defaults to 300 seconds
+	TTLSeconds int
+	// The claims of the Renku access token copied into the JWTs in addition to iss, sub, aud, iat and exp,
+	// defaults to name, email and preferred_username
+	Claims []string
+}
+
+// AuthorizationRequirement is what a request has to meet to pass an authorization rule
+type AuthorizationRequirement string
+
+const (
+	// The request has to come from a logged in user
+	RequireAuthenticated AuthorizationRequirement = "authenticated"
+	// The request has to come from a user who is not logged in
+	RequireAnonymous AuthorizationRequirement = "anonymous"
+	// The Renku access token of the user has to contain one of the accepted values in a claim
+	RequireClaim AuthorizationRequirement = "claim"
+)
+
+// AuthorizationRule decides if the requests matching a path prefix and method are allowed before they are proxied.
+// Requests from users who are not logged in are rejected with a 401, other rejected requests get a 403.
+type AuthorizationRule struct {
+	// The path prefix that the rule matches, i.e. /api/data/admin
+	PathPrefix string
+	// The HTTP methods that the rule matches, all methods are matched when empty
+	Methods []string
+	Require AuthorizationRequirement
+	// The claim of the Renku access token checked by the claim requirement, nested claims are separated
+	// by dots, i.e. realm_access.roles
+	Claim string
+	// The claim has to contain at least one of these values
+	Values []string
+}
+
+// UpstreamConfig describes how requests are balanced between the replicas of an upstream service
+type UpstreamConfig struct {
+	// The upstream URL as it is used in the routes or the Renku services config
+	URL *url.URL
+	// The addresses of the replicas that serve the upstream, defaults to the upstream URL
+	Targets     []*url.URL
+	HealthCheck HealthCheckConfig
+	// Consecutive 5xx responses or connection failures after which a target is ejected, 0 disables ejection
+	MaxConsecutiveFailures int
+	// How long an ejected target does not receive any requests
+	EjectionSeconds int
+	// Timeout for establishing a connection to a target, 0 uses the default of 30 seconds
+	ConnectTimeoutSeconds int
+	// Timeout for receiving the response headers after the request is sent, 0 means no timeout
+	ResponseHeaderTimeoutSeconds int
+	// Timeout for the whole request including the response body, 0 means no timeout.
+	// Websocket connections are not affected by it.
+	TimeoutSeconds int
+	Retry          RetryConfig
+	CircuitBreaker CircuitBreakerConfig
+}
+
+// CircuitBreakerConfig describes when requests to an upstream fail fast because the upstream keeps failing.
+// The breaker opens when the error rate in a window exceeds the threshold, after OpenSeconds it lets a few
+// probe requests through (half-open) and closes again if they succeed.
+type CircuitBreakerConfig struct {
+	Enabled bool
+	// The ratio of failed requests (5xx responses or connection failures) between 0 and 1 that opens the breaker
+	ErrorRateThreshold float64
+	// The minimum number of requests in a window before the error rate is considered, defaults to 20
+	MinimumRequests int
+	// The length of the window in which the error rate is computed, defaults to 30 seconds
+	WindowSeconds int
+	// How long the breaker stays open before probing the upstream again, defaults to 30 seconds
+	OpenSeconds int
+	// The number of probe requests let through while half-open, defaults to 1
+	HalfOpenRequests int
+}
+
+// RetryConfig describes how GET, HEAD and OPTIONS requests are retried when the connection to a target
+// fails or the target responds with 502, 503 or 504
+type RetryConfig struct {
+	// The number of retries after the first attempt, 0 disables retries
+	MaxRetries int
+	// The delay before a retry grows exponentially from BaseDelayMilliseconds up to MaxDelayMilliseconds,
+	// a random jitter is applied to it
+	BaseDelayMilliseconds int
+	MaxDelayMilliseconds  int
+}
+
+// HealthCheckConfig describes the active HTTP probes sent to every target of an upstream
+type HealthCheckConfig struct {
+	Enabled bool
+	// The path that is probed with GET requests, any 2xx or 3xx response counts as healthy
+	Path            string
+	IntervalSeconds int
+	TimeoutSeconds  int
+	// Consecutive failed probes after which a target is marked unhealthy
+	UnhealthyThreshold int
+	// Consecutive successful probes after which an unhealthy target is marked healthy again
+	HealthyThreshold int
+}
+
 type RevproxyConfig struct {
 	EnableInternalGitlab bool
 	RenkuBaseURL         *url.URL
 	ExternalGitlabURL    *url.URL
 	K8sNamespace         string
 	RenkuServices        RenkuServicesConfig
+	// Routes are added to the default routes, a route with the same path prefix as a default route replaces it
+	Routes []RouteConfig
+	// Upstreams with several replicas, health checks or passive ejection
+	Upstreams []UpstreamConfig
+	// Identity headers that clients are not allowed to send
+	IdentityHeaders IdentityHeadersConfig
+	// Routes which require a recent or stronger authentication
+	StepUp []StepUpRule
+	// Rules deciding which requests are allowed, the rule with the longest matching path prefix applies
+	Authorization []AuthorizationRule
+	// JWTs signed by the gateway which replace the Renku access token for the backend services
+	InternalJWT InternalJWTConfig
 }
 
 func (r *RevproxyConfig) Validate() error {
@@ -32,6 +214,152 @@ func (r *RevproxyConfig) Validate() error {
 	if r.RenkuBaseURL == nil {
 		return fmt.Errorf("the renkuBaseURL cannot be null or ''")
 	}
+	for _, route := range r.Routes {
+		err := route.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, upstream := range r.Upstreams {
+		err := upstream.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err := r.IdentityHeaders.Validate()
+	if err != nil {
+		return err
+	}
+	for _, rule := range r.StepUp {
+		err := rule.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	for _, rule := range r.Authorization {
+		err := rule.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	err = r.InternalJWT.Validate()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *RouteConfig) Validate() error {
+	if !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("the path prefix of a route has to start with '/', got '%s'", r.PathPrefix)
+	}
+	if r.Upstream == nil {
+		return fmt.Errorf("the route %s is missing the upstream url", r.PathPrefix)
+	}
+	for _, rule := range r.Rewrites {
+		_, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return fmt.Errorf("the route %s has an invalid rewrite rule %s: %w", r.PathPrefix, rule.Match, err)
+		}
+	}
+	return nil
+}
+
+func (s *StepUpRule) Validate() error {
+	if !strings.HasPrefix(s.PathPrefix, "/") {
+		return fmt.Errorf("the path prefix of a step-up rule has to start with '/', got '%s'", s.PathPrefix)
+	}
+	if s.MaxAgeSeconds < 0 {
+		return fmt.Errorf("the step-up rule %s cannot have a negative max age", s.PathPrefix)
+	}
+	if s.MaxAgeSeconds == 0 && s.ACR == "" {
+		return fmt.Errorf("the step-up rule %s requires neither a max age nor an acr value", s.PathPrefix)
+	}
+	return nil
+}
 
+func (a *AuthorizationRule) Validate() error {
+	if !strings.HasPrefix(a.PathPrefix, "/") {
+		return fmt.Errorf("the path prefix of an authorization rule has to start with '/', got '%s'", a.PathPrefix)
+	}
+	for _, method := range a.Methods {
+		if method == "" || strings.ContainsAny(method, " /") {
+			return fmt.Errorf("the authorization rule %s has an invalid method '%s'", a.PathPrefix, method)
+		}
+	}
+	switch a.Require {
+	case RequireAuthenticated, RequireAnonymous:
+		return nil
+	case RequireClaim:
+		if a.Claim == "" || len(a.Values) == 0 {
+			return fmt.Errorf("the authorization rule %s requires a claim but the claim name or values are missing", a.PathPrefix)
+		}
+		return nil
+	default:
+		return fmt.Errorf(
+			"the authorization rule %s has an unknown requirement '%s', it has to be one of %s, %s or %s",
+			a.PathPrefix,
+			a.Require,
+			RequireAuthenticated,
+			RequireAnonymous,
+			RequireClaim,
+		)
+	}
+}
+
+func (j *InternalJWTConfig) Validate() error {
+	if !j.Enabled {
+		return nil
+	}
+	if len(j.SigningKeyFiles) == 0 {
+		return fmt.Errorf("internal JWTs are enabled but no signing key files are configured")
+	}
+	if j.KeyReloadSeconds < 0 || j.TTLSeconds < 0 {
+		return fmt.Errorf("the internal JWT config cannot have negative values")
+	}
+	return nil
+}
+
+func (h *IdentityHeadersConfig) Validate() error {
+	for _, header := range slices.Concat(h.Strip, h.Allow) {
+		name := strings.TrimSuffix(header, "*")
+		if name == "" || strings.ContainsAny(name, "* ") {
+			return fmt.Errorf("the identity header '%s' is not a valid header name or prefix", header)
+		}
+	}
+	return nil
+}
+
+func (u *UpstreamConfig) Validate() error {
+	if u.URL == nil {
+		return fmt.Errorf("the upstream config is missing the url")
+	}
+	for _, target := range u.Targets {
+		if target == nil {
+			return fmt.Errorf("the upstream %s has an empty target url", u.URL.String())
+		}
+	}
+	if u.MaxConsecutiveFailures < 0 {
+		return fmt.Errorf("the upstream %s cannot have a negative number of max consecutive failures", u.URL.String())
+	}
+	if u.MaxConsecutiveFailures > 0 && u.EjectionSeconds <= 0 {
+		return fmt.Errorf("the upstream %s ejects targets but the ejection seconds (%d) are not greater than 0", u.URL.String(), u.EjectionSeconds)
+	}
+	if u.ConnectTimeoutSeconds < 0 || u.ResponseHeaderTimeoutSeconds < 0 || u.TimeoutSeconds < 0 {
+		return fmt.Errorf("the upstream %s cannot have negative timeouts", u.URL.String())
+	}
+	if u.Retry.MaxRetries < 0 || u.Retry.BaseDelayMilliseconds < 0 || u.Retry.MaxDelayMilliseconds < 0 {
+		return fmt.Errorf("the upstream %s cannot have negative retry values", u.URL.String())
+	}
+	if u.CircuitBreaker.Enabled && (u.CircuitBreaker.ErrorRateThreshold <= 0 || u.CircuitBreaker.ErrorRateThreshold > 1) {
+		return fmt.Errorf("the error rate threshold of the circuit breaker of the upstream %s has to be greater than 0 and at most 1, got %v", u.URL.String(), u.CircuitBreaker.ErrorRateThreshold)
+	}
+	if u.CircuitBreaker.MinimumRequests < 0 || u.CircuitBreaker.WindowSeconds < 0 || u.CircuitBreaker.OpenSeconds < 0 || u.CircuitBreaker.HalfOpenRequests < 0 {
+		return fmt.Errorf("the upstream %s cannot have negative circuit breaker values", u.URL.String())
+	}
+	if u.HealthCheck.Enabled && !strings.HasPrefix(u.HealthCheck.Path, "/") {
+		return fmt.Errorf("the health check path of the upstream %s has to start with '/', got '%s'", u.URL.String(), u.HealthCheck.Path)
+	}
 	return nil
 }
@@ -70,3 +70,242 @@ func TestInvalidUIServerURL(t *testing.T) {
 
 	assert.ErrorContains(t, err, "the proxy config is missing the url to ui-server")
 }
+
+func TestValidRoutes(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Routes = []RouteConfig{{
+		PathPrefix: "/api/search",
+		Upstream:   config.RenkuServices.DataService,
+		Rewrites:   []RewriteRule{{Match: "^/api/search(.*)", Replace: "/api/data/search$1"}},
+		Auth:       []string{"renkuAccessToken"},
+	}}
+
+	err := config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidRoutePathPrefix(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Routes = []RouteConfig{{
+		PathPrefix: "api/search",
+		Upstream:   config.RenkuServices.DataService,
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the path prefix of a route has to start with '/', got 'api/search'")
+}
+
+func TestInvalidRouteUpstream(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Routes = []RouteConfig{{
+		PathPrefix: "/api/search",
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the route /api/search is missing the upstream url")
+}
+
+func TestInvalidRouteRewrite(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Routes = []RouteConfig{{
+		PathPrefix: "/api/search",
+		Upstream:   config.RenkuServices.DataService,
+		Rewrites:   []RewriteRule{{Match: "^/api/search(.*", Replace: "/api/data/search$1"}},
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the route /api/search has an invalid rewrite rule")
+}
+
+func TestValidUpstreams(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	replicaURL, err := url.Parse("http://data-service-2")
+	require.NoError(t, err)
+	config.Upstreams = []UpstreamConfig{{
+		URL:                    config.RenkuServices.DataService,
+		Targets:                []*url.URL{config.RenkuServices.DataService, replicaURL},
+		HealthCheck:            HealthCheckConfig{Enabled: true, Path: "/health"},
+		MaxConsecutiveFailures: 5,
+		EjectionSeconds:        30,
+		ConnectTimeoutSeconds:  5,
+		TimeoutSeconds:         60,
+		Retry:                  RetryConfig{MaxRetries: 2, BaseDelayMilliseconds: 100, MaxDelayMilliseconds: 1000},
+		CircuitBreaker:         CircuitBreakerConfig{Enabled: true, ErrorRateThreshold: 0.5, OpenSeconds: 10},
+	}}
+
+	err = config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidUpstreamEjection(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:                    config.RenkuServices.DataService,
+		MaxConsecutiveFailures: 5,
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the upstream http://data-service ejects targets but the ejection seconds (0) are not greater than 0")
+}
+
+func TestInvalidUpstreamHealthCheckPath(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:         config.RenkuServices.DataService,
+		HealthCheck: HealthCheckConfig{Enabled: true},
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the health check path of the upstream http://data-service has to start with '/', got ''")
+}
+
+func TestInvalidUpstreamTimeout(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:            config.RenkuServices.DataService,
+		TimeoutSeconds: -1,
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the upstream http://data-service cannot have negative timeouts")
+}
+
+func TestInvalidUpstreamRetry(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:   config.RenkuServices.DataService,
+		Retry: RetryConfig{MaxRetries: -2},
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the upstream http://data-service cannot have negative retry values")
+}
+
+func TestInvalidUpstreamCircuitBreakerThreshold(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:            config.RenkuServices.DataService,
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, ErrorRateThreshold: 1.5},
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the error rate threshold of the circuit breaker of the upstream http://data-service has to be greater than 0 and at most 1")
+}
+
+func TestInvalidUpstreamCircuitBreakerValues(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Upstreams = []UpstreamConfig{{
+		URL:            config.RenkuServices.DataService,
+		CircuitBreaker: CircuitBreakerConfig{Enabled: true, ErrorRateThreshold: 0.5, OpenSeconds: -1},
+	}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the upstream http://data-service cannot have negative circuit breaker values")
+}
+
+func TestValidIdentityHeaders(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.IdentityHeaders = IdentityHeadersConfig{
+		Strip: []string{"Renku-Auth-*", "Authorization"},
+		Allow: []string{"Authorization"},
+	}
+
+	err := config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidIdentityHeaders(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.IdentityHeaders = IdentityHeadersConfig{Strip: []string{"Renku-*-Token"}}
+
+	err := config.Validate()
+
+	assert.ErrorContains(t, err, "the identity header 'Renku-*-Token' is not a valid header name or prefix")
+}
+
+func TestValidStepUpRules(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.StepUp = []StepUpRule{
+		{PathPrefix: "/api/data/user", MaxAgeSeconds: 300},
+		{PathPrefix: "/api/data/admin", ACR: "gold"},
+	}
+
+	err := config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidStepUpRules(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.StepUp = []StepUpRule{{PathPrefix: "api/data/user", MaxAgeSeconds: 300}}
+	assert.ErrorContains(t, config.Validate(), "the path prefix of a step-up rule has to start with '/', got 'api/data/user'")
+
+	config.StepUp = []StepUpRule{{PathPrefix: "/api/data/user", MaxAgeSeconds: -1}}
+	assert.ErrorContains(t, config.Validate(), "the step-up rule /api/data/user cannot have a negative max age")
+
+	config.StepUp = []StepUpRule{{PathPrefix: "/api/data/user"}}
+	assert.ErrorContains(t, config.Validate(), "the step-up rule /api/data/user requires neither a max age nor an acr value")
+}
+
+func TestValidAuthorizationRules(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Authorization = []AuthorizationRule{
+		{PathPrefix: "/api/data/admin", Require: RequireClaim, Claim: "realm_access.roles", Values: []string{"renku-admin"}},
+		{PathPrefix: "/api/data/user", Methods: []string{"POST", "PATCH"}, Require: RequireAuthenticated},
+		{PathPrefix: "/api/data/signup", Require: RequireAnonymous},
+	}
+
+	err := config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidAuthorizationRules(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.Authorization = []AuthorizationRule{{PathPrefix: "api/data/admin", Require: RequireAuthenticated}}
+	assert.ErrorContains(t, config.Validate(), "the path prefix of an authorization rule has to start with '/', got 'api/data/admin'")
+
+	config.Authorization = []AuthorizationRule{{PathPrefix: "/api/data/admin", Methods: []string{""}, Require: RequireAuthenticated}}
+	assert.ErrorContains(t, config.Validate(), "the authorization rule /api/data/admin has an invalid method ''")
+
+	config.Authorization = []AuthorizationRule{{PathPrefix: "/api/data/admin", Require: "admin"}}
+	assert.ErrorContains(t, config.Validate(), "the authorization rule /api/data/admin has an unknown requirement 'admin'")
+
+	config.Authorization = []AuthorizationRule{{PathPrefix: "/api/data/admin", Require: RequireClaim, Claim: "groups"}}
+	assert.ErrorContains(t, config.Validate(), "the authorization rule /api/data/admin requires a claim but the claim name or values are missing")
+}
+
+func TestValidInternalJWT(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.InternalJWT = InternalJWTConfig{
+		Enabled:          true,
+		SigningKeyFiles:  []string{"/etc/gateway/jwt/current.pem", "/etc/gateway/jwt/previous.pem"},
+		KeyReloadSeconds: 60,
+		TTLSeconds:       120,
+	}
+
+	err := config.Validate()
+
+	assert.NoError(t, err)
+}
+
+func TestInvalidInternalJWT(t *testing.T) {
+	config := getValidRevproxyConfig(t)
+	config.InternalJWT = InternalJWTConfig{Enabled: true}
+	assert.ErrorContains(t, config.Validate(), "internal JWTs are enabled but no signing key files are configured")
+
+	config.InternalJWT = InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{"/key.pem"}, TTLSeconds: -1}
+	assert.ErrorContains(t, config.Validate(), "the internal JWT config cannot have negative values")
+}
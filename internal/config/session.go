@@ -6,8 +6,12 @@ type SessionConfig struct {
 	IdleSessionTTLSeconds  int
 	MaxSessionTTLSeconds   int
 	AuthorizationVerifiers []AuthorizationVerifier
-	CookieEncodingKey      RedactedString
-	CookieHashKey          RedactedString
+	// The keys which encrypt and sign the session cookie and the login flow cookies, the login flow cookies
+	// bind the callbacks of all the providers to the browser which started the login
+	CookieEncodingKey RedactedString
+	CookieHashKey     RedactedString
+	// The secret used to derive the anonymous user IDs from the session IDs, defaults to a key derived from CookieHashKey
+	AnonymousIDKey RedactedString
 	// NOTE: UnsafeNoCookieHandler should only be used for testing, in production this has to be false/unset
 	// without this there is no CSRF protection on the oauth callback endpoint
 	UnsafeNoCookieHandler bool
@@ -26,6 +30,9 @@ func (c *SessionConfig) Validate(e RunningEnvironment) error {
 	if c.MaxSessionTTLSeconds > 0 && c.IdleSessionTTLSeconds > c.MaxSessionTTLSeconds {
 		return fmt.Errorf("max session TTL seconds (%d) cannot be less than idle session TTL seconds (%d)", c.MaxSessionTTLSeconds, c.IdleSessionTTLSeconds)
 	}
+	if len(c.AnonymousIDKey) > 0 && len(c.AnonymousIDKey) < 32 {
+		return fmt.Errorf("the anonymous ID key has to be at least 32 bytes long, got %d", len(c.AnonymousIDKey))
+	}
 	if e != Development && c.UnsafeNoCookieHandler {
 		return fmt.Errorf("a cookie handler needs to be configured in production")
 	}
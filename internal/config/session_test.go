@@ -47,3 +47,12 @@ func TestInvalidUnsafeNoCookieHandler(t *testing.T) {
 
 	assert.ErrorContains(t, err, "a cookie handler needs to be configured in production")
 }
+
+func TestInvalidAnonymousIDKey(t *testing.T) {
+	config := getValidSessionConfig()
+	config.AnonymousIDKey = "too-short"
+
+	err := config.Validate(Production)
+
+	assert.ErrorContains(t, err, "the anonymous ID key has to be at least 32 bytes long, got 9")
+}
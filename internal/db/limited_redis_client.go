@@ -18,11 +18,60 @@ type LimitedRedisClient interface {
 	Del(ctx context.Context, keys ...string) *redis.IntCmd
 	// PERSIST key
 	Persist(ctx context.Context, key string) *redis.BoolCmd
+	// EXPIRE key seconds NX
+	ExpireNX(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+	// EXPIRE key seconds GT
+	ExpireGT(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd
+
+	// String commands
+
+	// GET key
+	Get(ctx context.Context, key string) *redis.StringCmd
+	// GETDEL key
+	GetDel(ctx context.Context, key string) *redis.StringCmd
+	// SET key value PX milliseconds
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	// SET key value NX PX milliseconds
+	SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd
+	// INCR key
+	Incr(ctx context.Context, key string) *redis.IntCmd
 
 	// Hash commands
 
 	// HGETALL key
 	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	// HMGET key field [field ...]
+	HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd
 	// HSET key field value [field value ...]
 	HSet(ctx context.Context, key string, values ...any) *redis.IntCmd
+
+	// Set commands
+
+	// SADD key member [member ...]
+	SAdd(ctx context.Context, key string, members ...any) *redis.IntCmd
+	// SREM key member [member ...]
+	SRem(ctx context.Context, key string, members ...any) *redis.IntCmd
+	// SMEMBERS key
+	SMembers(ctx context.Context, key string) *redis.StringSliceCmd
+
+	// Sorted set commands
+
+	// ZADD key score member [score member ...]
+	ZAdd(ctx context.Context, key string, members ...redis.Z) *redis.IntCmd
+	// ZRANGE key min max BYSCORE LIMIT offset count
+	ZRangeByScore(ctx context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd
+	// ZREM key member [member ...]
+	ZRem(ctx context.Context, key string, members ...any) *redis.IntCmd
+	// ZCARD key
+	ZCard(ctx context.Context, key string) *redis.IntCmd
+
+	// Transactions
+
+	// MULTI, the queued commands and EXEC are sent in a single round trip
+	TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error)
+
+	// Scripting commands
+
+	// EVAL script numkeys [key [key ...]] [arg [arg ...]]
+	Eval(ctx context.Context, script string, keys []string, args ...any) *redis.Cmd
 }
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const loginFlowPrefix string = "loginFlow"
+
+// Expired login flows are kept for a while so that the login can be retried with the same redirect
+const loginFlowExpiredLeeway time.Duration = time.Hour
+
+// GetLoginFlow returns the login flow of a state, expired flows are returned together with ErrLoginFlowExpired
+func (r RedisAdapter) GetLoginFlow(ctx context.Context, state string) (models.LoginFlow, error) {
+	return r.decodeLoginFlow(r.rdb.Get(ctx, r.loginFlowKey(state)).Result())
+}
+
+// ConsumeLoginFlow returns and removes the login flow of a state in a single step (GETDEL), so that concurrent
+// callbacks with the same state cannot both use the flow. Expired flows are returned together with ErrLoginFlowExpired.
+func (r RedisAdapter) ConsumeLoginFlow(ctx context.Context, state string) (models.LoginFlow, error) {
+	return r.decodeLoginFlow(r.rdb.GetDel(ctx, r.loginFlowKey(state)).Result())
+}
+
+// SetLoginFlow saves the flow as a single string value so that it can be consumed atomically. The value is
+// encrypted like the tokens since it holds the binding and the code verifier of the flow.
+func (r RedisAdapter) SetLoginFlow(ctx context.Context, flow models.LoginFlow) error {
+	encoded, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	raw := string(encoded)
+	if r.encryptor != nil {
+		raw, err = r.encryptor.Encrypt(raw)
+		if err != nil {
+			return err
+		}
+	}
+	ttl := time.Until(flow.ExpiresAt.Add(loginFlowExpiredLeeway))
+	if ttl <= 0 {
+		return nil
+	}
+	return r.rdb.Set(ctx, r.loginFlowKey(flow.State), raw, ttl).Err()
+}
+
+func (r RedisAdapter) decodeLoginFlow(raw string, err error) (models.LoginFlow, error) {
+	if errors.Is(err, redis.Nil) {
+		return models.LoginFlow{}, gwerrors.ErrLoginFlowNotFound
+	}
+	if err != nil {
+		return models.LoginFlow{}, err
+	}
+	if r.encryptor != nil {
+		raw, err = r.encryptor.Decrypt(raw)
+		if err != nil {
+			return models.LoginFlow{}, err
+		}
+	}
+	output := models.LoginFlow{}
+	err = json.Unmarshal([]byte(raw), &output)
+	if err != nil {
+		return models.LoginFlow{}, err
+	}
+	if output.Expired() {
+		return output, gwerrors.ErrLoginFlowExpired
+	}
+	return output, nil
+}
+
+func (RedisAdapter) loginFlowKey(state string) string {
+	return loginFlowPrefix + ":" + state
+}
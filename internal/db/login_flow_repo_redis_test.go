@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Check that RedisAdapter implements LoginFlowRepository.
+// This test would fail to compile otherwise.
+func TestRedisAdapterIsLoginFlowRepository(t *testing.T) {
+	rdb := RedisAdapter{}
+	_ = models.LoginFlowRepository(rdb)
+}
+
+func TestSetGetConsumeLoginFlow(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	flow, err := models.NewLoginFlow("session-1", "https://renku.example.org", models.SerializableStringSlice{"renku", "gitlab"}, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, flow.GenerateState())
+	flow.ExpiresAt = flow.ExpiresAt.Truncate(time.Second)
+
+	require.NoError(t, adapter.SetLoginFlow(ctx, flow))
+	found, err := adapter.GetLoginFlow(ctx, flow.State)
+	require.NoError(t, err)
+	assert.Equal(t, flow.SessionID, found.SessionID)
+	assert.Equal(t, flow.Binding, found.Binding)
+	assert.Equal(t, flow.RedirectURL, found.RedirectURL)
+	assert.Equal(t, flow.Sequence, found.Sequence)
+	assert.Equal(t, flow.CodeVerifier, found.CodeVerifier)
+	assert.True(t, flow.ExpiresAt.Equal(found.ExpiresAt))
+
+	// A flow can only be consumed once
+	consumed, err := adapter.ConsumeLoginFlow(ctx, flow.State)
+	require.NoError(t, err)
+	assert.Equal(t, flow.SessionID, consumed.SessionID)
+	_, err = adapter.ConsumeLoginFlow(ctx, flow.State)
+	assert.ErrorIs(t, err, gwerrors.ErrLoginFlowNotFound)
+	_, err = adapter.GetLoginFlow(ctx, flow.State)
+	assert.ErrorIs(t, err, gwerrors.ErrLoginFlowNotFound)
+
+	// Expired flows are returned with an error so that the login can be retried
+	flow.ExpiresAt = time.Now().Add(-time.Second)
+	require.NoError(t, adapter.SetLoginFlow(ctx, flow))
+	found, err = adapter.GetLoginFlow(ctx, flow.State)
+	assert.ErrorIs(t, err, gwerrors.ErrLoginFlowExpired)
+	assert.Equal(t, flow.RedirectURL, found.RedirectURL)
+}
+
+func TestSetGetLoginFlowWithEncryption(t *testing.T) {
+	ctx := context.Background()
+	secretKey := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, secretKey)
+	require.NoError(t, err)
+	adapter := NewMockRedisAdapter(WithEncryption(string(secretKey)))
+	flow, err := models.NewLoginFlow("session-1", "https://renku.example.org", models.SerializableStringSlice{"renku"}, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, flow.GenerateState())
+
+	require.NoError(t, adapter.SetLoginFlow(ctx, flow))
+	// The secrets of the flow are not stored in plain text
+	raw, err := adapter.rdb.Get(ctx, adapter.loginFlowKey(flow.State)).Result()
+	require.NoError(t, err)
+	assert.NotContains(t, raw, flow.Binding)
+	assert.NotContains(t, raw, flow.CodeVerifier)
+	found, err := adapter.ConsumeLoginFlow(ctx, flow.State)
+	require.NoError(t, err)
+	assert.Equal(t, flow.Binding, found.Binding)
+	assert.Equal(t, flow.CodeVerifier, found.CodeVerifier)
+}
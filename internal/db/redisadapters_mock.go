@@ -1,10 +1,15 @@
 package db
 
 import (
+	"cmp"
 	"context"
 	"encoding"
 	"fmt"
 	"log"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,11 +18,18 @@ import (
 // Implements the LimitedRedis client struct
 // Only suitable for testing
 // The value set for the IntCmd or similar results is always 1 regardless of how many records were affected
-// Contexts are completely ignored
+// Contexts are completely ignored, only string values expire
 type MockRedisClient struct {
+	lock  sync.Mutex
 	store map[string]any
 }
 
+// mockStringValue is a string value stored in the mock client
+type mockStringValue struct {
+	value     string
+	expiresAt time.Time
+}
+
 type MockRedisAdapterOption func(r *RedisAdapter)
 
 func WithEncryption(key string) MockRedisAdapterOption {
@@ -31,7 +43,7 @@ func WithEncryption(key string) MockRedisAdapterOption {
 }
 
 func NewMockRedisAdapter(options ...MockRedisAdapterOption) RedisAdapter {
-	store := MockRedisClient{map[string]any{}}
+	store := MockRedisClient{store: map[string]any{}}
 	db := RedisAdapter{rdb: &store}
 	for _, opt := range options {
 		opt(&db)
@@ -53,6 +65,8 @@ func convertValuesToMap(values ...any) (map[string]any, error) {
 }
 
 func (m *MockRedisClient) HSet(_ context.Context, key string, values ...any) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	res := redis.IntCmd{}
 	val, err := convertValuesToMap(values...)
 	if err != nil {
@@ -65,6 +79,8 @@ func (m *MockRedisClient) HSet(_ context.Context, key string, values ...any) *re
 }
 
 func (m *MockRedisClient) Del(_ context.Context, keys ...string) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	for _, k := range keys {
 		delete(m.store, k)
 	}
@@ -74,6 +90,8 @@ func (m *MockRedisClient) Del(_ context.Context, keys ...string) *redis.IntCmd {
 }
 
 func (m *MockRedisClient) HGetAll(_ context.Context, key string) *redis.MapStringStringCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	val, found := m.store[key]
 	res := redis.MapStringStringCmd{}
 	res.SetVal(map[string]string{})
@@ -101,14 +119,365 @@ func (m *MockRedisClient) HGetAll(_ context.Context, key string) *redis.MapStrin
 	return &res
 }
 
+func (m *MockRedisClient) HMGet(_ context.Context, key string, fields ...string) *redis.SliceCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.SliceCmd{}
+	output := make([]any, len(fields))
+	valMap, _ := m.store[key].(map[string]any)
+	for i, field := range fields {
+		v, found := valMap[field]
+		if !found {
+			continue
+		}
+		if encodable, ok := v.(encoding.TextMarshaler); ok {
+			raw, err := encodable.MarshalText()
+			if err != nil {
+				res.SetErr(err)
+				return &res
+			}
+			output[i] = string(raw)
+			continue
+		}
+		output[i] = fmt.Sprint(v)
+	}
+	res.SetVal(output)
+	return &res
+}
+
 func (m *MockRedisClient) ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	output := redis.BoolCmd{}
 	output.SetVal(true)
 	return &output
 }
 
 func (m *MockRedisClient) Persist(ctx context.Context, key string) *redis.BoolCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
 	output := redis.BoolCmd{}
 	output.SetVal(true)
 	return &output
 }
+
+func (m *MockRedisClient) ExpireNX(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	output := redis.BoolCmd{}
+	output.SetVal(true)
+	return &output
+}
+
+func (m *MockRedisClient) ExpireGT(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	output := redis.BoolCmd{}
+	output.SetVal(true)
+	return &output
+}
+
+func (m *MockRedisClient) SAdd(_ context.Context, key string, members ...any) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	set, _ := m.store[key].(map[string]struct{})
+	if set == nil {
+		set = map[string]struct{}{}
+		m.store[key] = set
+	}
+	for _, member := range members {
+		set[fmt.Sprint(member)] = struct{}{}
+	}
+	res := redis.IntCmd{}
+	res.SetVal(1)
+	return &res
+}
+
+func (m *MockRedisClient) SRem(_ context.Context, key string, members ...any) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	set, _ := m.store[key].(map[string]struct{})
+	for _, member := range members {
+		delete(set, fmt.Sprint(member))
+	}
+	if set != nil && len(set) == 0 {
+		delete(m.store, key)
+	}
+	res := redis.IntCmd{}
+	res.SetVal(1)
+	return &res
+}
+
+func (m *MockRedisClient) SMembers(_ context.Context, key string) *redis.StringSliceCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	set, _ := m.store[key].(map[string]struct{})
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	slices.Sort(members)
+	res := redis.StringSliceCmd{}
+	res.SetVal(members)
+	return &res
+}
+
+func (m *MockRedisClient) ZAdd(_ context.Context, key string, members ...redis.Z) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sortedSet, _ := m.store[key].(map[string]float64)
+	if sortedSet == nil {
+		sortedSet = map[string]float64{}
+		m.store[key] = sortedSet
+	}
+	for _, member := range members {
+		sortedSet[fmt.Sprint(member.Member)] = member.Score
+	}
+	res := redis.IntCmd{}
+	res.SetVal(1)
+	return &res
+}
+
+// ZRangeByScore only supports numeric and infinite bounds, which are always inclusive
+func (m *MockRedisClient) ZRangeByScore(_ context.Context, key string, opt *redis.ZRangeBy) *redis.StringSliceCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.StringSliceCmd{}
+	parseBound := func(bound string) (float64, error) {
+		switch bound {
+		case "-inf":
+			return math.Inf(-1), nil
+		case "+inf":
+			return math.Inf(1), nil
+		}
+		return strconv.ParseFloat(bound, 64)
+	}
+	minScore, err := parseBound(opt.Min)
+	if err != nil {
+		res.SetErr(err)
+		return &res
+	}
+	maxScore, err := parseBound(opt.Max)
+	if err != nil {
+		res.SetErr(err)
+		return &res
+	}
+	sortedSet, _ := m.store[key].(map[string]float64)
+	members := []string{}
+	for member, score := range sortedSet {
+		if score >= minScore && score <= maxScore {
+			members = append(members, member)
+		}
+	}
+	slices.SortFunc(members, func(a, b string) int {
+		if sortedSet[a] != sortedSet[b] {
+			return cmp.Compare(sortedSet[a], sortedSet[b])
+		}
+		return cmp.Compare(a, b)
+	})
+	if opt.Offset > 0 {
+		members = members[min(int(opt.Offset), len(members)):]
+	}
+	if opt.Count > 0 && int(opt.Count) < len(members) {
+		members = members[:opt.Count]
+	}
+	res.SetVal(members)
+	return &res
+}
+
+func (m *MockRedisClient) ZRem(_ context.Context, key string, members ...any) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sortedSet, _ := m.store[key].(map[string]float64)
+	for _, member := range members {
+		delete(sortedSet, fmt.Sprint(member))
+	}
+	if sortedSet != nil && len(sortedSet) == 0 {
+		delete(m.store, key)
+	}
+	res := redis.IntCmd{}
+	res.SetVal(1)
+	return &res
+}
+
+func (m *MockRedisClient) ZCard(_ context.Context, key string) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	sortedSet, _ := m.store[key].(map[string]float64)
+	res := redis.IntCmd{}
+	res.SetVal(int64(len(sortedSet)))
+	return &res
+}
+
+func (m *MockRedisClient) getString(key string) (string, bool) {
+	val, found := m.store[key].(mockStringValue)
+	if !found {
+		return "", false
+	}
+	if !val.expiresAt.IsZero() && time.Now().After(val.expiresAt) {
+		delete(m.store, key)
+		return "", false
+	}
+	return val.value, true
+}
+
+func (m *MockRedisClient) Get(_ context.Context, key string) *redis.StringCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.StringCmd{}
+	val, found := m.getString(key)
+	if !found {
+		res.SetErr(redis.Nil)
+		return &res
+	}
+	res.SetVal(val)
+	return &res
+}
+
+func (m *MockRedisClient) GetDel(_ context.Context, key string) *redis.StringCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.StringCmd{}
+	val, found := m.getString(key)
+	if !found {
+		res.SetErr(redis.Nil)
+		return &res
+	}
+	delete(m.store, key)
+	res.SetVal(val)
+	return &res
+}
+
+func (m *MockRedisClient) Set(_ context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.StatusCmd{}
+	val := mockStringValue{value: fmt.Sprint(value)}
+	if raw, ok := value.([]byte); ok {
+		val.value = string(raw)
+	}
+	if expiration > 0 {
+		val.expiresAt = time.Now().Add(expiration)
+	}
+	m.store[key] = val
+	res.SetVal("OK")
+	return &res
+}
+
+func (m *MockRedisClient) SetNX(_ context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.BoolCmd{}
+	if _, found := m.getString(key); found {
+		res.SetVal(false)
+		return &res
+	}
+	val := mockStringValue{value: fmt.Sprint(value)}
+	if expiration > 0 {
+		val.expiresAt = time.Now().Add(expiration)
+	}
+	m.store[key] = val
+	res.SetVal(true)
+	return &res
+}
+
+func (m *MockRedisClient) Incr(_ context.Context, key string) *redis.IntCmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.IntCmd{}
+	var current int64
+	if val, found := m.getString(key); found {
+		parsed, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			res.SetErr(err)
+			return &res
+		}
+		current = parsed
+	}
+	current++
+	m.store[key] = mockStringValue{value: strconv.FormatInt(current, 10)}
+	res.SetVal(current)
+	return &res
+}
+
+// Eval only supports the scripts used by the adapter
+func (m *MockRedisClient) Eval(_ context.Context, script string, keys []string, args ...any) *redis.Cmd {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	res := redis.Cmd{}
+	switch script {
+	case releaseLockScript:
+		val, found := m.getString(keys[0])
+		if found && val == fmt.Sprint(args[0]) {
+			delete(m.store, keys[0])
+			res.SetVal(int64(1))
+		} else {
+			res.SetVal(int64(0))
+		}
+	case setTokensWithLockScript:
+		val, found := m.getString(keys[0])
+		if !found || val != fmt.Sprint(args[0]) {
+			res.SetVal(int64(0))
+			return &res
+		}
+		arg := 1
+		for _, key := range keys[1:] {
+			count := args[arg+1].(int)
+			hash, err := convertValuesToMap(args[arg+2 : arg+2+count]...)
+			if err != nil {
+				res.SetErr(err)
+				return &res
+			}
+			m.store[key] = hash
+			arg += 2 + count
+		}
+		res.SetVal(int64(1))
+	default:
+		res.SetErr(fmt.Errorf("script is not supported by the mock client"))
+	}
+	return &res
+}
+
+// mockPipeline runs the commands of a transaction directly on the mock client,
+// only the commands that the adapter sends in transactions are supported
+type mockPipeline struct {
+	redis.Pipeliner
+	client *MockRedisClient
+}
+
+func (p mockPipeline) HSet(ctx context.Context, key string, values ...any) *redis.IntCmd {
+	return p.client.HSet(ctx, key, values...)
+}
+
+func (p mockPipeline) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	return p.client.Del(ctx, keys...)
+}
+
+func (p mockPipeline) ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd {
+	return p.client.ExpireAt(ctx, key, tm)
+}
+
+func (p mockPipeline) Persist(ctx context.Context, key string) *redis.BoolCmd {
+	return p.client.Persist(ctx, key)
+}
+
+func (p mockPipeline) ExpireNX(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	return p.client.ExpireNX(ctx, key, expiration)
+}
+
+func (p mockPipeline) ExpireGT(ctx context.Context, key string, expiration time.Duration) *redis.BoolCmd {
+	return p.client.ExpireGT(ctx, key, expiration)
+}
+
+func (p mockPipeline) SAdd(ctx context.Context, key string, members ...any) *redis.IntCmd {
+	return p.client.SAdd(ctx, key, members...)
+}
+
+func (p mockPipeline) SRem(ctx context.Context, key string, members ...any) *redis.IntCmd {
+	return p.client.SRem(ctx, key, members...)
+}
+
+// TxPipelined runs the queued commands one by one, the transaction is not atomic in the mock client
+func (m *MockRedisClient) TxPipelined(_ context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	return nil, fn(mockPipeline{client: m})
+}
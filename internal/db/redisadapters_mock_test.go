@@ -10,7 +10,7 @@ import (
 
 func TestHGetAll(t *testing.T) {
 	ctx := context.Background()
-	store := MockRedisClient{map[string]any{}}
+	store := MockRedisClient{store: map[string]any{}}
 	res := store.HGetAll(ctx, "test")
 	val, err := res.Result()
 	require.NoError(t, err)
@@ -19,7 +19,7 @@ func TestHGetAll(t *testing.T) {
 
 func TestHSetDel(t *testing.T) {
 	ctx := context.Background()
-	store := MockRedisClient{map[string]any{}}
+	store := MockRedisClient{store: map[string]any{}}
 	res1 := store.HSet(ctx, "test", "f1", "v1", "f2", "v2")
 	_, err := res1.Result()
 	require.NoError(t, err)
@@ -35,3 +35,20 @@ func TestHSetDel(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, 0, len(val))
 }
+
+func TestSAddSRemSMembers(t *testing.T) {
+	ctx := context.Background()
+	store := MockRedisClient{store: map[string]any{}}
+	_, err := store.SAdd(ctx, "test", "m1", "m2").Result()
+	require.NoError(t, err)
+	_, err = store.SAdd(ctx, "test", "m1").Result()
+	require.NoError(t, err)
+	val, err := store.SMembers(ctx, "test").Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"m1", "m2"}, val)
+	_, err = store.SRem(ctx, "test", "m1", "m2").Result()
+	require.NoError(t, err)
+	val, err = store.SMembers(ctx, "test").Result()
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(val))
+}
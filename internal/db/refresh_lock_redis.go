@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshLockPrefix  string = "tokenRefreshLock"
+	refreshFencePrefix string = "tokenRefreshFence"
+	// refreshFenceTTL is how long the fencing counter of a token ID is kept after the last lock,
+	// it has to be much longer than any lock so that fencing tokens are never reused by live holders
+	refreshFenceTTL time.Duration = 24 * time.Hour
+)
+
+// releaseLockScript deletes a lock only if it is still held with the given fencing token
+const releaseLockScript string = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end`
+
+// setTokensWithLockScript saves tokens only if the lock in KEYS[1] is still held with the fencing token in ARGV[1].
+// The other keys are the token hashes, for each of them ARGV has the unix time at which it expires (0 to keep it
+// forever), the number of hash arguments and the hash arguments.
+const setTokensWithLockScript string = `if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local arg = 2
+for i = 2, #KEYS do
+	local expireAt = tonumber(ARGV[arg])
+	local count = tonumber(ARGV[arg + 1])
+	redis.call("HSET", KEYS[i], unpack(ARGV, arg + 2, arg + 1 + count))
+	if expireAt > 0 then
+		redis.call("EXPIREAT", KEYS[i], expireAt)
+	else
+		redis.call("PERSIST", KEYS[i])
+	end
+	arg = arg + 2 + count
+end
+return 1`
+
+// AcquireRefreshLock tries to acquire the lock for refreshing the tokens with the given ID (SET NX PX).
+// The returned fencing token increases with every attempt and identifies the holder of the lock.
+func (r RedisAdapter) AcquireRefreshLock(ctx context.Context, tokenID string, ttl time.Duration) (int64, bool, error) {
+	fenceKey := r.refreshFenceKey(tokenID)
+	fencingToken, err := r.rdb.Incr(ctx, fenceKey).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	err = r.rdb.ExpireAt(ctx, fenceKey, time.Now().Add(refreshFenceTTL)).Err()
+	if err != nil {
+		return 0, false, err
+	}
+	acquired, err := r.rdb.SetNX(ctx, r.refreshLockKey(tokenID), strconv.FormatInt(fencingToken, 10), ttl).Result()
+	if err != nil {
+		return 0, false, err
+	}
+	return fencingToken, acquired, nil
+}
+
+// SetTokensWithRefreshLock saves the refreshed tokens with the given ID only if the lock for refreshing them is
+// still held with the fencing token. The check and the writes run in a single script, so a holder whose lock
+// expired during the refresh cannot overwrite the tokens saved by the next holder.
+func (r RedisAdapter) SetTokensWithRefreshLock(ctx context.Context, tokenID string, fencingToken int64, tokens ...models.AuthToken) (bool, error) {
+	keys := []string{r.refreshLockKey(tokenID)}
+	args := []any{strconv.FormatInt(fencingToken, 10)}
+	for _, token := range tokens {
+		err := validateTokenType(token.Type)
+		if err != nil {
+			return false, err
+		}
+		encToken, err := token.Encrypt(r.encryptor)
+		if err != nil {
+			return false, err
+		}
+		var expireAt int64
+		if !token.ExpiresAt.IsZero() {
+			expireAt = token.ExpiresAt.Add(tokenExpiresAtLeeway).Unix()
+		}
+		fields := r.serializeStruct(encToken)
+		keys = append(keys, r.getTokenKey(token))
+		args = append(args, expireAt, len(fields))
+		args = append(args, fields...)
+	}
+	saved, err := r.rdb.Eval(ctx, setTokensWithLockScript, keys, args...).Int64()
+	if err != nil {
+		return false, err
+	}
+	return saved == 1, nil
+}
+
+// IsRefreshLocked checks if another holder refreshes the tokens with the given ID
+func (r RedisAdapter) IsRefreshLocked(ctx context.Context, tokenID string) (bool, error) {
+	err := r.rdb.Get(ctx, r.refreshLockKey(tokenID)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReleaseRefreshLock releases the lock for refreshing the tokens with the given ID if it is still held
+// with the fencing token, a lock that expired and was acquired by someone else is left untouched
+func (r RedisAdapter) ReleaseRefreshLock(ctx context.Context, tokenID string, fencingToken int64) error {
+	return r.rdb.Eval(ctx, releaseLockScript, []string{r.refreshLockKey(tokenID)}, strconv.FormatInt(fencingToken, 10)).Err()
+}
+
+func (RedisAdapter) refreshLockKey(tokenID string) string {
+	return refreshLockPrefix + ":" + tokenID
+}
+
+func (RedisAdapter) refreshFenceKey(tokenID string) string {
+	return refreshFencePrefix + ":" + tokenID
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Check that RedisAdapter implements TokenRefreshLocker.
+// This test would fail to compile otherwise.
+func TestRedisAdapterIsTokenRefreshLocker(t *testing.T) {
+	rdb := RedisAdapter{}
+	_ = models.TokenRefreshLocker(rdb)
+}
+
+func TestRefreshLock(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+
+	fencingToken, acquired, err := adapter.AcquireRefreshLock(ctx, "token-1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	otherFencingToken, acquired, err := adapter.AcquireRefreshLock(ctx, "token-1", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, acquired)
+	assert.Greater(t, otherFencingToken, fencingToken)
+	_, acquired, err = adapter.AcquireRefreshLock(ctx, "token-2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+
+	locked, err := adapter.IsRefreshLocked(ctx, "token-1")
+	require.NoError(t, err)
+	assert.True(t, locked)
+	token := models.AuthToken{ID: "token-1", Type: models.AccessTokenType, Value: "fresh", ExpiresAt: time.Now().Add(time.Hour)}
+	saved, err := adapter.SetTokensWithRefreshLock(ctx, "token-1", otherFencingToken, token)
+	require.NoError(t, err)
+	assert.False(t, saved)
+	_, err = adapter.GetAccessToken(ctx, "token-1")
+	assert.Error(t, err)
+	saved, err = adapter.SetTokensWithRefreshLock(ctx, "token-1", fencingToken, token)
+	require.NoError(t, err)
+	assert.True(t, saved)
+	accessToken, err := adapter.GetAccessToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", accessToken.Value)
+
+	// Only the holder can release the lock
+	require.NoError(t, adapter.ReleaseRefreshLock(ctx, "token-1", otherFencingToken))
+	locked, err = adapter.IsRefreshLocked(ctx, "token-1")
+	require.NoError(t, err)
+	assert.True(t, locked)
+	require.NoError(t, adapter.ReleaseRefreshLock(ctx, "token-1", fencingToken))
+	locked, err = adapter.IsRefreshLocked(ctx, "token-1")
+	require.NoError(t, err)
+	assert.False(t, locked)
+}
+
+func TestRefreshLockExpires(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+
+	fencingToken, acquired, err := adapter.AcquireRefreshLock(ctx, "token-1", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, acquired)
+	time.Sleep(20 * time.Millisecond)
+	newFencingToken, acquired, err := adapter.AcquireRefreshLock(ctx, "token-1", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, acquired)
+	// The holder of the expired lock cannot overwrite the tokens of the new holder
+	token := models.AuthToken{ID: "token-1", Type: models.RefreshTokenType, Value: "stale", ExpiresAt: time.Now().Add(time.Hour)}
+	saved, err := adapter.SetTokensWithRefreshLock(ctx, "token-1", fencingToken, token)
+	require.NoError(t, err)
+	assert.False(t, saved)
+	token.Value = "fresh"
+	saved, err = adapter.SetTokensWithRefreshLock(ctx, "token-1", newFencingToken, token)
+	require.NoError(t, err)
+	assert.True(t, saved)
+	refreshToken, err := adapter.GetRefreshToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", refreshToken.Value)
+}
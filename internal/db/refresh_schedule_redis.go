@@ -0,0 +1,37 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// refreshScheduleKey is the sorted set of token IDs scored by the unix time at which they should be refreshed
+const refreshScheduleKey string = "tokenRefreshSchedule"
+
+// ScheduleTokenRefresh sets when the tokens with the given ID should be refreshed
+func (r RedisAdapter) ScheduleTokenRefresh(ctx context.Context, tokenID string, refreshAt time.Time) error {
+	return r.rdb.ZAdd(ctx, refreshScheduleKey, redis.Z{Score: float64(refreshAt.Unix()), Member: tokenID}).Err()
+}
+
+// GetDueTokenRefreshes returns up to limit token IDs which should be refreshed before the given time,
+// the most urgent first
+func (r RedisAdapter) GetDueTokenRefreshes(ctx context.Context, before time.Time, limit int) ([]string, error) {
+	return r.rdb.ZRangeByScore(ctx, refreshScheduleKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(before.Unix(), 10),
+		Count: int64(limit),
+	}).Result()
+}
+
+// UnscheduleTokenRefresh stops the scheduled refreshes of the tokens with the given ID
+func (r RedisAdapter) UnscheduleTokenRefresh(ctx context.Context, tokenID string) error {
+	return r.rdb.ZRem(ctx, refreshScheduleKey, tokenID).Err()
+}
+
+// CountScheduledTokenRefreshes returns the number of token IDs with a scheduled refresh
+func (r RedisAdapter) CountScheduledTokenRefreshes(ctx context.Context) (int64, error) {
+	return r.rdb.ZCard(ctx, refreshScheduleKey).Result()
+}
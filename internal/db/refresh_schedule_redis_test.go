@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Check that RedisAdapter implements TokenRefreshSchedule.
+// This test would fail to compile otherwise.
+func TestRedisAdapterIsTokenRefreshSchedule(t *testing.T) {
+	rdb := RedisAdapter{}
+	_ = models.TokenRefreshSchedule(rdb)
+}
+
+func TestRefreshSchedule(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	now := time.Now()
+
+	require.NoError(t, adapter.ScheduleTokenRefresh(ctx, "token-1", now.Add(10*time.Minute)))
+	require.NoError(t, adapter.ScheduleTokenRefresh(ctx, "token-2", now.Add(2*time.Minute)))
+	require.NoError(t, adapter.ScheduleTokenRefresh(ctx, "token-3", now.Add(time.Minute)))
+	count, err := adapter.CountScheduledTokenRefreshes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	tokenIDs, err := adapter.GetDueTokenRefreshes(ctx, now.Add(5*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-3", "token-2"}, tokenIDs)
+	tokenIDs, err = adapter.GetDueTokenRefreshes(ctx, now.Add(time.Hour), 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-3"}, tokenIDs)
+
+	// Scheduling again moves the refresh
+	require.NoError(t, adapter.ScheduleTokenRefresh(ctx, "token-3", now.Add(time.Hour)))
+	require.NoError(t, adapter.UnscheduleTokenRefresh(ctx, "token-2"))
+	tokenIDs, err = adapter.GetDueTokenRefreshes(ctx, now.Add(30*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1"}, tokenIDs)
+	count, err = adapter.CountScheduledTokenRefreshes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
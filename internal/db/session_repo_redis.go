@@ -2,13 +2,20 @@ package db
 
 import (
 	"context"
+	"errors"
+	"slices"
+	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
-	sessionPrefix string = "session"
+	sessionPrefix                 string = "session"
+	userSessionsPrefix            string = "userSessions"
+	providerSessionSessionsPrefix string = "providerSessionSessions"
+	tokenSessionsPrefix           string = "tokenSessions"
 )
 
 func (r RedisAdapter) GetSession(ctx context.Context, sessionID string) (models.Session, error) {
@@ -32,26 +39,185 @@ func (r RedisAdapter) GetSession(ctx context.Context, sessionID string) (models.
 	return output, nil
 }
 
+// SetSession writes the session and updates the index sets it is part of. The session is saved
+// on every proxied request so all the writes are sent in a single transaction, which also removes
+// the session from the index sets of the tokens and provider sessions it does not reference anymore.
 func (r RedisAdapter) SetSession(ctx context.Context, session models.Session) error {
 	key := r.sessionKey(session.ID)
-	err := r.rdb.HSet(
-		ctx,
-		key,
-		r.serializeStruct(session)...,
-	).Err()
+	staleIndexKeys, err := r.staleSessionIndexKeys(ctx, session)
 	if err != nil {
 		return err
 	}
-	return r.rdb.ExpireAt(ctx, key, session.ExpiresAt.Add(tokenExpiresAtLeeway)).Err()
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(
+			ctx,
+			key,
+			r.serializeStruct(session)...,
+		)
+		pipe.ExpireAt(ctx, key, session.ExpiresAt.Add(tokenExpiresAtLeeway))
+		for _, indexKey := range r.sessionIndexKeys(session) {
+			r.addToSessionIndex(ctx, pipe, indexKey, session)
+		}
+		for _, indexKey := range staleIndexKeys {
+			pipe.SRem(ctx, indexKey, session.ID)
+		}
+		return nil
+	})
+	return err
+}
+
+// staleSessionIndexKeys returns the keys of the index sets that the stored version of a session is part of
+// but the session itself is not, i.e. after the tokens of a provider were disconnected
+func (r RedisAdapter) staleSessionIndexKeys(ctx context.Context, session models.Session) ([]string, error) {
+	fields := []string{"UserID", "TokenIDs", "ProviderSessionIDs"}
+	values, err := r.rdb.HMGet(ctx, r.sessionKey(session.ID), fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]string{}
+	for i, value := range values {
+		if value, ok := value.(string); ok {
+			raw[fields[i]] = value
+		}
+	}
+	stored := models.Session{}
+	err = r.deserializeToStruct(raw, &stored)
+	if errors.Is(err, gwerrors.ErrMissingDBResource) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	current := r.sessionIndexKeys(session)
+	output := []string{}
+	for _, indexKey := range r.sessionIndexKeys(stored) {
+		if !slices.Contains(current, indexKey) {
+			output = append(output, indexKey)
+		}
+	}
+	return output, nil
 }
 
 func (r RedisAdapter) RemoveSession(ctx context.Context, sessionID string) error {
-	return r.rdb.Del(
-		ctx,
-		r.sessionKey(sessionID),
-	).Err()
+	session, err := r.GetSession(ctx, sessionID)
+	if err != nil && !errors.Is(err, gwerrors.ErrSessionNotFound) {
+		return err
+	}
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(
+			ctx,
+			r.sessionKey(sessionID),
+		)
+		for _, indexKey := range r.sessionIndexKeys(session) {
+			pipe.SRem(ctx, indexKey, sessionID)
+		}
+		return nil
+	})
+	return err
+}
+
+// GetSessionIDsByUser returns the IDs of the sessions of a user. The IDs of sessions which have
+// expired in the meantime can be part of the result.
+func (r RedisAdapter) GetSessionIDsByUser(ctx context.Context, userID string) ([]string, error) {
+	return r.rdb.SMembers(ctx, r.userSessionsKey(userID)).Result()
+}
+
+// GetSessionIDsByProviderSession returns the IDs of the sessions that were logged in with the given
+// session at an identity provider. The IDs of sessions which have expired in the meantime can be part of the result.
+func (r RedisAdapter) GetSessionIDsByProviderSession(ctx context.Context, providerID, providerSessionID string) ([]string, error) {
+	return r.rdb.SMembers(ctx, r.providerSessionSessionsKey(providerID, providerSessionID)).Result()
+}
+
+// GetSessionIDsByToken returns the IDs of the sessions which reference the tokens with the given ID.
+// The IDs of sessions which have expired in the meantime can be part of the result.
+func (r RedisAdapter) GetSessionIDsByToken(ctx context.Context, tokenID string) ([]string, error) {
+	return r.rdb.SMembers(ctx, r.tokenSessionsKey(tokenID)).Result()
+}
+
+// GetSessionsByUser returns the sessions of a user, the IDs of sessions which do not exist
+// anymore are removed from the index
+func (r RedisAdapter) GetSessionsByUser(ctx context.Context, userID string) ([]models.Session, error) {
+	sessionIDs, err := r.GetSessionIDsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	output := make([]models.Session, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		session, err := r.GetSession(ctx, sessionID)
+		if errors.Is(err, gwerrors.ErrSessionNotFound) {
+			err = r.rdb.SRem(ctx, r.userSessionsKey(userID), sessionID).Err()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, session)
+	}
+	return output, nil
+}
+
+// RemoveSessionsByUser removes all the sessions of a user
+func (r RedisAdapter) RemoveSessionsByUser(ctx context.Context, userID string) error {
+	sessionIDs, err := r.GetSessionIDsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		err = r.RemoveSession(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addToSessionIndex queues adding the session to an index set, the set expires together with the last session in it
+func (r RedisAdapter) addToSessionIndex(ctx context.Context, pipe redis.Pipeliner, indexKey string, session models.Session) {
+	pipe.SAdd(ctx, indexKey, session.ID)
+	if session.ExpiresAt.IsZero() {
+		pipe.Persist(ctx, indexKey)
+		return
+	}
+	ttl := time.Until(session.ExpiresAt.Add(tokenExpiresAtLeeway))
+	// NOTE: NX sets the expiry of a new set, GT only ever extends the expiry of an existing set
+	pipe.ExpireNX(ctx, indexKey, ttl)
+	pipe.ExpireGT(ctx, indexKey, ttl)
+}
+
+// sessionIndexKeys returns the keys of all the index sets that a session is part of
+func (r RedisAdapter) sessionIndexKeys(session models.Session) []string {
+	output := []string{}
+	if session.UserID != "" {
+		output = append(output, r.userSessionsKey(session.UserID))
+	}
+	for providerID, providerSessionID := range session.ProviderSessionIDs {
+		if providerSessionID != "" {
+			output = append(output, r.providerSessionSessionsKey(providerID, providerSessionID))
+		}
+	}
+	for _, tokenID := range session.TokenIDs {
+		if tokenID != "" {
+			output = append(output, r.tokenSessionsKey(tokenID))
+		}
+	}
+	return output
 }
 
 func (RedisAdapter) sessionKey(sessionID string) string {
 	return sessionPrefix + ":" + sessionID
 }
+
+func (RedisAdapter) userSessionsKey(userID string) string {
+	return userSessionsPrefix + ":" + userID
+}
+
+func (RedisAdapter) providerSessionSessionsKey(providerID, providerSessionID string) string {
+	return providerSessionSessionsPrefix + ":" + providerID + ":" + providerSessionID
+}
+
+func (RedisAdapter) tokenSessionsKey(tokenID string) string {
+	return tokenSessionsPrefix + ":" + tokenID
+}
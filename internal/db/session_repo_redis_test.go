@@ -3,10 +3,12 @@ package db
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -44,3 +46,155 @@ func TestRemoveSession(t *testing.T) {
 	_, err = adapter.GetSession(ctx, mySession.ID)
 	assert.ErrorIs(t, err, gwerrors.ErrSessionNotFound)
 }
+
+func TestSessionIndexes(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	sm := sessions.NewSessionMaker()
+	session1, err := sm.NewSession()
+	require.NoError(t, err)
+	session1.UserID = "user-1"
+	session1.ProviderSessionIDs = models.SerializableMap{"renku": "sid-1"}
+	session1.TokenIDs = models.SerializableMap{"renku": "renku:user-1"}
+	session2, err := sm.NewSession()
+	require.NoError(t, err)
+	session2.UserID = "user-1"
+	require.NoError(t, adapter.SetSession(ctx, session1))
+	require.NoError(t, adapter.SetSession(ctx, session2))
+
+	sessionIDs, err := adapter.GetSessionIDsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{session1.ID, session2.ID}, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByProviderSession(ctx, "renku", "sid-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session1.ID}, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByToken(ctx, "renku:user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session1.ID}, sessionIDs)
+
+	require.NoError(t, adapter.RemoveSession(ctx, session1.ID))
+	sessionIDs, err = adapter.GetSessionIDsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session2.ID}, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByProviderSession(ctx, "renku", "sid-1")
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByToken(ctx, "renku:user-1")
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+}
+
+func TestGetRemoveSessionsByUser(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	sm := sessions.NewSessionMaker()
+	userSessions := []models.Session{}
+	for range 3 {
+		session, err := sm.NewSession()
+		require.NoError(t, err)
+		session.UserID = "user-1"
+		require.NoError(t, adapter.SetSession(ctx, session))
+		userSessions = append(userSessions, session)
+	}
+	otherSession, err := sm.NewSession()
+	require.NoError(t, err)
+	otherSession.UserID = "user-2"
+	require.NoError(t, adapter.SetSession(ctx, otherSession))
+	// A session which is gone but still in the index is dropped from the index
+	require.NoError(t, adapter.rdb.Del(ctx, adapter.sessionKey(userSessions[0].ID)).Err())
+
+	found, err := adapter.GetSessionsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, userSessions[1:], found)
+	sessionIDs, err := adapter.GetSessionIDsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Len(t, sessionIDs, 2)
+
+	require.NoError(t, adapter.RemoveSessionsByUser(ctx, "user-1"))
+	found, err = adapter.GetSessionsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	_, err = adapter.GetSession(ctx, otherSession.ID)
+	assert.NoError(t, err)
+}
+
+// roundTripCounter counts the requests sent to redis, a transaction is sent in a single request
+type roundTripCounter struct {
+	LimitedRedisClient
+	roundTrips int
+}
+
+func (c *roundTripCounter) TxPipelined(ctx context.Context, fn func(redis.Pipeliner) error) ([]redis.Cmder, error) {
+	c.roundTrips++
+	return c.LimitedRedisClient.TxPipelined(ctx, fn)
+}
+
+func (c *roundTripCounter) HSet(ctx context.Context, key string, values ...any) *redis.IntCmd {
+	c.roundTrips++
+	return c.LimitedRedisClient.HSet(ctx, key, values...)
+}
+
+func (c *roundTripCounter) SAdd(ctx context.Context, key string, members ...any) *redis.IntCmd {
+	c.roundTrips++
+	return c.LimitedRedisClient.SAdd(ctx, key, members...)
+}
+
+func (c *roundTripCounter) HMGet(ctx context.Context, key string, fields ...string) *redis.SliceCmd {
+	c.roundTrips++
+	return c.LimitedRedisClient.HMGet(ctx, key, fields...)
+}
+
+func (c *roundTripCounter) ExpireAt(ctx context.Context, key string, tm time.Time) *redis.BoolCmd {
+	c.roundTrips++
+	return c.LimitedRedisClient.ExpireAt(ctx, key, tm)
+}
+
+func TestSetSessionRoundTrips(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	counter := roundTripCounter{LimitedRedisClient: adapter.rdb}
+	adapter.rdb = &counter
+	sm := sessions.NewSessionMaker()
+	session, err := sm.NewSession()
+	require.NoError(t, err)
+	session.UserID = "user-1"
+	session.ProviderSessionIDs = models.SerializableMap{"renku": "sid-1"}
+	session.TokenIDs = models.SerializableMap{"renku": "renku:user-1"}
+
+	require.NoError(t, adapter.SetSession(ctx, session))
+
+	// The indexed fields of the stored session are read, then all the writes are sent in a transaction
+	assert.Equal(t, 2, counter.roundTrips)
+	sessionIDs, err := adapter.GetSessionIDsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session.ID}, sessionIDs)
+}
+
+func TestSetSessionRemovesStaleIndexEntries(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	sm := sessions.NewSessionMaker()
+	session, err := sm.NewSession()
+	require.NoError(t, err)
+	session.UserID = "user-1"
+	session.ProviderSessionIDs = models.SerializableMap{"renku": "sid-1", "gitlab": "sid-2"}
+	session.TokenIDs = models.SerializableMap{"renku": "renku:user-1", "gitlab": "gitlab:user-1"}
+	require.NoError(t, adapter.SetSession(ctx, session))
+	sessionIDs, err := adapter.GetSessionIDsByToken(ctx, "gitlab:user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session.ID}, sessionIDs)
+
+	// The tokens of gitlab are disconnected from the session
+	delete(session.TokenIDs, "gitlab")
+	delete(session.ProviderSessionIDs, "gitlab")
+	require.NoError(t, adapter.SetSession(ctx, session))
+	sessionIDs, err = adapter.GetSessionIDsByToken(ctx, "gitlab:user-1")
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByProviderSession(ctx, "gitlab", "sid-2")
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+	sessionIDs, err = adapter.GetSessionIDsByToken(ctx, "renku:user-1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{session.ID}, sessionIDs)
+}
@@ -8,12 +8,15 @@ import (
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
 	accessTokenPrefix  string = "accessToken"
 	refreshTokenPrefix string = "refreshToken"
 	idTokenPrefix      string = "idToken"
+	// The sets of the IDs of the tokens exchanged for an access token
+	exchangedTokensPrefix string = "exchangedTokens"
 )
 
 const tokenExpiresAtLeeway time.Duration = 10 * time.Second
@@ -57,6 +60,65 @@ func (r RedisAdapter) SetIDToken(ctx context.Context, token models.AuthToken) er
 	return r.setAuthToken(ctx, token)
 }
 
+// RemoveAccessToken removes an access token from Redis
+func (r RedisAdapter) RemoveAccessToken(ctx context.Context, tokenID string) error {
+	return r.rdb.Del(ctx, r.accessTokenKey(tokenID)).Err()
+}
+
+// RemoveRefreshToken removes a refresh token from Redis
+func (r RedisAdapter) RemoveRefreshToken(ctx context.Context, tokenID string) error {
+	return r.rdb.Del(ctx, r.refreshTokenKey(tokenID)).Err()
+}
+
+// RemoveIDToken removes an ID token from Redis
+func (r RedisAdapter) RemoveIDToken(ctx context.Context, tokenID string) error {
+	return r.rdb.Del(ctx, r.idTokenKey(tokenID)).Err()
+}
+
+// SetExchangedToken saves a token exchanged for the access token with the given ID and adds its ID to the set
+// of exchanged tokens of the access token, in a single transaction
+func (r RedisAdapter) SetExchangedToken(ctx context.Context, subjectTokenID string, token models.AuthToken) error {
+	if token.Type != models.AccessTokenType {
+		return fmt.Errorf("token is not of the right type")
+	}
+	encToken, err := token.Encrypt(r.encryptor)
+	if err != nil {
+		return err
+	}
+	key := r.accessTokenKey(token.ID)
+	setKey := r.exchangedTokensKey(subjectTokenID)
+	_, err = r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, r.serializeStruct(encToken)...)
+		pipe.SAdd(ctx, setKey, token.ID)
+		if token.ExpiresAt.IsZero() {
+			pipe.Persist(ctx, key)
+			pipe.Persist(ctx, setKey)
+			return nil
+		}
+		pipe.ExpireAt(ctx, key, token.ExpiresAt.Add(tokenExpiresAtLeeway))
+		ttl := time.Until(token.ExpiresAt.Add(tokenExpiresAtLeeway))
+		// NOTE: NX sets the expiry of a new set, GT only ever extends the expiry of an existing set
+		pipe.ExpireNX(ctx, setKey, ttl)
+		pipe.ExpireGT(ctx, setKey, ttl)
+		return nil
+	})
+	return err
+}
+
+// RemoveExchangedTokens removes the tokens exchanged for the access token with the given ID together with their set
+func (r RedisAdapter) RemoveExchangedTokens(ctx context.Context, subjectTokenID string) error {
+	setKey := r.exchangedTokensKey(subjectTokenID)
+	tokenIDs, err := r.rdb.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{setKey}
+	for _, tokenID := range tokenIDs {
+		keys = append(keys, r.accessTokenKey(tokenID))
+	}
+	return r.rdb.Del(ctx, keys...).Err()
+}
+
 func (RedisAdapter) accessTokenKey(tokenID string) string {
 	return accessTokenPrefix + ":" + tokenID
 }
@@ -69,6 +131,10 @@ func (RedisAdapter) idTokenKey(tokenID string) string {
 	return idTokenPrefix + ":" + tokenID
 }
 
+func (RedisAdapter) exchangedTokensKey(tokenID string) string {
+	return exchangedTokensPrefix + ":" + tokenID
+}
+
 func (r RedisAdapter) getTokenKey(token models.AuthToken) string {
 	switch token.Type {
 	case models.AccessTokenType:
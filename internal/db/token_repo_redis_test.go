@@ -70,3 +70,53 @@ func TestSetGetAccessTokenWithEncryption(t *testing.T) {
 		cmp.Diff(myAccessToken, accessToken, compareOptions...),
 	)
 }
+
+func TestRemoveTokens(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	token := models.AuthToken{
+		ID:        "12345",
+		Value:     "6789",
+		ExpiresAt: time.Now().Add(time.Hour * 24),
+	}
+	token.Type = models.AccessTokenType
+	require.NoError(t, adapter.SetAccessToken(ctx, token))
+	token.Type = models.RefreshTokenType
+	require.NoError(t, adapter.SetRefreshToken(ctx, token))
+	token.Type = models.IDTokenType
+	require.NoError(t, adapter.SetIDToken(ctx, token))
+
+	require.NoError(t, adapter.RemoveAccessToken(ctx, token.ID))
+	require.NoError(t, adapter.RemoveRefreshToken(ctx, token.ID))
+	require.NoError(t, adapter.RemoveIDToken(ctx, token.ID))
+	_, err := adapter.GetAccessToken(ctx, token.ID)
+	assert.Error(t, err)
+	_, err = adapter.GetRefreshToken(ctx, token.ID)
+	assert.Error(t, err)
+	_, err = adapter.GetIDToken(ctx, token.ID)
+	assert.Error(t, err)
+}
+
+func TestSetRemoveExchangedTokens(t *testing.T) {
+	ctx := context.Background()
+	adapter := NewMockRedisAdapter()
+	for _, audience := range []string{"data-service", "search"} {
+		require.NoError(t, adapter.SetExchangedToken(ctx, "renku:user-1", models.AuthToken{
+			ID:        "renku:user-1:exchanged:" + audience + ":",
+			Type:      models.AccessTokenType,
+			Value:     audience + "-token",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+	}
+	token, err := adapter.GetAccessToken(ctx, "renku:user-1:exchanged:search:")
+	require.NoError(t, err)
+	assert.Equal(t, "search-token", token.Value)
+
+	require.NoError(t, adapter.RemoveExchangedTokens(ctx, "renku:user-1"))
+	for _, audience := range []string{"data-service", "search"} {
+		_, err = adapter.GetAccessToken(ctx, "renku:user-1:exchanged:"+audience+":")
+		assert.Error(t, err)
+	}
+	// Removing the exchanged tokens of an access token without any is a no-op
+	assert.NoError(t, adapter.RemoveExchangedTokens(ctx, "renku:user-2"))
+}
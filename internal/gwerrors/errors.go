@@ -6,6 +6,8 @@ import "fmt"
 var ErrSessionParse = fmt.Errorf("cannot parse session from context")
 var ErrSessionNotFound = fmt.Errorf("cannot find the session")
 var ErrSessionExpired = fmt.Errorf("the session has expired")
+var ErrLoginFlowNotFound = fmt.Errorf("cannot find the login flow")
+var ErrLoginFlowExpired = fmt.Errorf("the login flow has expired")
 var ErrTokenParse = fmt.Errorf("cannot parse token from context")
 var ErrTokenNotFound = fmt.Errorf("the token cannot be found")
 var ErrTokenExpired = fmt.Errorf("the token has expired")
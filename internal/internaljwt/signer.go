@@ -0,0 +1,242 @@
+// Package internaljwt signs the short-lived JWTs that the gateway sends to the backend services
+// instead of the tokens of the identity provider, and publishes the keys needed to verify them.
+package internaljwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultTTL time.Duration = 5 * time.Minute
+	// How long clients may cache the published keys, rotated keys should be published for longer than that
+	// before they are used for signing
+	jwksMaxAge time.Duration = 5 * time.Minute
+)
+
+var defaultClaims = []string{"name", "email", "preferred_username"}
+
+// Signer signs internal JWTs with the first of its keys, all the keys are published in its JWKS
+type Signer struct {
+	issuer         string
+	keyFiles       []string
+	reloadInterval time.Duration
+	ttl            time.Duration
+	claims         []string
+
+	lock sync.RWMutex
+	keys []jose.JSONWebKey
+}
+
+// Sign returns a JWT for the user with the given audience. The claims that the signer is configured
+// to forward are copied from the claims of the user's access token when they are present.
+func (s *Signer) Sign(subject, audience string, userClaims map[string]any) (string, error) {
+	s.lock.RLock()
+	if len(s.keys) == 0 {
+		s.lock.RUnlock()
+		return "", fmt.Errorf("no signing keys are loaded")
+	}
+	signingKey := s.keys[0]
+	s.lock.RUnlock()
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.SignatureAlgorithm(signingKey.Algorithm), Key: signingKey},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	registeredClaims := jwt.Claims{
+		Issuer:    s.issuer,
+		Subject:   subject,
+		Audience:  jwt.Audience{audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		Expiry:    jwt.NewNumericDate(now.Add(s.ttl)),
+	}
+	forwardedClaims := map[string]any{}
+	for _, claim := range s.claims {
+		if value, found := userClaims[claim]; found {
+			forwardedClaims[claim] = value
+		}
+	}
+	return jwt.Signed(signer).Claims(forwardedClaims).Claims(registeredClaims).Serialize()
+}
+
+// KeySet returns the public keys that can verify the JWTs of the signer
+func (s *Signer) KeySet() jose.JSONWebKeySet {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	output := jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0, len(s.keys))}
+	for _, key := range s.keys {
+		output.Keys = append(output.Keys, key.Public())
+	}
+	return output
+}
+
+// JWKSHandler publishes the public keys of the signer as a JSON Web Key Set
+func (s *Signer) JWKSHandler(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+	return c.JSON(http.StatusOK, s.KeySet())
+}
+
+// LoadKeys reads the key files again. The keys in use are kept when any of the files cannot be loaded.
+func (s *Signer) LoadKeys() error {
+	keys := make([]jose.JSONWebKey, 0, len(s.keyFiles))
+	for _, keyFile := range s.keyFiles {
+		key, err := loadKey(keyFile)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys = keys
+	return nil
+}
+
+// Start reloads the key files periodically until the context is cancelled, so that rotated keys
+// are picked up without a restart
+func (s *Signer) Start(ctx context.Context) {
+	if s.reloadInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(s.reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			err := s.LoadKeys()
+			if err != nil {
+				slog.Error("INTERNAL JWT", "message", "reloading the signing keys failed, keeping the current keys", "error", err)
+			}
+		}
+	}()
+}
+
+// loadKey reads a PEM encoded private key, its key ID is the RFC 7638 thumbprint of the public key
+func loadKey(keyFile string) (jose.JSONWebKey, error) {
+	content, err := os.ReadFile(keyFile)
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("cannot read the signing key file %s: %w", keyFile, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return jose.JSONWebKey{}, fmt.Errorf("the signing key file %s does not contain a PEM block", keyFile)
+	}
+	var privateKey any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("cannot parse the signing key file %s: %w", keyFile, err)
+	}
+	algorithm, err := keyAlgorithm(privateKey)
+	if err != nil {
+		return jose.JSONWebKey{}, fmt.Errorf("cannot use the signing key file %s: %w", keyFile, err)
+	}
+	key := jose.JSONWebKey{Key: privateKey, Algorithm: string(algorithm), Use: "sig"}
+	publicKey := key.Public()
+	thumbprint, err := publicKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	key.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	return key, nil
+}
+
+func keyAlgorithm(privateKey any) (jose.SignatureAlgorithm, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+		return "", fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", privateKey)
+	}
+}
+
+type SignerOption func(*Signer)
+
+// WithConfig sets up the signer from the internal JWT config, the issuer is only set when it is configured
+func WithConfig(jwtConfig config.InternalJWTConfig) SignerOption {
+	return func(s *Signer) {
+		if jwtConfig.Issuer != "" {
+			s.issuer = jwtConfig.Issuer
+		}
+		s.keyFiles = jwtConfig.SigningKeyFiles
+		s.reloadInterval = time.Duration(jwtConfig.KeyReloadSeconds) * time.Second
+		s.ttl = time.Duration(jwtConfig.TTLSeconds) * time.Second
+		if len(jwtConfig.Claims) > 0 {
+			s.claims = jwtConfig.Claims
+		}
+	}
+}
+
+// WithIssuer sets the issuer of the JWTs
+func WithIssuer(issuer string) SignerOption {
+	return func(s *Signer) {
+		s.issuer = issuer
+	}
+}
+
+// NewSigner creates a signer and loads its keys
+func NewSigner(options ...SignerOption) (*Signer, error) {
+	s := Signer{claims: defaultClaims}
+	for _, opt := range options {
+		opt(&s)
+	}
+	if s.issuer == "" {
+		return nil, fmt.Errorf("the issuer of the internal JWTs is not set")
+	}
+	if len(s.keyFiles) == 0 {
+		return nil, fmt.Errorf("no signing key files are configured for the internal JWTs")
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultTTL
+	}
+	err := s.LoadKeys()
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
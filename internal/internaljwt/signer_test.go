@@ -0,0 +1,160 @@
+package internaljwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRSAKey(t *testing.T, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	block := pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&block), 0600))
+}
+
+func writeECKey(t *testing.T, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	block := pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&block), 0600))
+}
+
+// verify checks the signature of a JWT against the published keys and returns its claims
+func verify(t *testing.T, signer *Signer, token string) (jwt.Claims, map[string]any) {
+	parsed, err := jwt.ParseSigned(token, []jose.SignatureAlgorithm{jose.RS256, jose.ES256})
+	require.NoError(t, err)
+	require.Len(t, parsed.Headers, 1)
+	keySet := signer.KeySet()
+	keys := keySet.Key(parsed.Headers[0].KeyID)
+	require.Len(t, keys, 1)
+	var registeredClaims jwt.Claims
+	otherClaims := map[string]any{}
+	require.NoError(t, parsed.Claims(keys[0].Key, &registeredClaims, &otherClaims))
+	return registeredClaims, otherClaims
+}
+
+func TestSign(t *testing.T) {
+	keyFile := path.Join(t.TempDir(), "key.pem")
+	writeRSAKey(t, keyFile)
+	signer, err := NewSigner(
+		WithIssuer("https://renku.example.org"),
+		WithConfig(config.InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{keyFile}, TTLSeconds: 60}),
+	)
+	require.NoError(t, err)
+
+	token, err := signer.Sign("user-id", "data-service", map[string]any{
+		"name":         "Jane Doe",
+		"email":        "jane@example.org",
+		"realm_access": map[string]any{"roles": []string{"renku-admin"}},
+		"sub":          "forged",
+	})
+	require.NoError(t, err)
+
+	registeredClaims, otherClaims := verify(t, signer, token)
+	assert.NoError(t, registeredClaims.Validate(jwt.Expected{
+		Issuer:      "https://renku.example.org",
+		Subject:     "user-id",
+		AnyAudience: jwt.Audience{"data-service"},
+	}))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), registeredClaims.Expiry.Time(), 5*time.Second)
+	assert.Equal(t, "Jane Doe", otherClaims["name"])
+	assert.Equal(t, "jane@example.org", otherClaims["email"])
+	assert.NotContains(t, otherClaims, "realm_access")
+}
+
+func TestKeyRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	currentKeyFile := path.Join(tmpDir, "current.pem")
+	previousKeyFile := path.Join(tmpDir, "previous.pem")
+	writeRSAKey(t, currentKeyFile)
+	writeECKey(t, previousKeyFile)
+	signer, err := NewSigner(
+		WithIssuer("https://renku.example.org"),
+		WithConfig(config.InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{currentKeyFile, previousKeyFile}}),
+	)
+	require.NoError(t, err)
+	assert.Len(t, signer.KeySet().Keys, 2)
+	oldToken, err := signer.Sign("user-id", "data-service", nil)
+	require.NoError(t, err)
+
+	// Rotate the keys, the JWTs signed before the rotation can still be verified
+	currentKey, err := os.ReadFile(currentKeyFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(previousKeyFile, currentKey, 0600))
+	writeECKey(t, currentKeyFile)
+	require.NoError(t, signer.LoadKeys())
+	newToken, err := signer.Sign("user-id", "data-service", nil)
+	require.NoError(t, err)
+
+	oldClaims, _ := verify(t, signer, oldToken)
+	assert.Equal(t, "user-id", oldClaims.Subject)
+	newClaims, _ := verify(t, signer, newToken)
+	assert.Equal(t, "user-id", newClaims.Subject)
+	assert.Equal(t, string(jose.ES256), signer.KeySet().Keys[0].Algorithm)
+
+	// A broken key file does not remove the loaded keys
+	require.NoError(t, os.WriteFile(currentKeyFile, []byte("not a key"), 0600))
+	assert.ErrorContains(t, signer.LoadKeys(), "does not contain a PEM block")
+	assert.Len(t, signer.KeySet().Keys, 2)
+}
+
+func TestJWKSHandler(t *testing.T) {
+	keyFile := path.Join(t.TempDir(), "key.pem")
+	writeECKey(t, keyFile)
+	signer, err := NewSigner(
+		WithIssuer("https://renku.example.org"),
+		WithConfig(config.InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{keyFile}}),
+	)
+	require.NoError(t, err)
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/auth/jwks", nil), rec)
+
+	require.NoError(t, signer.JWKSHandler(c))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "public, max-age=300", rec.Header().Get(echo.HeaderCacheControl))
+	var body struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Keys, 1)
+	assert.Equal(t, "ES256", body.Keys[0]["alg"])
+	assert.Equal(t, "sig", body.Keys[0]["use"])
+	assert.NotEmpty(t, body.Keys[0]["kid"])
+	assert.NotContains(t, body.Keys[0], "d")
+}
+
+func TestNewSignerErrors(t *testing.T) {
+	_, err := NewSigner(WithConfig(config.InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{"/key.pem"}}))
+	assert.ErrorContains(t, err, "the issuer of the internal JWTs is not set")
+
+	_, err = NewSigner(WithIssuer("https://renku.example.org"))
+	assert.ErrorContains(t, err, "no signing key files are configured for the internal JWTs")
+
+	_, err = NewSigner(
+		WithIssuer("https://renku.example.org"),
+		WithConfig(config.InternalJWTConfig{Enabled: true, SigningKeyFiles: []string{path.Join(t.TempDir(), "missing.pem")}}),
+	)
+	assert.ErrorContains(t, err, "cannot read the signing key file")
+}
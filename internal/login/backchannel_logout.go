@@ -0,0 +1,87 @@
+package login
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// PostBackchannelLogout implements OpenID Connect Back-Channel Logout 1.0. The identity provider
+// sends a signed logout token and all the gateway sessions matching its sid or sub are removed
+// together with their tokens.
+func (l *LoginServer) PostBackchannelLogout(c echo.Context) error {
+	if l.authenticator == nil {
+		return c.NoContent(http.StatusNotImplemented)
+	}
+	ctx := c.Request().Context()
+	claims, err := l.authenticator.VerifyLogoutToken(ctx, c.FormValue("logout_token"))
+	if err != nil {
+		slog.Warn("BACKCHANNEL LOGOUT", "message", "invalid logout token", "error", err, "requestID", utils.GetRequestID(c))
+		return backchannelLogoutError(c, "the logout token is invalid")
+	}
+	providerID, found := l.providerIDByIssuer(claims.Issuer)
+	if !found {
+		slog.Warn("BACKCHANNEL LOGOUT", "message", "logout token from an unknown provider", "issuer", claims.Issuer, "requestID", utils.GetRequestID(c))
+		return backchannelLogoutError(c, "the issuer of the logout token is not a login provider")
+	}
+
+	var sessionIDs []string
+	if claims.SessionID != "" {
+		sessionIDs, err = l.sessions.GetSessionIDsByProviderSession(ctx, providerID, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		if claims.Subject != "" && providerID == models.PrimaryProviderID {
+			userSessionIDs, err := l.sessions.GetSessionIDsByUser(ctx, claims.Subject)
+			if err != nil {
+				return err
+			}
+			sessionIDs = slices.DeleteFunc(sessionIDs, func(sessionID string) bool {
+				return !slices.Contains(userSessionIDs, sessionID)
+			})
+		}
+	} else if providerID == models.PrimaryProviderID {
+		count, err := l.sessions.RevokeUserSessions(ctx, claims.Subject)
+		if err != nil {
+			slog.Error("BACKCHANNEL LOGOUT", "message", "failed to remove the sessions of a user", "error", err, "requestID", utils.GetRequestID(c))
+			return err
+		}
+		slog.Info("BACKCHANNEL LOGOUT", "message", "removed sessions", "providerID", providerID, "count", count, "requestID", utils.GetRequestID(c))
+		return c.NoContent(http.StatusOK)
+	} else {
+		return backchannelLogoutError(c, fmt.Sprintf("logout tokens from the provider %s have to contain a sid", providerID))
+	}
+
+	for _, sessionID := range sessionIDs {
+		err = l.sessions.Revoke(ctx, sessionID)
+		if err != nil {
+			slog.Error("BACKCHANNEL LOGOUT", "message", "failed to remove session", "error", err, "requestID", utils.GetRequestID(c))
+			return err
+		}
+	}
+	slog.Info("BACKCHANNEL LOGOUT", "message", "removed sessions", "providerID", providerID, "count", len(sessionIDs), "requestID", utils.GetRequestID(c))
+	return c.NoContent(http.StatusOK)
+}
+
+// providerIDByIssuer returns the ID of the login provider with the given issuer
+func (l *LoginServer) providerIDByIssuer(issuer string) (string, bool) {
+	for providerID, provider := range l.config.Providers {
+		if strings.TrimSuffix(provider.Issuer, "/") == strings.TrimSuffix(issuer, "/") {
+			return providerID, true
+		}
+	}
+	return "", false
+}
+
+func backchannelLogoutError(c echo.Context, description string) error {
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error":             "invalid_request",
+		"error_description": description,
+	})
+}
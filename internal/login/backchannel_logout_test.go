@@ -0,0 +1,152 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getLogoutToken(issuer string, claims jwt.MapClaims) (string, error) {
+	tokenClaims := jwt.MapClaims{
+		"iss":    issuer,
+		"aud":    "renku",
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(time.Minute).Unix(),
+		"jti":    "logout-token-id",
+		"events": map[string]any{"http://schemas.openid.net/event/backchannel-logout": map[string]any{}},
+	}
+	for k, v := range claims {
+		tokenClaims[k] = v
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(testRSAPrivateKey))
+	if err != nil {
+		return "", err
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims).SignedString(key)
+}
+
+func TestPostBackchannelLogout(t *testing.T) {
+	kcAuthServer := testAuthServer{ClientID: "renku"}
+	kcAuthServer.Start()
+	defer kcAuthServer.Server().Close()
+	issuer := kcAuthServer.Server().URL
+	testConfig, err := getTestConfig(8080, kcAuthServer)
+	require.NoError(t, err)
+
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator(authentication.WithConfig([]config.AuthorizationVerifier{
+		{Issuer: issuer, Audience: "renku", AuthorizedParty: "renku"},
+	}))
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{UnsafeNoCookieHandler: true}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+		WithAuthenticator(authenticator),
+	)
+	require.NoError(t, err)
+	e := echo.New()
+	api.RegisterHandlers(e)
+
+	ctx := context.Background()
+	sm := sessions.NewSessionMaker()
+	newSession := func(userID, sid string) models.Session {
+		session, err := sm.NewSession()
+		require.NoError(t, err)
+		session.UserID = userID
+		session.ProviderSessionIDs = models.SerializableMap{"renku": sid}
+		require.NoError(t, dbAdapter.SetSession(ctx, session))
+		return session
+	}
+	postLogoutToken := func(logoutToken string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Add("logout_token", logoutToken)
+		req := httptest.NewRequest(http.MethodPost, "/backchannel-logout", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	sessionExists := func(sessionID string) bool {
+		_, err := dbAdapter.GetSession(ctx, sessionID)
+		if err != nil {
+			require.ErrorIs(t, err, gwerrors.ErrSessionNotFound)
+			return false
+		}
+		return true
+	}
+
+	session1 := newSession("user-1", "sid-1")
+	session2 := newSession("user-1", "sid-2")
+	session3 := newSession("user-2", "sid-3")
+
+	logoutToken, err := getLogoutToken(issuer, jwt.MapClaims{"sub": "user-1", "sid": "sid-1"})
+	require.NoError(t, err)
+	rec := postLogoutToken(logoutToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, sessionExists(session1.ID))
+	assert.True(t, sessionExists(session2.ID))
+	assert.True(t, sessionExists(session3.ID))
+
+	logoutToken, err = getLogoutToken(issuer, jwt.MapClaims{"sub": "user-1"})
+	require.NoError(t, err)
+	rec = postLogoutToken(logoutToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, sessionExists(session2.ID))
+	assert.True(t, sessionExists(session3.ID))
+
+	invalidTokens := map[string]jwt.MapClaims{
+		"missing event":        {"sub": "user-2", "events": map[string]any{}},
+		"nonce":                {"sub": "user-2", "nonce": "nonce-value"},
+		"missing sub and sid":  {},
+		"expired":              {"sub": "user-2", "exp": time.Now().Add(-time.Minute).Unix()},
+		"unknown audience":     {"sub": "user-2", "aud": "other"},
+		"unknown issuer":       {"sub": "user-2", "iss": "https://example.org"},
+		"issued in the future": {"sub": "user-2", "iat": time.Now().Add(time.Hour).Unix()},
+	}
+	for name, claims := range invalidTokens {
+		t.Run(name, func(t *testing.T) {
+			logoutToken, err := getLogoutToken(issuer, claims)
+			require.NoError(t, err)
+			rec := postLogoutToken(logoutToken)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "invalid_request")
+			assert.True(t, sessionExists(session3.ID))
+		})
+	}
+	rec = postLogoutToken("not-a-token")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
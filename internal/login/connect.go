@@ -0,0 +1,56 @@
+package login
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// GetConnect runs the authorization code flow of a single provider and links its tokens
+// to the current session, unlike GetLogin the session is kept
+func (l *LoginServer) GetConnect(c echo.Context) error {
+	providerID := c.Param("providerId")
+	session, err := l.connectableSession(c, providerID)
+	if err != nil {
+		return err
+	}
+	redirectURL := l.redirects.safeRedirectURL(c, c.QueryParam("redirect_url"))
+	slog.Info("LOGIN", "message", "connecting provider", "providerID", providerID, "requestID", utils.GetRequestID(c))
+	return l.startLoginFlow(c, session, redirectURL, models.SerializableStringSlice{providerID}, nil)
+}
+
+// PostDisconnect removes the tokens of a provider from the current session and revokes them
+// if they are not used by other sessions of the user
+func (l *LoginServer) PostDisconnect(c echo.Context) error {
+	providerID := c.Param("providerId")
+	session, err := l.connectableSession(c, providerID)
+	if err != nil {
+		return err
+	}
+	err = l.sessions.Disconnect(c.Request().Context(), session, providerID)
+	if err != nil {
+		slog.Error("LOGIN", "message", "failed to disconnect provider", "providerID", providerID, "error", err, "requestID", utils.GetRequestID(c))
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// connectableSession returns the current session if it belongs to a logged in user and the
+// provider can be connected to it
+func (l *LoginServer) connectableSession(c echo.Context, providerID string) (*models.Session, error) {
+	if _, found := l.config.Providers[providerID]; !found {
+		return nil, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("cannot find the provider with ID %s", providerID))
+	}
+	if providerID == models.PrimaryProviderID {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("the provider %s cannot be connected, log in instead", providerID))
+	}
+	session, err := l.sessions.Get(c)
+	if err != nil || session.UserID == "" || session.ID == "" {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "a logged in session is required")
+	}
+	return session, nil
+}
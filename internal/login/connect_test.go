@@ -0,0 +1,192 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectDisconnect(t *testing.T) {
+	ctx := context.Background()
+	loginServerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	loginServerPort := loginServerListener.Addr().(*net.TCPAddr).Port
+	defer loginServerListener.Close()
+	renkuAuthServer := testAuthServer{
+		Authorized:      true,
+		RefreshToken:    "renku-refresh-token-value",
+		ClientID:        "renku",
+		CallbackURI:     fmt.Sprintf("http://127.0.0.1:%d/callback", loginServerPort),
+		DefaultProvider: true,
+		IssuedTokens:    []string{},
+	}
+	renkuAuthServer.Start()
+	defer renkuAuthServer.Server().Close()
+	gitlabAuthServer := testAuthServer{
+		Authorized:      true,
+		RefreshToken:    "gitlab-refresh-token-value",
+		ClientID:        "gitlab",
+		CallbackURI:     fmt.Sprintf("http://127.0.0.1:%d/callback", loginServerPort),
+		DefaultProvider: true,
+		IssuedTokens:    []string{},
+	}
+	gitlabAuthServer.Start()
+	defer gitlabAuthServer.Server().Close()
+	// A plain oauth2 provider which does not issue ID tokens
+	oauth2AuthServer := testAuthServer{
+		Authorized:   true,
+		RefreshToken: "oauth2-refresh-token-value",
+		ClientID:     "oauth2",
+		CallbackURI:  fmt.Sprintf("http://127.0.0.1:%d/callback", loginServerPort),
+		IssuedTokens: []string{},
+		OAuth2Only:   true,
+	}
+	oauth2AuthServer.Start()
+	defer oauth2AuthServer.Server().Close()
+	testConfig, err := getTestConfig(loginServerPort, renkuAuthServer, gitlabAuthServer, oauth2AuthServer)
+	require.NoError(t, err)
+
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{
+			UnsafeNoCookieHandler: true,
+		}),
+		sessions.WithCookieTemplate(func() http.Cookie {
+			return http.Cookie{
+				Name:     sessions.SessionCookieName,
+				Path:     "/",
+				Secure:   false,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode}
+		}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	apiServer, err := startTestServer(api, loginServerListener)
+	require.NoError(t, err)
+	defer apiServer.Close()
+	testServerURL, err := url.Parse(strings.TrimRight(
+		fmt.Sprintf("http://127.0.0.1:%d%s", loginServerPort, testConfig.LoginRoutesBasePath),
+		"/",
+	))
+	require.NoError(t, err)
+
+	// Connecting requires a logged in session
+	anonymousClient := *http.DefaultClient
+	res, err := anonymousClient.Get(testServerURL.JoinPath("/connect/gitlab").String())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	// Logging in with another provider only does not link its tokens to any user
+	linkClient := *http.DefaultClient
+	linkJar, err := cookiejar.New(&cookiejar.Options{})
+	require.NoError(t, err)
+	linkClient.Jar = linkJar
+	linkURL := testServerURL.JoinPath("/login")
+	linkURL.RawQuery = url.Values{"provider_id": []string{"gitlab"}}.Encode()
+	res, err = linkClient.Get(linkURL.String())
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	assert.Contains(t, string(body), "You are not logged in")
+	linkSession, err := dbAdapter.GetSession(ctx, linkJar.Cookies(testServerURL)[0].Value)
+	require.NoError(t, err)
+	assert.Empty(t, linkSession.UserID)
+	assert.Empty(t, linkSession.TokenIDs)
+
+	client := *http.DefaultClient
+	jar, err := cookiejar.New(&cookiejar.Options{})
+	require.NoError(t, err)
+	client.Jar = jar
+	loginURL := testServerURL.JoinPath("/login")
+	loginURL.RawQuery = url.Values{"provider_id": []string{"renku"}}.Encode()
+	res, err = client.Get(loginURL.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	sessionCookie := client.Jar.Cookies(testServerURL)[0]
+	session, err := dbAdapter.GetSession(ctx, sessionCookie.Value)
+	require.NoError(t, err)
+	require.Len(t, session.TokenIDs, 1)
+
+	res, err = client.Get(testServerURL.JoinPath("/connect/gitlab").String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, testConfig.RenkuBaseURL.String(), res.Request.URL.String())
+	// The same session now holds the tokens of both providers
+	assert.Equal(t, sessionCookie.Value, client.Jar.Cookies(testServerURL)[0].Value)
+	session, err = dbAdapter.GetSession(ctx, sessionCookie.Value)
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab:"+session.UserID, session.TokenIDs["gitlab"])
+	assert.Equal(t, "renku:"+session.UserID, session.TokenIDs["renku"])
+	gitlabTokenID := session.TokenIDs["gitlab"]
+	_, err = dbAdapter.GetRefreshToken(ctx, gitlabTokenID)
+	require.NoError(t, err)
+
+	res, err = client.Get(testServerURL.JoinPath("/connect/oauth2").String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, testConfig.RenkuBaseURL.String(), res.Request.URL.String())
+	session, err = dbAdapter.GetSession(ctx, sessionCookie.Value)
+	require.NoError(t, err)
+	oauth2TokenID := session.TokenIDs["oauth2"]
+	assert.Equal(t, "oauth2:"+session.UserID, oauth2TokenID)
+	_, err = dbAdapter.GetRefreshToken(ctx, oauth2TokenID)
+	require.NoError(t, err)
+	_, err = dbAdapter.GetIDToken(ctx, oauth2TokenID)
+	assert.Error(t, err)
+
+	for path, status := range map[string]int{"/connect/renku": http.StatusBadRequest, "/connect/github": http.StatusNotFound} {
+		res, err = client.Get(testServerURL.JoinPath(path).String())
+		require.NoError(t, err)
+		assert.Equal(t, status, res.StatusCode, path)
+	}
+
+	res, err = client.Post(testServerURL.JoinPath("/disconnect/gitlab").String(), "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	session, err = dbAdapter.GetSession(ctx, sessionCookie.Value)
+	require.NoError(t, err)
+	assert.NotContains(t, session.TokenIDs, "gitlab")
+	assert.Contains(t, session.TokenIDs, "renku")
+	_, err = dbAdapter.GetRefreshToken(ctx, gitlabTokenID)
+	assert.Error(t, err)
+	assert.Contains(t, gitlabAuthServer.RevokedTokens(), "gitlab-refresh-token-value")
+	assert.Empty(t, renkuAuthServer.RevokedTokens())
+}
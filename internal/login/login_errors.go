@@ -0,0 +1,77 @@
+package login
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// loginErrorReason is the reason why a login failed, it selects the explanation shown to the user
+type loginErrorReason string
+
+const (
+	loginErrorExpired       loginErrorReason = "expired"
+	loginErrorStateMismatch loginErrorReason = "state_mismatch"
+	loginErrorAccessDenied  loginErrorReason = "access_denied"
+	loginErrorProvider      loginErrorReason = "provider_error"
+	loginErrorLoginRequired loginErrorReason = "login_required"
+)
+
+// errPrimaryLoginRequired is returned when the tokens of a provider are received before the user logged in with Renku
+var errPrimaryLoginRequired = errors.New("the user is not logged in with the primary provider")
+
+type loginErrorPage struct {
+	status  int
+	title   string
+	message string
+}
+
+var loginErrorPages = map[loginErrorReason]loginErrorPage{
+	loginErrorExpired: {
+		status:  http.StatusBadRequest,
+		title:   "Your login has expired",
+		message: "The login took too long to complete or was already used. Please log in again.",
+	},
+	loginErrorStateMismatch: {
+		status:  http.StatusBadRequest,
+		title:   "Your login could not be verified",
+		message: "The login was not started from this browser or its state is invalid. Please log in again.",
+	},
+	loginErrorAccessDenied: {
+		status:  http.StatusForbidden,
+		title:   "Access was denied",
+		message: "The login was cancelled or access to your account was not granted to Renku.",
+	},
+	loginErrorProvider: {
+		status:  http.StatusBadGateway,
+		title:   "Your login failed",
+		message: "The identity provider could not complete the login. Please try again later.",
+	},
+	loginErrorLoginRequired: {
+		status:  http.StatusUnauthorized,
+		title:   "You are not logged in",
+		message: "Your Renku login has expired or was never completed. Please log in to Renku before connecting other accounts.",
+	},
+}
+
+// renderLoginError renders the login error page, the user can retry the login with the same redirect URL
+func (l *LoginServer) renderLoginError(c echo.Context, reason loginErrorReason, redirectURL string, err error) error {
+	slog.Info("LOGIN", "message", "login failed", "reason", reason, "error", err, "requestID", utils.GetRequestID(c))
+	retryURL := l.config.RenkuBaseURL.JoinPath(l.config.LoginRoutesBasePath, "login")
+	if redirectURL != "" {
+		query := retryURL.Query()
+		query.Set("redirect_url", redirectURL)
+		retryURL.RawQuery = query.Encode()
+	}
+	page := loginErrorPages[reason]
+	templateData := map[string]any{
+		"renkuBaseURL": l.config.RenkuBaseURL.String(),
+		"title":        page.title,
+		"message":      page.message,
+		"retryURL":     retryURL.String(),
+	}
+	return c.Render(page.status, "login_error", templateData)
+}
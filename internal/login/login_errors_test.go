@@ -0,0 +1,175 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginErrorPages(t *testing.T) {
+	ctx := context.Background()
+	loginServerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	loginServerPort := loginServerListener.Addr().(*net.TCPAddr).Port
+	defer loginServerListener.Close()
+	kcAuthServer := testAuthServer{
+		Authorized:      true,
+		RefreshToken:    "refresh-token-value",
+		ClientID:        "renku",
+		CallbackURI:     fmt.Sprintf("http://127.0.0.1:%d/callback", loginServerPort),
+		DefaultProvider: true,
+		IssuedTokens:    []string{},
+	}
+	kcAuthServer.Start()
+	defer kcAuthServer.Server().Close()
+	testConfig, err := getTestConfig(loginServerPort, kcAuthServer)
+	require.NoError(t, err)
+
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{
+			UnsafeNoCookieHandler: true,
+		}),
+		sessions.WithCookieTemplate(func() http.Cookie {
+			return http.Cookie{
+				Name:     sessions.SessionCookieName,
+				Path:     "/",
+				Secure:   false,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode}
+		}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	apiServer, err := startTestServer(api, loginServerListener)
+	require.NoError(t, err)
+	defer apiServer.Close()
+	testServerURL, err := url.Parse(strings.TrimRight(
+		fmt.Sprintf("http://127.0.0.1:%d%s", loginServerPort, testConfig.LoginRoutesBasePath),
+		"/",
+	))
+	require.NoError(t, err)
+	appRedirectURL := testConfig.RenkuBaseURL.JoinPath("projects").String()
+	expectedRetryURL := testConfig.RenkuBaseURL.JoinPath("login")
+	expectedRetryURL.RawQuery = url.Values{"redirect_url": []string{appRedirectURL}}.Encode()
+
+	// newClient returns a client with its own cookies which stops at the identity provider
+	newClient := func() *http.Client {
+		client := *http.DefaultClient
+		jar, err := cookiejar.New(&cookiejar.Options{})
+		require.NoError(t, err)
+		client.Jar = jar
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host != loginServerListener.Addr().String() {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+		return &client
+	}
+	startLogin := func(client *http.Client) *url.URL {
+		loginURL := testServerURL.JoinPath("/login")
+		loginURL.RawQuery = url.Values{"redirect_url": []string{appRedirectURL}}.Encode()
+		res, err := client.Get(loginURL.String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, res.StatusCode)
+		authURL, err := res.Location()
+		require.NoError(t, err)
+		return authURL
+	}
+	callback := func(client *http.Client, query url.Values) (int, string) {
+		callbackURL := testServerURL.JoinPath("/callback")
+		callbackURL.RawQuery = query.Encode()
+		res, err := client.Get(callbackURL.String())
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	t.Run("missing state", func(t *testing.T) {
+		status, body := callback(newClient(), url.Values{"code": []string{"codeValue"}})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, body, "Your login could not be verified")
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		status, body := callback(newClient(), url.Values{"code": []string{"codeValue"}, "state": []string{"unknown"}})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, body, "Your login has expired")
+	})
+
+	t.Run("expired flow", func(t *testing.T) {
+		client := newClient()
+		state := startLogin(client).Query().Get("state")
+		flow, err := dbAdapter.GetLoginFlow(ctx, state)
+		require.NoError(t, err)
+		flow.ExpiresAt = time.Now().Add(-time.Second)
+		require.NoError(t, dbAdapter.SetLoginFlow(ctx, flow))
+		status, body := callback(client, url.Values{"code": []string{"codeValue"}, "state": []string{state}})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, body, "Your login has expired")
+		assert.Contains(t, body, strings.ReplaceAll(expectedRetryURL.String(), "&", "&amp;"))
+	})
+
+	t.Run("state from another browser", func(t *testing.T) {
+		state := startLogin(newClient()).Query().Get("state")
+		status, body := callback(newClient(), url.Values{"code": []string{"codeValue"}, "state": []string{state}})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Contains(t, body, "Your login could not be verified")
+		assert.Contains(t, body, strings.ReplaceAll(expectedRetryURL.String(), "&", "&amp;"))
+	})
+
+	t.Run("access denied", func(t *testing.T) {
+		client := newClient()
+		state := startLogin(client).Query().Get("state")
+		status, body := callback(client, url.Values{"error": []string{"access_denied"}, "state": []string{state}})
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Contains(t, body, "Access was denied")
+		assert.Contains(t, body, strings.ReplaceAll(expectedRetryURL.String(), "&", "&amp;"))
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		client := newClient()
+		state := startLogin(client).Query().Get("state")
+		status, body := callback(client, url.Values{"error": []string{"server_error"}, "state": []string{state}})
+		assert.Equal(t, http.StatusBadGateway, status)
+		assert.Contains(t, body, "Your login failed")
+	})
+}
@@ -3,6 +3,7 @@ package login
 import (
 	"fmt"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/oidc"
@@ -16,6 +17,9 @@ type LoginServer struct {
 	sessions      *sessions.SessionStore
 	tokenStore    models.TokenStoreInterface
 	metricsClient models.MetricsClientInterface
+	redirects     redirectValidator
+	authenticator authentication.Authenticator
+	loginFlows    models.LoginFlowRepository
 }
 
 func (l *LoginServer) RegisterHandlers(server *echo.Echo, commonMiddlewares ...echo.MiddlewareFunc) {
@@ -29,6 +33,12 @@ func (l *LoginServer) RegisterHandlers(server *echo.Echo, commonMiddlewares ...e
 	e.GET("/user-profile", wrapper.GetUserProfile)
 	e.GET("/gitlab/exchange", l.GetGitLabToken, NoCaching)
 	e.GET("/gitlab/logout", l.GetGitLabLogout)
+	e.POST("/backchannel-logout", l.PostBackchannelLogout, NoCaching)
+	e.GET("/connect/:providerId", l.GetConnect, NoCaching)
+	e.POST("/disconnect/:providerId", l.PostDisconnect, NoCaching)
+	e.GET("/tokens/:providerId", l.GetProviderToken, NoCaching)
+	e.GET("/session", l.GetSessionInfo, NoCaching)
+	e.POST("/session/refresh", l.PostSessionRefresh, NoCaching)
 }
 
 type LoginServerOption func(*LoginServer) error
@@ -41,7 +51,8 @@ func WithConfig(loginConfig config.LoginConfig) LoginServerOption {
 			return err
 		}
 		l.providerStore = providerStore
-		return nil
+		l.redirects, err = newRedirectValidator(loginConfig)
+		return err
 	}
 }
 
@@ -59,6 +70,22 @@ func WithTokenStore(store models.TokenStoreInterface) LoginServerOption {
 	}
 }
 
+// WithAuthenticator sets the authenticator used to verify the logout tokens sent by identity providers
+func WithAuthenticator(authenticator authentication.Authenticator) LoginServerOption {
+	return func(l *LoginServer) error {
+		l.authenticator = authenticator
+		return nil
+	}
+}
+
+// WithLoginFlowRepository sets where the state of the login flows in progress is kept
+func WithLoginFlowRepository(repo models.LoginFlowRepository) LoginServerOption {
+	return func(l *LoginServer) error {
+		l.loginFlows = repo
+		return nil
+	}
+}
+
 func WithMetricsClient(client models.MetricsClientInterface) LoginServerOption {
 	return func(l *LoginServer) error {
 		l.metricsClient = client
@@ -88,5 +115,8 @@ func NewLoginServer(options ...LoginServerOption) (*LoginServer, error) {
 	if server.tokenStore == nil {
 		return &LoginServer{}, fmt.Errorf("token store is not initialized")
 	}
+	if server.loginFlows == nil {
+		return &LoginServer{}, fmt.Errorf("login flow repository is not initialized")
+	}
 	return &server, nil
 }
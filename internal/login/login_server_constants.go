@@ -1,4 +1,8 @@
 package login
 
+import "time"
+
 var defaultLoginSequence = [...]string{"renku", "gitlab"}
 var v2OnlyLoginSequence = [...]string{"renku"}
+
+const defaultLoginFlowTTL time.Duration = 10 * time.Minute
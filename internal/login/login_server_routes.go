@@ -1,14 +1,20 @@
 package login
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	gwoidc "github.com/SwissDataScienceCenter/renku-gateway/internal/oidc"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
 )
 
 // GetLogin is a handler for the initiation of a authorization code flow login for Renku
@@ -19,12 +25,10 @@ func (l *LoginServer) GetLogin(c echo.Context, params GetLoginParams) error {
 	}
 	// Check redirect parameters
 	var appRedirectURL string
-	if params.RedirectUrl != nil && *params.RedirectUrl != "" {
+	if params.RedirectUrl != nil {
 		appRedirectURL = *params.RedirectUrl
-	} else {
-		appRedirectURL = l.config.RenkuBaseURL.String()
 	}
-	session.LoginRedirectURL = appRedirectURL
+	redirectURL := l.redirects.safeRedirectURL(c, appRedirectURL)
 	// Check provider IDs requested for login
 	var loginSequence models.SerializableStringSlice
 	if params.ProviderId != nil && len(*params.ProviderId) > 0 {
@@ -32,75 +36,123 @@ func (l *LoginServer) GetLogin(c echo.Context, params GetLoginParams) error {
 	} else {
 		loginSequence = l.getLoginSequence()
 	}
-	session.LoginSequence = loginSequence
-	return l.nextAuthStep(c, session)
+	// Step-up authentication parameters are forwarded to the Renku identity provider
+	authParams := models.SerializableMap{}
+	if params.MaxAge != nil && *params.MaxAge >= 0 {
+		authParams["max_age"] = strconv.Itoa(*params.MaxAge)
+	}
+	if params.AcrValues != nil && *params.AcrValues != "" {
+		authParams["acr_values"] = *params.AcrValues
+	}
+	return l.startLoginFlow(c, session, redirectURL, loginSequence, authParams)
 }
 
 func (l *LoginServer) GetCallback(c echo.Context, params GetCallbackParams) error {
 	state := c.Request().URL.Query().Get("state")
 	if state == "" {
-		return fmt.Errorf("a state parameter is required")
+		return l.renderLoginError(c, loginErrorStateMismatch, "", fmt.Errorf("a state parameter is required"))
 	}
-	session, err := l.sessions.Get(c)
-	if err != nil {
+	// Load the login flow, a state can only be used once
+	ctx := c.Request().Context()
+	flow, err := l.loginFlows.ConsumeLoginFlow(ctx, state)
+	if errors.Is(err, gwerrors.ErrLoginFlowNotFound) {
+		// The flow was already used or it has been removed after expiring
+		return l.renderLoginError(c, loginErrorExpired, "", fmt.Errorf("state cannot be found in the login flows"))
+	}
+	if err != nil && !errors.Is(err, gwerrors.ErrLoginFlowExpired) {
 		return err
 	}
-	sessionState := session.LoginState
-	if state != sessionState {
-		return fmt.Errorf("state cannot be found in the existing session")
+	if err != nil {
+		return l.renderLoginError(c, loginErrorExpired, flow.RedirectURL, err)
+	}
+	err = l.sessions.CheckLoginFlowCookie(c, flow)
+	if err != nil {
+		return l.renderLoginError(c, loginErrorStateMismatch, flow.RedirectURL, err)
+	}
+	session, err := l.sessions.Resume(c, flow.SessionID)
+	if errors.Is(err, gwerrors.ErrSessionNotFound) || errors.Is(err, gwerrors.ErrSessionExpired) {
+		return l.renderLoginError(c, loginErrorExpired, flow.RedirectURL, err)
+	}
+	if err != nil {
+		return err
 	}
-	// Load the provider from the session
-	if len(session.LoginSequence) == 0 {
-		return fmt.Errorf("login sequence is invalid")
+	// Load the provider from the login flow
+	if len(flow.Sequence) == 0 {
+		return l.renderLoginError(c, loginErrorStateMismatch, flow.RedirectURL, fmt.Errorf("login sequence is invalid"))
 	}
-	providerID := session.LoginSequence[0]
-	session.LoginSequence = session.LoginSequence[1:]
-	handler, err := l.providerStore.CodeExchangeHandler(providerID)
+	providerID := flow.Sequence[0]
+	flow.Sequence = flow.Sequence[1:]
+	handler, err := l.providerStore.CodeExchangeHandler(providerID, flow.CodeVerifier)
 	if err != nil {
 		return err
 	}
 	tokenCallback := func(tokenSet models.AuthTokenSet) error {
-		// Clear the state value before saving the tokens
-		session.LoginState = ""
-		if providerID == "renku" {
+		if providerID == models.PrimaryProviderID {
 			session.UserID = tokenSet.IDToken.Subject
-			if session.UserID != "" {
-				tokenID := "renku:" + session.UserID
-				tokenSet.AccessToken.ID = tokenID
-				tokenSet.RefreshToken.ID = tokenID
-				tokenSet.IDToken.ID = tokenID
-			}
-		} else if providerID == "gitlab" && session.UserID != "" {
-			tokenID := "gitlab:" + session.UserID
+		} else if session.UserID == "" {
+			// The tokens of the other providers are linked to the Renku user
+			return fmt.Errorf("%w: cannot link the tokens of provider %s without logging in with %s first", errPrimaryLoginRequired, providerID, models.PrimaryProviderID)
+		}
+		if session.UserID != "" {
+			tokenID := models.TokenID(providerID, session.UserID)
 			tokenSet.AccessToken.ID = tokenID
 			tokenSet.RefreshToken.ID = tokenID
-			tokenSet.IDToken.ID = tokenID
+			// OAuth2 providers do not issue ID tokens
+			if tokenSet.HasIDToken() {
+				tokenSet.IDToken.ID = tokenID
+			}
+		}
+		// Keep the session ID at the provider so that back-channel logout can find the session
+		if tokenSet.IDToken.Value != "" {
+			var claims oidc.IDTokenClaims
+			_, err := oidc.ParseToken(tokenSet.IDToken.Value, &claims)
+			if err != nil {
+				slog.Warn("LOGIN", "message", "could not parse the ID token", "providerID", providerID, "error", err, "requestID", utils.GetRequestID(c))
+			} else if claims.SessionID != "" {
+				if session.ProviderSessionIDs == nil {
+					session.ProviderSessionIDs = models.SerializableMap{}
+				}
+				session.ProviderSessionIDs[providerID] = claims.SessionID
+			}
 		}
 		return l.sessions.SaveTokens(c, session, tokenSet)
 	}
+	var exchangeErr error
+	errorCallback := func(err error) {
+		exchangeErr = err
+	}
 	// Exchange the authorization code for credentials
-	err = echo.WrapHandler(handler(tokenCallback))(c)
+	err = echo.WrapHandler(handler(tokenCallback, errorCallback))(c)
 	if err != nil {
 		slog.Error("code exchange handler failed", "error", err, "requestID", utils.GetRequestID(c))
 		return err
 	}
+	var providerErr gwoidc.ProviderError
+	if errors.As(exchangeErr, &providerErr) && providerErr.Code == "access_denied" {
+		return l.renderLoginError(c, loginErrorAccessDenied, flow.RedirectURL, exchangeErr)
+	}
+	if errors.Is(exchangeErr, errPrimaryLoginRequired) {
+		return l.renderLoginError(c, loginErrorLoginRequired, flow.RedirectURL, exchangeErr)
+	}
+	if exchangeErr != nil {
+		return l.renderLoginError(c, loginErrorProvider, flow.RedirectURL, exchangeErr)
+	}
 	// Continue to the next authentication step
-	return l.nextAuthStep(c, session)
+	return l.nextAuthStep(c, &flow)
 }
 
 func (l *LoginServer) GetLogout(c echo.Context, params GetLogoutParams) error {
 	// Check redirect parameters
 	var redirectURL string
-	if params.RedirectUrl != nil && *params.RedirectUrl != "" {
+	if params.RedirectUrl != nil {
 		redirectURL = *params.RedirectUrl
-	} else {
-		redirectURL = l.config.RenkuBaseURL.String()
 	}
+	redirectURL = l.redirects.safeRedirectURL(c, redirectURL)
 
 	session, err := l.sessions.Get(c)
 	var renkuIdToken string = ""
 	if err == nil {
-		idToken, err := l.sessions.GetIDToken(c, *session, "renku")
+		idToken, err := l.sessions.GetIDToken(c, *session, models.PrimaryProviderID)
 		if err == nil {
 			renkuIdToken = idToken.Value
 		}
@@ -114,7 +166,7 @@ func (l *LoginServer) GetLogout(c echo.Context, params GetLogoutParams) error {
 
 	templateProviders := make(map[string]any, len(l.providerStore))
 	for providerID, provider := range l.config.Providers {
-		if providerID == "renku" && renkuIdToken != "" {
+		if providerID == models.PrimaryProviderID && renkuIdToken != "" {
 			logoutURL, err := url.Parse(provider.Issuer)
 			if err != nil {
 				return err
@@ -161,7 +213,7 @@ func (l *LoginServer) GetGitLabToken(c echo.Context) error {
 	if userID == "" {
 		return c.String(401, "Unauthorized")
 	}
-	gilabTokenID := "gitlab:" + userID
+	gilabTokenID := models.TokenID("gitlab", userID)
 	gitlabAccessToken, err := l.tokenStore.GetFreshAccessToken(c.Request().Context(), gilabTokenID)
 	if err != nil {
 		return err
@@ -185,7 +237,7 @@ func (l *LoginServer) GetGitLabLogout(c echo.Context) error {
 }
 
 func (l *LoginServer) GetUserProfile(c echo.Context) error {
-	redirectURL, err := l.providerStore.UserProfileURL("renku")
+	redirectURL, err := l.providerStore.UserProfileURL(models.PrimaryProviderID)
 	if err != nil {
 		return err
 	}
@@ -196,18 +248,37 @@ func (*LoginServer) GetHealth(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
+// startLoginFlow starts a login flow for a session which logs in with the given sequence of providers
+func (l *LoginServer) startLoginFlow(
+	c echo.Context,
+	session *models.Session,
+	redirectURL string,
+	sequence models.SerializableStringSlice,
+	primaryAuthParams models.SerializableMap,
+) error {
+	ttl := time.Duration(l.config.LoginFlowTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultLoginFlowTTL
+	}
+	flow, err := models.NewLoginFlow(session.ID, redirectURL, sequence, ttl)
+	if err != nil {
+		return err
+	}
+	flow.PrimaryAuthParams = primaryAuthParams
+	return l.nextAuthStep(c, &flow)
+}
+
 // nextAuthStep sets up the beginning of the oauth flow and ends with
 // the redirect of the user to the Provider's login and authorization page.
 // Adapted from oauth2-proxy code.
 func (l *LoginServer) nextAuthStep(
 	c echo.Context,
-	session *models.Session,
+	flow *models.LoginFlow,
 ) error {
 	// Get the next provider to authenticate with
-	if session.LoginSequence == nil || len(session.LoginSequence) == 0 {
+	if len(flow.Sequence) == 0 {
 		// no more providers to login with, go to the application
-		url := session.LoginRedirectURL
-		session.LoginRedirectURL = ""
+		url := flow.RedirectURL
 		if url == "" {
 			url = l.config.RenkuBaseURL.String()
 		}
@@ -222,14 +293,26 @@ func (l *LoginServer) nextAuthStep(
 		}
 		return c.Redirect(http.StatusFound, url)
 	}
-	providerID := session.LoginSequence[0]
+	providerID := flow.Sequence[0]
 	// Setup the next login step
-	err := session.GenerateLoginState()
+	err := flow.GenerateState()
+	if err != nil {
+		return err
+	}
+	err = l.loginFlows.SetLoginFlow(c.Request().Context(), *flow)
+	if err != nil {
+		return err
+	}
+	err = l.sessions.SetLoginFlowCookie(c, *flow)
 	if err != nil {
 		return err
 	}
 	// Handle the login
-	handler, err := l.providerStore.AuthHandler(providerID, session.LoginState)
+	var authParams map[string]string
+	if providerID == models.PrimaryProviderID {
+		authParams = flow.PrimaryAuthParams
+	}
+	handler, err := l.providerStore.AuthHandler(providerID, flow.State, flow.CodeVerifier, authParams)
 	if err != nil {
 		slog.Error("auth handler failed", "error", err, "requestID", utils.GetRequestID(c))
 		return err
@@ -19,6 +19,7 @@ import (
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/views"
@@ -139,6 +140,7 @@ func TestGetLogin(t *testing.T) {
 		WithConfig(testConfig),
 		WithSessionStore(sessionStore),
 		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
 	)
 	require.NoError(t, err)
 	apiServer, err := startTestServer(api, loginServerListener)
@@ -181,9 +183,19 @@ func TestGetLogin(t *testing.T) {
 	session, err := dbAdapter.GetSession(context.Background(), sessionCookie.Value)
 	require.NoError(t, err)
 	assert.Len(t, session.TokenIDs, 1)
-	assert.Len(t, session.LoginSequence, 0)
-	assert.Equal(t, "", session.LoginState)
 	assert.Equal(t, res.Request.URL.String(), testConfig.RenkuBaseURL.String())
+	tokenID := session.TokenIDs["renku"]
+	_, err = dbAdapter.GetRefreshToken(context.Background(), tokenID)
+	require.NoError(t, err)
+	// A token exchanged for the access token of the session
+	exchangedTokenID := tokenID + ":exchanged:data-service:"
+	require.NoError(t, dbAdapter.SetExchangedToken(context.Background(), tokenID, models.AuthToken{
+		ID:         exchangedTokenID,
+		Type:       models.AccessTokenType,
+		Value:      "exchanged-token-value",
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ProviderID: "renku",
+	}))
 
 	req, err = http.NewRequest(http.MethodGet, testServerURL.JoinPath("/logout").String(), nil)
 	require.NoError(t, err)
@@ -192,6 +204,16 @@ func TestGetLogin(t *testing.T) {
 	assert.Equal(t, http.StatusOK, res.StatusCode)
 	session, err = dbAdapter.GetSession(context.Background(), sessionCookie.Value)
 	assert.ErrorIs(t, err, gwerrors.ErrSessionNotFound)
+	// The tokens of the session are revoked at the provider and removed on logout
+	assert.ElementsMatch(t, []string{"refresh-token-value", kcAuthServer.IssuedTokens[0]}, kcAuthServer.RevokedTokens())
+	_, err = dbAdapter.GetAccessToken(context.Background(), tokenID)
+	assert.Error(t, err)
+	_, err = dbAdapter.GetRefreshToken(context.Background(), tokenID)
+	assert.Error(t, err)
+	_, err = dbAdapter.GetIDToken(context.Background(), tokenID)
+	assert.Error(t, err)
+	_, err = dbAdapter.GetAccessToken(context.Background(), exchangedTokenID)
+	assert.ErrorIs(t, err, gwerrors.ErrTokenNotFound)
 }
 
 func TestGetLogin2Steps(t *testing.T) {
@@ -260,6 +282,7 @@ func TestGetLogin2Steps(t *testing.T) {
 		WithConfig(testConfig),
 		WithSessionStore(sessionStore),
 		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
 	)
 	require.NoError(t, err)
 	apiServer, err := startTestServer(api, loginServerListener)
@@ -300,8 +323,6 @@ func TestGetLogin2Steps(t *testing.T) {
 	session, err := dbAdapter.GetSession(context.Background(), sessionCookie.Value)
 	require.NoError(t, err)
 	assert.Len(t, session.TokenIDs, 2)
-	assert.Len(t, session.LoginSequence, 0)
-	assert.Equal(t, "", session.LoginState)
 	assert.Equal(t, res.Request.URL.String(), testConfig.RenkuBaseURL.String())
 
 	req, err = http.NewRequest(http.MethodGet, testServerURL.JoinPath("/logout").String(), nil)
@@ -312,3 +333,133 @@ func TestGetLogin2Steps(t *testing.T) {
 	session, err = dbAdapter.GetSession(context.Background(), sessionCookie.Value)
 	assert.ErrorIs(t, err, gwerrors.ErrSessionNotFound)
 }
+
+func TestConcurrentLoginFlows(t *testing.T) {
+	var err error
+
+	loginServerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	loginServerPort := loginServerListener.Addr().(*net.TCPAddr).Port
+	defer loginServerListener.Close()
+	kcAuthServer := testAuthServer{
+		Authorized:      true,
+		RefreshToken:    "refresh-token-value",
+		ClientID:        "renku",
+		CallbackURI:     fmt.Sprintf("http://127.0.0.1:%d/callback", loginServerPort),
+		DefaultProvider: true,
+		IssuedTokens:    []string{},
+	}
+	kcAuthServer.Start()
+	defer kcAuthServer.Server().Close()
+	testConfig, err := getTestConfig(loginServerPort, kcAuthServer)
+	require.NoError(t, err)
+
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{
+			UnsafeNoCookieHandler: true,
+		}),
+		sessions.WithCookieTemplate(func() http.Cookie {
+			return http.Cookie{
+				Name:     sessions.SessionCookieName,
+				Path:     "/",
+				Secure:   false,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode}
+		}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	apiServer, err := startTestServer(api, loginServerListener)
+	require.NoError(t, err)
+	defer apiServer.Close()
+	jar, err := cookiejar.New(&cookiejar.Options{})
+	require.NoError(t, err)
+	// The client stops at the identity provider so that several logins can be started in parallel
+	client := *http.DefaultClient
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.URL.Host != loginServerListener.Addr().String() {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+	testServerURL, err := url.Parse(strings.TrimRight(
+		fmt.Sprintf("http://127.0.0.1:%d%s", loginServerPort, testConfig.LoginRoutesBasePath),
+		"/",
+	))
+	require.NoError(t, err)
+
+	startLogin := func() *url.URL {
+		res, err := client.Get(testServerURL.JoinPath("/login").String())
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, res.StatusCode)
+		authURL, err := res.Location()
+		require.NoError(t, err)
+		return authURL
+	}
+	firstAuthURL := startLogin()
+	secondAuthURL := startLogin()
+	assert.NotEqual(t, firstAuthURL.Query().Get("state"), secondAuthURL.Query().Get("state"))
+
+	// The login flows can be completed in any order
+	client.CheckRedirect = nil
+	var callbackURLs []string
+	for _, authURL := range []*url.URL{secondAuthURL, firstAuthURL} {
+		res, err := client.Get(authURL.String())
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, testConfig.RenkuBaseURL.String(), res.Request.URL.String())
+		callbackURLs = append(callbackURLs, res.Request.Response.Request.URL.String())
+		sessionCookies := []*http.Cookie{}
+		for _, cookie := range client.Jar.Cookies(testServerURL) {
+			if cookie.Name == sessions.SessionCookieName {
+				sessionCookies = append(sessionCookies, cookie)
+			}
+		}
+		require.Len(t, sessionCookies, 1)
+		session, err := dbAdapter.GetSession(context.Background(), sessionCookies[0].Value)
+		require.NoError(t, err)
+		assert.Len(t, session.TokenIDs, 1)
+	}
+	assert.Len(t, client.Jar.Cookies(testServerURL), 1)
+
+	// The state of a login flow can only be used once
+	res, err := client.Get(callbackURLs[0])
+	require.NoError(t, err)
+	assert.NotEqual(t, http.StatusOK, res.StatusCode)
+
+	// The step-up parameters of a login are forwarded to the identity provider
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	stepUpURL := testServerURL.JoinPath("/login")
+	stepUpURL.RawQuery = url.Values{"max_age": {"300"}, "acr_values": {"gold"}}.Encode()
+	res, err = client.Get(stepUpURL.String())
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, res.StatusCode)
+	authURL, err := res.Location()
+	require.NoError(t, err)
+	assert.Equal(t, "300", authURL.Query().Get("max_age"))
+	assert.Equal(t, "gold", authURL.Query().Get("acr_values"))
+}
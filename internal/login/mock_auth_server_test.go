@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
@@ -102,7 +104,28 @@ type testAuthServer struct {
 	CallbackURI     string
 	DefaultProvider bool
 	IssuedTokens    []string
-	server          *httptest.Server
+	// OAuth2Only makes the server act as a plain oauth2 provider which does not issue ID tokens
+	OAuth2Only  bool
+	server      *httptest.Server
+	revocations *tokenRevocations
+}
+
+// tokenRevocations records the tokens revoked at the test auth server
+type tokenRevocations struct {
+	lock   sync.Mutex
+	tokens []string
+}
+
+func (r *tokenRevocations) add(token string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tokens = append(r.tokens, token)
+}
+
+func (r *tokenRevocations) list() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.tokens)
 }
 
 func (*testAuthServer) jwksEndpoint(c echo.Context) error {
@@ -120,6 +143,7 @@ func (t *testAuthServer) wktEndpoint(c echo.Context) error {
 		IdTokenSignAlgs             []string `json:"id_token_signing_alg_values_supported,omitempty"`
 		DeviceAuthorizationEndpoint string   `json:"device_authorization_endpoint,omitempty"`
 		GrantTypesSupported         []string `json:"grant_types_supported,omitempty"`
+		RevocationEndpoint          string   `json:"revocation_endpoint,omitempty"`
 	}
 	res := wkt{
 		Issuer:                      t.Server().URL,
@@ -131,6 +155,7 @@ func (t *testAuthServer) wktEndpoint(c echo.Context) error {
 		IdTokenSignAlgs:             []string{"RS256"},
 		DeviceAuthorizationEndpoint: t.Server().URL + "/authorize/device",
 		GrantTypesSupported:         []string{"authorization_code", "urn:ietf:params:oauth:grant-type:device_code"},
+		RevocationEndpoint:          t.Server().URL + "/revoke",
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -173,15 +198,28 @@ func (t *testAuthServer) tokenEndpoint(c echo.Context) error {
 			return err
 		}
 		t.IssuedTokens = append(t.IssuedTokens, jwtToken)
-		return c.JSON(http.StatusOK, map[string]string{
+		tokens := map[string]string{
 			"access_token":  jwtToken,
 			"refresh_token": t.RefreshToken,
-			"id_token":      jwtToken,
-		})
+		}
+		if !t.OAuth2Only {
+			tokens["id_token"] = jwtToken
+		}
+		return c.JSON(http.StatusOK, tokens)
 	}
 	return c.String(http.StatusBadRequest, "bad request")
 }
 
+func (t *testAuthServer) revokeEndpoint(c echo.Context) error {
+	t.revocations.add(c.FormValue("token"))
+	return c.NoContent(http.StatusOK)
+}
+
+// RevokedTokens returns the tokens that were revoked at the test auth server
+func (t *testAuthServer) RevokedTokens() []string {
+	return t.revocations.list()
+}
+
 func (t *testAuthServer) Server() *httptest.Server {
 	if t.server == nil {
 		panic("Server has not been started")
@@ -196,16 +234,27 @@ func (t *testAuthServer) Start() {
 	e.GET("/authorize", t.authorizeEndpoint)
 	e.GET("/jwks", t.jwksEndpoint)
 	e.POST("/token", t.tokenEndpoint)
+	e.POST("/revoke", t.revokeEndpoint)
 	e.GET("/.well-known/openid-configuration", t.wktEndpoint)
+	t.revocations = &tokenRevocations{}
 	t.server = httptest.NewServer(e.Server.Handler)
 }
 
 func (t *testAuthServer) ProviderConfig() config.OIDCClient {
+	if t.OAuth2Only {
+		return config.OIDCClient{
+			Type:             config.ProviderTypeOAuth2,
+			AuthorizationURL: t.Server().URL + "/authorize",
+			TokenURL:         t.Server().URL + "/token",
+			ClientID:         t.ClientID,
+			ClientSecret:     "client-secret-value",
+			CallbackURI:      t.CallbackURI,
+		}
+	}
 	return config.OIDCClient{
-		Issuer:                t.Server().URL,
-		ClientID:              t.ClientID,
-		ClientSecret:          "client-secret-value",
-		CallbackURI:           t.CallbackURI,
-		UnsafeNoCookieHandler: true,
+		Issuer:       t.Server().URL,
+		ClientID:     t.ClientID,
+		ClientSecret: "client-secret-value",
+		CallbackURI:  t.CallbackURI,
 	}
 }
@@ -0,0 +1,112 @@
+package login
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// redirectValidator checks that the users are only redirected to allowed URLs after logging in or out,
+// otherwise the login endpoints can be used to send users to any website.
+type redirectValidator struct {
+	baseURL      *url.URL
+	origins      []string
+	pathPatterns []*regexp.Regexp
+}
+
+// safeRedirectURL returns the redirect URL if it is allowed, otherwise it returns the Renku base URL.
+// Relative URLs are resolved against the Renku base URL.
+func (r *redirectValidator) safeRedirectURL(c echo.Context, redirectURL string) string {
+	if redirectURL == "" {
+		return r.baseURL.String()
+	}
+	parsed, err := url.Parse(redirectURL)
+	if err != nil {
+		r.reject(c, redirectURL, "the redirect URL cannot be parsed")
+		return r.baseURL.String()
+	}
+	if !parsed.IsAbs() {
+		// Protocol relative URLs like //example.org and backslashes that browsers treat as slashes
+		if parsed.Host != "" || strings.HasPrefix(parsed.Path, "//") || strings.Contains(redirectURL, "\\") || !strings.HasPrefix(parsed.Path, "/") {
+			r.reject(c, redirectURL, "the relative redirect URL is not a path")
+			return r.baseURL.String()
+		}
+		parsed = r.baseURL.ResolveReference(parsed)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		r.reject(c, redirectURL, "the redirect URL scheme is not allowed")
+		return r.baseURL.String()
+	}
+	if parsed.User != nil || !r.allowedOrigin(parsed) {
+		r.reject(c, redirectURL, "the redirect URL origin is not allowed")
+		return r.baseURL.String()
+	}
+	if !r.allowedPath(parsed.Path) {
+		r.reject(c, redirectURL, "the redirect URL path is not allowed")
+		return r.baseURL.String()
+	}
+	return parsed.String()
+}
+
+func (r *redirectValidator) allowedOrigin(redirectURL *url.URL) bool {
+	origin := strings.ToLower(redirectURL.Scheme + "://" + redirectURL.Host)
+	for _, allowed := range r.origins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redirectValidator) allowedPath(path string) bool {
+	if len(r.pathPatterns) == 0 {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	for _, pattern := range r.pathPatterns {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *redirectValidator) reject(c echo.Context, redirectURL string, reason string) {
+	slog.Warn(
+		"LOGIN",
+		"message",
+		"rejected redirect URL, using the Renku base URL instead",
+		"reason",
+		reason,
+		"redirectURL",
+		redirectURL,
+		"requestID",
+		utils.GetRequestID(c),
+	)
+}
+
+func newRedirectValidator(loginConfig config.LoginConfig) (redirectValidator, error) {
+	validator := redirectValidator{baseURL: loginConfig.RenkuBaseURL}
+	if loginConfig.RenkuBaseURL != nil {
+		validator.origins = append(validator.origins, strings.ToLower(loginConfig.RenkuBaseURL.Scheme+"://"+loginConfig.RenkuBaseURL.Host))
+	}
+	for _, origin := range loginConfig.RedirectAllowlist.Origins {
+		validator.origins = append(validator.origins, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+	for _, pattern := range loginConfig.RedirectAllowlist.PathPatterns {
+		// The patterns have to match the whole path
+		compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return redirectValidator{}, err
+		}
+		validator.pathPatterns = append(validator.pathPatterns, compiled)
+	}
+	return validator, nil
+}
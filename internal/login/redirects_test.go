@@ -0,0 +1,65 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSafeRedirectURL(t *testing.T) {
+	renkuBaseURL, err := url.Parse("https://renku.example.org")
+	require.NoError(t, err)
+	validator, err := newRedirectValidator(config.LoginConfig{
+		RenkuBaseURL: renkuBaseURL,
+		RedirectAllowlist: config.RedirectAllowlistConfig{
+			Origins:      []string{"https://docs.example.org"},
+			PathPatterns: []string{"/?", "/projects/.*", "/help"},
+		},
+	})
+	require.NoError(t, err)
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	testCases := []struct {
+		redirectURL string
+		expected    string
+	}{
+		{"", "https://renku.example.org"},
+		{"https://renku.example.org/projects/my-project", "https://renku.example.org/projects/my-project"},
+		{"https://RENKU.example.org/", "https://RENKU.example.org/"},
+		{"https://docs.example.org/help", "https://docs.example.org/help"},
+		{"/projects/my-project?tab=files", "https://renku.example.org/projects/my-project?tab=files"},
+		{"https://evil.example.org/projects/my-project", "https://renku.example.org"},
+		{"https://renku.example.org.evil.org/", "https://renku.example.org"},
+		{"https://renku.example.org@evil.example.org/", "https://renku.example.org"},
+		{"//evil.example.org/projects", "https://renku.example.org"},
+		{"/\\evil.example.org", "https://renku.example.org"},
+		{"javascript:alert(1)", "https://renku.example.org"},
+		{"projects/my-project", "https://renku.example.org"},
+		{"https://renku.example.org/admin", "https://renku.example.org"},
+		{"https://renku.example.org/helpful", "https://renku.example.org"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.redirectURL, func(t *testing.T) {
+			assert.Equal(t, testCase.expected, validator.safeRedirectURL(c, testCase.redirectURL))
+		})
+	}
+}
+
+func TestSafeRedirectURLAllowsAllPathsByDefault(t *testing.T) {
+	renkuBaseURL, err := url.Parse("https://renku.example.org")
+	require.NoError(t, err)
+	validator, err := newRedirectValidator(config.LoginConfig{RenkuBaseURL: renkuBaseURL})
+	require.NoError(t, err)
+	e := echo.New()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	assert.Equal(t, "https://renku.example.org/any/path", validator.safeRedirectURL(c, "https://renku.example.org/any/path"))
+	assert.Equal(t, "https://renku.example.org", validator.safeRedirectURL(c, "https://docs.example.org/any/path"))
+}
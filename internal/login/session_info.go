@@ -0,0 +1,87 @@
+package login
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// sessionInfo describes the current session to the UI
+type sessionInfo struct {
+	Anonymous          bool       `json:"anonymous"`
+	UserID             string     `json:"user_id,omitempty"`
+	Name               string     `json:"name,omitempty"`
+	Email              string     `json:"email,omitempty"`
+	PreferredUsername  string     `json:"preferred_username,omitempty"`
+	ConnectedProviders []string   `json:"connected_providers"`
+	CreatedAt          *time.Time `json:"created_at,omitempty"`
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`
+}
+
+// GetSessionInfo returns who is logged in with the current session, requests without a session
+// or with a session that is not logged in are reported as anonymous
+func (l *LoginServer) GetSessionInfo(c echo.Context) error {
+	output := sessionInfo{Anonymous: true, ConnectedProviders: []string{}}
+	session, err := l.sessions.Get(c)
+	if err != nil {
+		return c.JSON(http.StatusOK, output)
+	}
+	if !session.CreatedAt.IsZero() {
+		output.CreatedAt = &session.CreatedAt
+	}
+	if !session.ExpiresAt.IsZero() {
+		output.ExpiresAt = &session.ExpiresAt
+	}
+	if session.UserID == "" {
+		return c.JSON(http.StatusOK, output)
+	}
+	output.Anonymous = false
+	output.UserID = session.UserID
+	for providerID, tokenID := range session.TokenIDs {
+		if tokenID != "" {
+			output.ConnectedProviders = append(output.ConnectedProviders, providerID)
+		}
+	}
+	slices.Sort(output.ConnectedProviders)
+	idToken, err := l.sessions.GetIDToken(c, *session, models.PrimaryProviderID)
+	if err != nil {
+		slog.Debug("SESSION INFO", "message", "the ID token is not available", "error", err, "requestID", utils.GetRequestID(c))
+		return c.JSON(http.StatusOK, output)
+	}
+	var claims oidc.IDTokenClaims
+	_, err = oidc.ParseToken(idToken.Value, &claims)
+	if err != nil {
+		slog.Warn("SESSION INFO", "message", "could not parse the ID token", "error", err, "requestID", utils.GetRequestID(c))
+		return c.JSON(http.StatusOK, output)
+	}
+	output.Name = claims.Name
+	output.Email = claims.Email
+	output.PreferredUsername = claims.PreferredUsername
+	return c.JSON(http.StatusOK, output)
+}
+
+// PostSessionRefresh keeps the current session alive and reports how long it has left. Loading the
+// session extends its idle expiry, which the session middleware persists after the response.
+func (l *LoginServer) PostSessionRefresh(c echo.Context) error {
+	session, err := l.sessions.Get(c)
+	if err != nil || session.ID == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "a session is required")
+	}
+	now := time.Now().UTC()
+	output := map[string]any{}
+	if !session.ExpiresAt.IsZero() {
+		output["expires_at"] = session.ExpiresAt.UTC()
+		output["expires_in"] = int64(session.ExpiresAt.Sub(now).Seconds())
+	}
+	if maxExpiresAt := session.MaxExpiresAt(); !maxExpiresAt.IsZero() {
+		output["max_expires_at"] = maxExpiresAt.UTC()
+		output["max_expires_in"] = int64(maxExpiresAt.Sub(now).Seconds())
+	}
+	return c.JSON(http.StatusOK, output)
+}
@@ -0,0 +1,204 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSessionInfo(t *testing.T) {
+	ctx := context.Background()
+	renkuBaseURL, err := url.Parse("https://renku.example.org")
+	require.NoError(t, err)
+	testConfig := config.LoginConfig{
+		RenkuBaseURL:        renkuBaseURL,
+		LoginRoutesBasePath: "/api/auth",
+		Providers: map[string]config.OIDCClient{
+			"github": {
+				Type:             config.ProviderTypeOAuth2,
+				ClientID:         "renku",
+				AuthorizationURL: "https://provider.example.org/authorize",
+				TokenURL:         "https://provider.example.org/token",
+			},
+		},
+	}
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{UnsafeNoCookieHandler: true}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	e := echo.New()
+	api.RegisterHandlers(e, sessionStore.Middleware())
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(testRSAPrivateKey))
+	require.NoError(t, err)
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"sub":                "user-1",
+		"name":               "Jane Doe",
+		"email":              "jane.doe@example.org",
+		"preferred_username": "jane",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+	}).SignedString(key)
+	require.NoError(t, err)
+
+	session, err := sessions.NewSessionMaker().NewSession()
+	require.NoError(t, err)
+	session.UserID = "user-1"
+	session.IdleTTLSeconds = 3600
+	session.Touch()
+	session.TokenIDs = models.SerializableMap{
+		"renku":  "renku:user-1",
+		"github": "github:user-1",
+	}
+	require.NoError(t, dbAdapter.SetSession(ctx, session))
+	require.NoError(t, dbAdapter.SetIDToken(ctx, models.AuthToken{
+		ID:         "renku:user-1",
+		Type:       models.IDTokenType,
+		Value:      idToken,
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ProviderID: "renku",
+	}))
+	anonymousSession, err := sessions.NewSessionMaker().NewSession()
+	require.NoError(t, err)
+	require.NoError(t, dbAdapter.SetSession(ctx, anonymousSession))
+
+	request := func(sessionID string) map[string]any {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/session", nil)
+		if sessionID != "" {
+			req.AddCookie(&http.Cookie{Name: sessions.SessionCookieName, Value: sessionID})
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		return body
+	}
+
+	body := request(session.ID)
+	assert.Equal(t, false, body["anonymous"])
+	assert.Equal(t, "user-1", body["user_id"])
+	assert.Equal(t, "Jane Doe", body["name"])
+	assert.Equal(t, "jane.doe@example.org", body["email"])
+	assert.Equal(t, "jane", body["preferred_username"])
+	assert.Equal(t, []any{"github", "renku"}, body["connected_providers"])
+	assert.Equal(t, session.CreatedAt.Format(time.RFC3339Nano), body["created_at"])
+	assert.Contains(t, body, "expires_at")
+
+	body = request(anonymousSession.ID)
+	assert.Equal(t, true, body["anonymous"])
+	assert.NotContains(t, body, "user_id")
+	assert.Equal(t, []any{}, body["connected_providers"])
+	assert.Contains(t, body, "created_at")
+
+	body = request("")
+	assert.Equal(t, true, body["anonymous"])
+	assert.NotContains(t, body, "created_at")
+}
+
+func TestPostSessionRefresh(t *testing.T) {
+	ctx := context.Background()
+	renkuBaseURL, err := url.Parse("https://renku.example.org")
+	require.NoError(t, err)
+	testConfig := config.LoginConfig{
+		RenkuBaseURL:        renkuBaseURL,
+		LoginRoutesBasePath: "/api/auth",
+	}
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{UnsafeNoCookieHandler: true}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	e := echo.New()
+	api.RegisterHandlers(e, sessionStore.Middleware())
+
+	session, err := sessions.NewSessionMaker(
+		sessions.WithIdleSessionTTLSeconds(600),
+		sessions.WithMaxSessionTTLSeconds(3600),
+	).NewSession()
+	require.NoError(t, err)
+	// The session was last used 5 minutes ago
+	session.CreatedAt = session.CreatedAt.Add(-5 * time.Minute)
+	session.ExpiresAt = session.ExpiresAt.Add(-5 * time.Minute)
+	require.NoError(t, dbAdapter.SetSession(ctx, session))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/session/refresh", nil)
+	req.AddCookie(&http.Cookie{Name: sessions.SessionCookieName, Value: session.ID})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.InDelta(t, 600, body["expires_in"], 5)
+	assert.InDelta(t, 3300, body["max_expires_in"], 5)
+	expiresAt, err := time.Parse(time.RFC3339, body["expires_at"].(string))
+	require.NoError(t, err)
+	headerExpiresAt, err := time.Parse(time.RFC3339, rec.Header().Get(sessions.SessionExpiresAtHeader))
+	require.NoError(t, err)
+	assert.WithinDuration(t, expiresAt, headerExpiresAt, time.Second)
+	// The extended idle expiry is persisted
+	refreshed, err := dbAdapter.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), refreshed.ExpiresAt, 5*time.Second)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/auth/session/refresh", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
@@ -20,8 +20,12 @@ import (
 
 // GetCallbackParams defines parameters for GetCallback.
 type GetCallbackParams struct {
-	Code  string `form:"code" json:"code"`
-	State string `form:"state" json:"state"`
+	Code  *string `form:"code,omitempty" json:"code,omitempty"`
+	State *string `form:"state,omitempty" json:"state,omitempty"`
+
+	// Error The error returned by the identity provider instead of a code, i.e. `access_denied`
+	Error            *string `form:"error,omitempty" json:"error,omitempty"`
+	ErrorDescription *string `form:"error_description,omitempty" json:"error_description,omitempty"`
 }
 
 // GetLoginParams defines parameters for GetLogin.
@@ -30,6 +34,14 @@ type GetLoginParams struct {
 
 	// ProviderId Providing `provider_id` query parameters should be used for testing only.
 	ProviderId *[]string `form:"provider_id,omitempty" json:"provider_id,omitempty"`
+
+	// MaxAge Asks the Renku identity provider to authenticate the user again if their last
+	// authentication is older than this number of seconds, used for step-up authentication.
+	MaxAge *int `form:"max_age,omitempty" json:"max_age,omitempty"`
+
+	// AcrValues The authentication context classes requested from the Renku identity provider,
+	// used for step-up authentication.
+	AcrValues *string `form:"acr_values,omitempty" json:"acr_values,omitempty"`
 }
 
 // GetLogoutParams defines parameters for GetLogout.
@@ -67,20 +79,34 @@ func (w *ServerInterfaceWrapper) GetCallback(ctx echo.Context) error {
 
 	// Parameter object where we will unmarshal all parameters from the context
 	var params GetCallbackParams
-	// ------------- Required query parameter "code" -------------
+	// ------------- Optional query parameter "code" -------------
 
-	err = runtime.BindQueryParameter("form", true, true, "code", ctx.QueryParams(), &params.Code)
+	err = runtime.BindQueryParameter("form", true, false, "code", ctx.QueryParams(), &params.Code)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter code: %s", err))
 	}
 
-	// ------------- Required query parameter "state" -------------
+	// ------------- Optional query parameter "state" -------------
 
-	err = runtime.BindQueryParameter("form", true, true, "state", ctx.QueryParams(), &params.State)
+	err = runtime.BindQueryParameter("form", true, false, "state", ctx.QueryParams(), &params.State)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter state: %s", err))
 	}
 
+	// ------------- Optional query parameter "error" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "error", ctx.QueryParams(), &params.Error)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter error: %s", err))
+	}
+
+	// ------------- Optional query parameter "error_description" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "error_description", ctx.QueryParams(), &params.ErrorDescription)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter error_description: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetCallback(ctx, params)
 	return err
@@ -115,6 +141,20 @@ func (w *ServerInterfaceWrapper) GetLogin(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter provider_id: %s", err))
 	}
 
+	// ------------- Optional query parameter "max_age" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "max_age", ctx.QueryParams(), &params.MaxAge)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter max_age: %s", err))
+	}
+
+	// ------------- Optional query parameter "acr_values" -------------
+
+	err = runtime.BindQueryParameter("form", true, false, "acr_values", ctx.QueryParams(), &params.AcrValues)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Invalid format for parameter acr_values: %s", err))
+	}
+
 	// Invoke the callback with all the unmarshaled arguments
 	err = w.Handler.GetLogin(ctx, params)
 	return err
@@ -185,19 +225,23 @@ func RegisterHandlersWithBaseURL(router EchoRouter, si ServerInterface, baseURL
 
 // Base64 encoded, gzipped, json marshaled Swagger object
 var swaggerSpec = []string{
-
-	"H4sIAAAAAAAC/7xVwY7bNhD9lcFcetHa7uZS6Fa0wLZAgAZpe2qMhKZGEmGaVDhDG26w/14MJSW7Xhm7",
-	"C7S9WeTwzeN7b+gv6EIbsf6CDbFNbhAXA9b4NnYuQOvjqYLffszSgzXe74zdM5jQgMnSUxBnjR6ANiZ4",
-	"T2GfsUJx4glrLN9wZ4RO5owVHinxCH78Hu8rjAMFMzis8c1qs7rFCgcjPSuX9dxMPzqSp/yUUkzu77G9",
-	"jQ0Vsl9ZYsFPZfvXBmu8I/np295gkjmQUGKs/1INsMbPmZLyDOag9BUTK0z0ObtEDdaSMlXItqeDUUJy",
-	"HrSOJbnQ4f19tYzDYuR1QFst5iEGpqLGm83tUwH+6Akk7inAyTBkpgYkgrGlCUhPYKwl5qlIPdNFZUEs",
-	"4BgSNS6RFWqgzUl6SnoJFNOpKPjYYdzq3ron46W/6sovZdv2ZPdAoRmiC7JasmIsxIuL3m42yxdlSkdn",
-	"qbDOIbjQwZAU1J9XhbQWzGZeBOVonDc7TxCDP0Ob4gFOTnoXih77HxiszyyUsMKcPNbYiwz1ep00vzfd",
-	"mN8blQPVmlmfSYrt/9ldLfA6mVcd+F1MEi7gpVB1KjH4OqGLfpRxf9lczLH5qHSfmYfH3N6leHSNmvdp",
-	"KD8pfXTNJygN4Ftr4D5m38COxlwrdSEWPakyrj4o1SVuD2AfUXNCB17gWM0LJiVzfs3oZaZ0MUQSi+xj",
-	"MGf1TUer58fKxy5muWrq29gV6NI0ZoHYTl7CzzRQaEZlSo2NoXVdHu3VQl2cYgQfgvSOwZoAiTh7gSmI",
-	"Bdl4jrAjBfOx66iZe9058WY3yr4UHeX+72Zn+9KXoRDXF5Bzee7a7P35Af3ntVeEmyHF1um/1hUH3k/E",
-	"eXZZT33HMJ0bfV7W50+m9G6C/8/TdXBs9V4Xj9L4sqzN4NbzS/JPAAAA///N5yMY+QcAAA==",
+	"H4sIAAAAAAAC/7xWwW4jNwz9FUKXXiZ2utsChW+LFkgLBOhi26KHJnBkiZ4RrJEmIuXEXeTfC0qeOHHG",
+	"m+1i25stS+TjI9+jPyoX1lEtPiqLZJIb2MWgFuoyti7A2se7Bn59l7kDo71fabMh0MGCztxhYGe0PIB1",
+	"TPABwyarRrFjj2qhyne40Ix3eqcatcVENfj2W/XQqDhg0INTC/V2dj57oxo1aO5IsMzHZPKlRX6JTyDF",
+	"5P6u6U20WMA+opzBnx0G4A7B11K089SAm+EMVmh0JgTHgPeDS2ivQkzlsrNSFe9gSHHrLCZIyDkFtKAD",
+	"YEoxNY+fYNAtgiNIGCwmtHDXOdOBR6arIOEyYQJOO9CtdmF2FVSpOxXYv1i1UBfIP47FCgNJ98iYSC3+",
+	"kt6ohbrNmIS/oHuhVWpVjSLTYa+FGN4Nck6cXGjVw0Mz/Y5Y86sPn5P8e4f7Qh9JWO1O0OQCMWoLcQ26",
+	"9GPP9Y02BomWFoNDe6OaSXAly5dUVR4un8L+VJDrRiWkIQbCMmdvz9+oxVTVHDcY4E6TdNACR9DmNruE",
+	"pfpa0v6SqEEOE95mJK7jYF1Cw2hhnRN3mGTevzs/n05WB7TTNE4jxASOCUrLwOgQIsMKYYvJrR3aGu3t",
+	"dLQycpXtEajm6aZJnO9PUfCyxSZmb0GwmNgPHhkP+pIOKdatzK16bg7qWn6bd6g9dycF/XP52XRoNoDB",
+	"DtEFnk2ppV5UR518c4pcwrR1pqo0h+BCKwUNmPxuVkDLhVFvRx6z1c7rlUeIwe9gnWIPd447V31l8wOB",
+	"8ZkYZXJz8mqhOuZhMZ8nsb6ztlrfmdChHq4P/OypuP4/s0sLaqdOdeA31onpiWUOKZbxeTT3yX6UTfF5",
+	"1jXqYilw/5UTvS8zKM27Gcdx6ewNlARwSA3UlSFdYRWuQGcklpdCY3XgKWxPwj6D5hh7msDYjAc6Jb2b",
+	"wvyONpXNughf6klc5aCUKqai3rIswK3lxCXwmvgqHG1cRxB9idJpmQhHEHK/wiQOTGhisNQcSCDG4SwP",
+	"R3v7NB29vl/q9vm+6F1wfe7V4vyxeBcYW0xT5Yv4jjCbGBjvGYzXREijZaKt0/0Jrpqr8MWlaJOWW+0z",
+	"0tfZDaVFz12eYwFfjWVUj25x9rot+tjGzCdFeRnbw1zEzNLdqkX4CQcMtk52uWNiWLs2V3nKRTnc2wDI",
+	"/xFHskwgIWXP4MIhsvYUYYUSzMe2RTvmunDs9erEH5fLiv3rav/6c529AJcVTbmsuXX2fvcE/uvcS4Sz",
+	"IcW183iyAx/2wGnssrz6hmD/rvZ5mp8/CNP7ffj/fLp6R0bqOloqdTPM9eDm4yb4ZwAalQ2p9AsAAA==",
 }
 
 // GetSwagger returns the content of the embedded swagger specification file
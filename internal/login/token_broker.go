@@ -0,0 +1,62 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// GetProviderToken returns a fresh access token of a connected provider for the current session.
+// Only the providers which opt in with ExposeAccessTokens are served.
+func (l *LoginServer) GetProviderToken(c echo.Context) error {
+	providerID := c.Param("providerId")
+	provider, found := l.config.Providers[providerID]
+	if !found || !provider.ExposeAccessTokens {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("cannot find the provider with ID %s", providerID))
+	}
+	session, err := l.sessions.Get(c)
+	if err != nil || session.UserID == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "a logged in session is required")
+	}
+	tokenID, found := session.TokenIDs[providerID]
+	if !found || tokenID == "" {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("the provider %s is not connected", providerID))
+	}
+	accessToken, err := l.tokenStore.GetFreshAccessToken(c.Request().Context(), tokenID)
+	if errors.Is(err, gwerrors.ErrTokenExpired) || errors.Is(err, gwerrors.ErrTokenNotFound) {
+		// The tokens cannot be refreshed anymore, the user has to connect the provider again
+		slog.Info("TOKEN BROKER", "message", "re-consent required", "providerID", providerID, "userID", session.UserID, "requestID", utils.GetRequestID(c))
+		return c.JSON(http.StatusConflict, map[string]any{
+			"error":       "consent_required",
+			"connect_url": l.config.RenkuBaseURL.JoinPath(l.config.LoginRoutesBasePath, "connect", providerID).String(),
+		})
+	}
+	if err != nil {
+		slog.Error("TOKEN BROKER", "message", "GetFreshAccessToken failed", "providerID", providerID, "error", err, "requestID", utils.GetRequestID(c))
+		return err
+	}
+	slog.Info(
+		"TOKEN BROKER",
+		"message",
+		"issued access token",
+		"providerID",
+		providerID,
+		"userID",
+		session.UserID,
+		"expiresAt",
+		accessToken.ExpiresAt,
+		"remoteIP",
+		c.RealIP(),
+		"requestID",
+		utils.GetRequestID(c),
+	)
+	return c.JSON(http.StatusOK, map[string]any{
+		"access_token": accessToken.Value,
+		"expires_at":   accessToken.ExpiresAt.Unix(),
+	})
+}
@@ -0,0 +1,121 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetProviderToken(t *testing.T) {
+	ctx := context.Background()
+	renkuBaseURL, err := url.Parse("https://renku.example.org")
+	require.NoError(t, err)
+	oauth2Provider := func(exposeAccessTokens bool) config.OIDCClient {
+		return config.OIDCClient{
+			Type:               config.ProviderTypeOAuth2,
+			ClientID:           "renku",
+			AuthorizationURL:   "https://provider.example.org/authorize",
+			TokenURL:           "https://provider.example.org/token",
+			ExposeAccessTokens: exposeAccessTokens,
+		}
+	}
+	testConfig := config.LoginConfig{
+		RenkuBaseURL:        renkuBaseURL,
+		LoginRoutesBasePath: "/api/auth",
+		Providers: map[string]config.OIDCClient{
+			"github":  oauth2Provider(true),
+			"zenodo":  oauth2Provider(false),
+			"dropbox": oauth2Provider(true),
+		},
+	}
+	dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
+		Type: config.DBTypeRedisMock,
+	}))
+	require.NoError(t, err)
+	tokenStore, err := tokenstore.NewTokenStore(
+		tokenstore.WithExpiryMargin(time.Duration(3)*time.Minute),
+		tokenstore.WithConfig(testConfig),
+		tokenstore.WithTokenRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	authenticator, err := authentication.NewAuthenticator()
+	require.NoError(t, err)
+	sessionStore, err := sessions.NewSessionStore(
+		sessions.WithAuthenticator(authenticator),
+		sessions.WithSessionRepository(dbAdapter),
+		sessions.WithTokenStore(tokenStore),
+		sessions.WithConfig(config.SessionConfig{UnsafeNoCookieHandler: true}),
+	)
+	require.NoError(t, err)
+	api, err := NewLoginServer(
+		WithConfig(testConfig),
+		WithSessionStore(sessionStore),
+		WithTokenStore(tokenStore),
+		WithLoginFlowRepository(dbAdapter),
+	)
+	require.NoError(t, err)
+	e := echo.New()
+	api.RegisterHandlers(e, sessionStore.Middleware())
+
+	session, err := sessions.NewSessionMaker().NewSession()
+	require.NoError(t, err)
+	session.UserID = "user-1"
+	session.TokenIDs = models.SerializableMap{
+		"github":  "github:user-1",
+		"dropbox": "dropbox:user-1",
+	}
+	require.NoError(t, dbAdapter.SetSession(ctx, session))
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, dbAdapter.SetAccessToken(ctx, models.AuthToken{
+		ID:         "github:user-1",
+		Type:       models.AccessTokenType,
+		Value:      "github-access-token",
+		ExpiresAt:  expiresAt,
+		ProviderID: "github",
+	}))
+
+	request := func(providerID string, withSession bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/auth/tokens/"+providerID, nil)
+		if withSession {
+			req.AddCookie(&http.Cookie{Name: sessions.SessionCookieName, Value: session.ID})
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request("github", true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "github-access-token", body["access_token"])
+	assert.Equal(t, float64(expiresAt.Unix()), body["expires_at"])
+
+	assert.Equal(t, http.StatusUnauthorized, request("github", false).Code)
+	// Providers which do not opt in are not served
+	assert.Equal(t, http.StatusNotFound, request("zenodo", true).Code)
+	assert.Equal(t, http.StatusNotFound, request("unknown", true).Code)
+	// The tokens of dropbox are gone, the user has to connect it again
+	rec = request("dropbox", true)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "https://renku.example.org/api/auth/connect/dropbox", body["connect_url"])
+
+	delete(session.TokenIDs, "dropbox")
+	require.NoError(t, dbAdapter.SetSession(ctx, session))
+	assert.Equal(t, http.StatusNotFound, request("dropbox", true).Code)
+}
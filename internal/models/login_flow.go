@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+var codeVerifierGenerator IDGenerator = NewRandomGenerator(32)
+
+// LoginFlow holds the state of a login in progress. Flows are stored apart from the session and are keyed
+// by the OAuth state value of their current step, so that a user can run several logins at the same time.
+type LoginFlow struct {
+	// The OAuth state value of the current step
+	State string
+	// The ID of the session which receives the tokens of the flow
+	SessionID string
+	// Secret kept in a cookie of the browser which started the flow, the callback has to present it
+	Binding string
+	// The url to redirect to when the login flow is complete (i.e. Renku homepage)
+	RedirectURL string
+	// The sequence of providers left in the flow, the first one is the provider of the current step
+	Sequence SerializableStringSlice
+	// The PKCE code verifier of the current step
+	CodeVerifier string
+	// Additional parameters of the authorization request sent to the primary provider,
+	// i.e. max_age and acr_values for step-up authentication
+	PrimaryAuthParams SerializableMap
+	// UTC timestamp for when the flow expires
+	ExpiresAt time.Time
+}
+
+// NewLoginFlow creates a flow for a session that logs in with the given sequence of providers
+func NewLoginFlow(sessionID, redirectURL string, sequence SerializableStringSlice, ttl time.Duration) (LoginFlow, error) {
+	binding, err := randomStateGenerator.ID()
+	if err != nil {
+		return LoginFlow{}, err
+	}
+	return LoginFlow{
+		SessionID:   sessionID,
+		Binding:     binding,
+		RedirectURL: redirectURL,
+		Sequence:    sequence,
+		ExpiresAt:   time.Now().UTC().Add(ttl),
+	}, nil
+}
+
+func (f *LoginFlow) Expired() bool {
+	return time.Now().UTC().After(f.ExpiresAt)
+}
+
+// GenerateState sets a new state value and code verifier for the next step of the flow
+func (f *LoginFlow) GenerateState() error {
+	state, err := randomStateGenerator.ID()
+	if err != nil {
+		return err
+	}
+	codeVerifier, err := codeVerifierGenerator.ID()
+	if err != nil {
+		return err
+	}
+	f.State = state
+	f.CodeVerifier = codeVerifier
+	return nil
+}
+
+type LoginFlowRepository interface {
+	GetLoginFlow(ctx context.Context, state string) (LoginFlow, error)
+	SetLoginFlow(ctx context.Context, flow LoginFlow) error
+	ConsumeLoginFlow(ctx context.Context, state string) (LoginFlow, error)
+}
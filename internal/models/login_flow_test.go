@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginFlowState(t *testing.T) {
+	flow, err := NewLoginFlow("session-id", "/", SerializableStringSlice{"renku", "gitlab"}, time.Minute)
+	require.NoError(t, err)
+	assert.NotEmpty(t, flow.Binding)
+	assert.False(t, flow.Expired())
+
+	err = flow.GenerateState()
+	require.NoError(t, err)
+	assert.NotEmpty(t, flow.State)
+	assert.GreaterOrEqual(t, len(flow.CodeVerifier), 43)
+	state := flow.State
+	codeVerifier := flow.CodeVerifier
+	err = flow.GenerateState()
+	require.NoError(t, err)
+	assert.NotEqual(t, state, flow.State)
+	assert.NotEqual(t, codeVerifier, flow.CodeVerifier)
+	assert.NotEqual(t, flow.Binding, flow.State)
+}
+
+func TestLoginFlowExpired(t *testing.T) {
+	flow, err := NewLoginFlow("session-id", "/", SerializableStringSlice{"renku"}, -time.Second)
+	require.NoError(t, err)
+	assert.True(t, flow.Expired())
+}
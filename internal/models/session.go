@@ -18,12 +18,8 @@ type Session struct {
 	UserID         string
 	// Map of providerID to tokenID
 	TokenIDs SerializableMap
-	// The url to redirect to when the login flow is complete (i.e. Renku homepage)
-	LoginRedirectURL string
-	// The sequence of providers for the login flow
-	LoginSequence SerializableStringSlice
-	// State value used during login flows
-	LoginState string
+	// Map of providerID to the session ID (sid) at the provider, used for OIDC back-channel logout
+	ProviderSessionIDs SerializableMap
 }
 
 func (s *Session) Expired() bool {
@@ -50,6 +46,14 @@ func (s *Session) Touch() {
 	s.ExpiresAt = expiresAt
 }
 
+// MaxExpiresAt returns when the session ends regardless of its activity, it is zero when the session has no max TTL
+func (s *Session) MaxExpiresAt() time.Time {
+	if s.MaxTTLSeconds == 0 {
+		return time.Time{}
+	}
+	return s.CreatedAt.Add(s.MaxTTL())
+}
+
 func (s *Session) IdleTTL() time.Duration {
 	return time.Duration(s.IdleTTLSeconds) * time.Second
 }
@@ -57,12 +61,3 @@ func (s *Session) IdleTTL() time.Duration {
 func (s *Session) MaxTTL() time.Duration {
 	return time.Duration(s.MaxTTLSeconds) * time.Second
 }
-
-func (s *Session) GenerateLoginState() error {
-	state, err := randomStateGenerator.ID()
-	if err != nil {
-		return err
-	}
-	s.LoginState = state
-	return nil
-}
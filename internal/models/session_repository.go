@@ -8,6 +8,9 @@ type SessionRepository interface {
 	SessionGetter
 	SessionSetter
 	SessionRemover
+	SessionIndex
+	UserSessionsGetter
+	UserSessionsRemover
 }
 
 type SessionGetter interface {
@@ -21,3 +24,18 @@ type SessionSetter interface {
 type SessionRemover interface {
 	RemoveSession(ctx context.Context, sessionID string) error
 }
+
+// SessionIndex finds the sessions that belong to a user, to a session at an identity provider or that reference tokens
+type SessionIndex interface {
+	GetSessionIDsByUser(ctx context.Context, userID string) ([]string, error)
+	GetSessionIDsByProviderSession(ctx context.Context, providerID, providerSessionID string) ([]string, error)
+	GetSessionIDsByToken(ctx context.Context, tokenID string) ([]string, error)
+}
+
+type UserSessionsGetter interface {
+	GetSessionsByUser(ctx context.Context, userID string) ([]Session, error)
+}
+
+type UserSessionsRemover interface {
+	RemoveSessionsByUser(ctx context.Context, userID string) error
+}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/require"
 )
 
 func TestSessionNotExpired(t *testing.T) {
@@ -65,17 +64,3 @@ func TestSessionTouchNoIdleTTL(t *testing.T) {
 	assert.True(t, session.ExpiresAt.After(session.CreatedAt))
 	assert.True(t, session.ExpiresAt.Equal(session.CreatedAt.Add(session.MaxTTL())))
 }
-
-func TestSessionLoginState(t *testing.T) {
-	session := Session{
-		CreatedAt: time.Now(),
-	}
-	err := session.GenerateLoginState()
-	require.NoError(t, err)
-	assert.NotEmpty(t, session.LoginState)
-	state := session.LoginState
-	err = session.GenerateLoginState()
-	require.NoError(t, err)
-	assert.NotEmpty(t, session.LoginState)
-	assert.NotEqual(t, state, session.LoginState)
-}
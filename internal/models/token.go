@@ -5,6 +5,15 @@ import (
 	"time"
 )
 
+// PrimaryProviderID is the identity provider which establishes the identity of Renku users,
+// the tokens of all other providers are linked to the Renku user
+const PrimaryProviderID string = "renku"
+
+// TokenID returns the ID under which the tokens issued by a provider for a Renku user are stored
+func TokenID(providerID, userID string) string {
+	return providerID + ":" + userID
+}
+
 // AuthToken is a struct used to store and work with OAuth 2.0 access tokens, OAuth 2.0 refresh tokens and OIDC ID tokens
 type AuthToken struct {
 	ID         string
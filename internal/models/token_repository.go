@@ -2,19 +2,22 @@ package models
 
 import (
 	"context"
+	"time"
 )
 
 // TokenRepository represents the interface used to persist tokens
 type TokenRepository interface {
 	AccessTokenGetter
 	AccessTokenSetter
-	// AccessTokenRemover
+	AccessTokenRemover
 	RefreshTokenGetter
 	RefreshTokenSetter
-	// RefreshTokenRemover
+	RefreshTokenRemover
 	IDTokenGetter
 	IDTokenSetter
-	// IDTokenRemover
+	IDTokenRemover
+	ExchangedTokenSetter
+	ExchangedTokensRemover
 }
 
 type AccessTokenGetter interface {
@@ -52,3 +55,33 @@ type IDTokenSetter interface {
 type IDTokenRemover interface {
 	RemoveIDToken(ctx context.Context, tokenID string) error
 }
+
+// ExchangedTokenSetter saves a token exchanged for the access token with the given ID (RFC 8693) and keeps track of it
+// so that it can be removed together with the access token
+type ExchangedTokenSetter interface {
+	SetExchangedToken(ctx context.Context, subjectTokenID string, token AuthToken) error
+}
+
+// ExchangedTokensRemover removes all the tokens exchanged for the access token with the given ID
+type ExchangedTokensRemover interface {
+	RemoveExchangedTokens(ctx context.Context, subjectTokenID string) error
+}
+
+// TokenRefreshLocker coordinates the refresh of tokens between gateway replicas so that only
+// one refresh per token ID is in flight at any time
+type TokenRefreshLocker interface {
+	// AcquireRefreshLock tries to acquire the lock, the fencing token identifies the holder
+	AcquireRefreshLock(ctx context.Context, tokenID string, ttl time.Duration) (fencingToken int64, acquired bool, err error)
+	// SetTokensWithRefreshLock saves the tokens only if the lock is still held with the fencing token
+	SetTokensWithRefreshLock(ctx context.Context, tokenID string, fencingToken int64, tokens ...AuthToken) (saved bool, err error)
+	IsRefreshLocked(ctx context.Context, tokenID string) (bool, error)
+	ReleaseRefreshLock(ctx context.Context, tokenID string, fencingToken int64) error
+}
+
+// TokenRefreshSchedule keeps track of when tokens have to be refreshed ahead of their expiry
+type TokenRefreshSchedule interface {
+	ScheduleTokenRefresh(ctx context.Context, tokenID string, refreshAt time.Time) error
+	GetDueTokenRefreshes(ctx context.Context, before time.Time, limit int) ([]string, error)
+	UnscheduleTokenRefresh(ctx context.Context, tokenID string) error
+	CountScheduledTokenRefreshes(ctx context.Context) (int64, error)
+}
@@ -10,11 +10,16 @@ type AuthTokenSet struct {
 	IDToken      AuthToken
 }
 
+// HasIDToken checks if the set contains an ID token, the sets of plain OAuth2 providers do not
+func (s *AuthTokenSet) HasIDToken() bool {
+	return s.IDToken != AuthToken{}
+}
+
 func (s *AuthTokenSet) ValidateTokensType() error {
-	if s.AccessToken.ID != s.RefreshToken.ID || s.AccessToken.ID != s.IDToken.ID {
+	if s.AccessToken.ID != s.RefreshToken.ID || (s.HasIDToken() && s.AccessToken.ID != s.IDToken.ID) {
 		return fmt.Errorf("tokens in a set should have the same ID")
 	}
-	if s.AccessToken.ProviderID != s.RefreshToken.ProviderID || s.AccessToken.ProviderID != s.IDToken.ProviderID {
+	if s.AccessToken.ProviderID != s.RefreshToken.ProviderID || (s.HasIDToken() && s.AccessToken.ProviderID != s.IDToken.ProviderID) {
 		return fmt.Errorf("tokens in a set should have the same provider ID")
 	}
 	if s.AccessToken.Type != AccessTokenType {
@@ -23,7 +28,7 @@ func (s *AuthTokenSet) ValidateTokensType() error {
 	if s.RefreshToken.Type != RefreshTokenType {
 		return fmt.Errorf("invalid type %s for refresh token %s", s.RefreshToken.Type, s.RefreshToken.ID)
 	}
-	if s.IDToken.Type != IDTokenType {
+	if s.HasIDToken() && s.IDToken.Type != IDTokenType {
 		return fmt.Errorf("invalid type %s for ID token %s", s.IDToken.Type, s.IDToken.ID)
 	}
 	return nil
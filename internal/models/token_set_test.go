@@ -159,3 +159,22 @@ func TestInvalidIDToken(t *testing.T) {
 
 	assert.ErrorContains(t, err, "invalid type AccessToken for ID token my-token")
 }
+
+func TestValidateTokensTypeWithoutIDToken(t *testing.T) {
+	tokenID := "my-token"
+	tokenSet := AuthTokenSet{
+		AccessToken: AuthToken{
+			ID:   tokenID,
+			Type: AccessTokenType,
+		},
+		RefreshToken: AuthToken{
+			ID:   tokenID,
+			Type: RefreshTokenType,
+		},
+	}
+
+	err := tokenSet.ValidateTokensType()
+
+	assert.NoError(t, err)
+	assert.False(t, tokenSet.HasIDToken())
+}
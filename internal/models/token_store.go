@@ -6,13 +6,16 @@ import "context"
 type TokenStoreInterface interface {
 	FreshAccessTokenGetter
 	AccessTokenSetter
-	// AccessTokenRemover
+	AccessTokenRemover
 	RefreshTokenGetter
 	RefreshTokenSetter
-	// RefreshTokenRemover
+	RefreshTokenRemover
 	FreshIDTokenGetter
 	IDTokenSetter
-	// IDTokenRemover
+	IDTokenRemover
+	TokenRevoker
+	AccessTokenExchanger
+	ExchangedTokensRemover
 }
 
 type FreshAccessTokenGetter interface {
@@ -22,3 +25,13 @@ type FreshAccessTokenGetter interface {
 type FreshIDTokenGetter interface {
 	GetFreshIDToken(ctx context.Context, tokenID string) (AuthToken, error)
 }
+
+// TokenRevoker revokes the access and refresh tokens with the given ID at their identity provider
+type TokenRevoker interface {
+	RevokeTokens(ctx context.Context, tokenID string) error
+}
+
+// AccessTokenExchanger exchanges an access token for a token with a specific audience and scopes (RFC 8693)
+type AccessTokenExchanger interface {
+	ExchangeAccessToken(ctx context.Context, subjectToken AuthToken, audience string, scopes []string) (AuthToken, error)
+}
@@ -2,25 +2,34 @@ package oidc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/labstack/echo/v4"
 	"github.com/zitadel/oidc/v3/pkg/client/rp"
-	httphelper "github.com/zitadel/oidc/v3/pkg/http"
+	"github.com/zitadel/oidc/v3/pkg/client/tokenexchange"
 	"github.com/zitadel/oidc/v3/pkg/oidc"
+	"golang.org/x/oauth2"
 )
 
 type oidcClient struct {
-	client rp.RelyingParty
-	id     string
+	client  rp.RelyingParty
+	id      string
+	usePKCE bool
+	// userInfoURL and subjectClaim are used to get the subject of the tokens of oauth2 providers
+	userInfoURL  string
+	subjectClaim string
 }
 
-func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback) func(
+// getCodeExchangeCallback returns the function which passes the tokens of a code exchange to the callback,
+// the failures are passed to the error callback without writing to the response
+func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback, errorCallback ErrorCallback) func(
 	w http.ResponseWriter,
 	r *http.Request,
 	tokens *oidc.Tokens[*oidc.IDTokenClaims],
@@ -37,7 +46,7 @@ func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback) func(
 		id, err := models.ULIDGenerator{}.ID()
 		if err != nil {
 			slog.Error("generating token ID failed in token exchange", "error", err, "requestID", r.Header.Get(echo.HeaderXRequestID))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errorCallback(fmt.Errorf("failed to generate the token ID: %w", err))
 			return
 		}
 		refreshTokenClaims := oidc.TokenClaims{}
@@ -45,7 +54,27 @@ func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback) func(
 		if err != nil {
 			slog.Error("could not parse refresh token", "error", err, "requestID", r.Header.Get(echo.HeaderXRequestID))
 		}
-		subject := tokens.IDTokenClaims.Subject
+		// Plain oauth2 providers do not issue ID tokens
+		idToken := models.AuthToken{}
+		var subject string
+		if tokens.IDTokenClaims != nil {
+			subject = tokens.IDTokenClaims.Subject
+			idToken = models.AuthToken{
+				ID:         id,
+				Type:       models.IDTokenType,
+				Value:      tokens.IDToken,
+				ExpiresAt:  tokens.IDTokenClaims.GetExpiration(),
+				Subject:    subject,
+				ProviderID: c.getID(),
+			}
+		} else if c.userInfoURL != "" {
+			subject, err = c.userInfoSubject(r.Context(), tokens.AccessToken)
+			if err != nil {
+				slog.Error("could not get the subject from the user info endpoint", "error", err, "requestID", r.Header.Get(echo.HeaderXRequestID))
+				errorCallback(fmt.Errorf("failed to get the subject from the user info endpoint: %w", err))
+				return
+			}
+		}
 		accessToken := models.AuthToken{
 			ID:         id,
 			Type:       models.AccessTokenType,
@@ -64,14 +93,6 @@ func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback) func(
 			ExpiresAt:  refreshTokenClaims.Expiration.AsTime(),
 			ProviderID: c.getID(),
 		}
-		idToken := models.AuthToken{
-			ID:         id,
-			Type:       models.IDTokenType,
-			Value:      tokens.IDToken,
-			ExpiresAt:  tokens.IDTokenClaims.GetExpiration(),
-			Subject:    subject,
-			ProviderID: c.getID(),
-		}
 		tokenSet := models.AuthTokenSet{
 			AccessToken:  accessToken,
 			RefreshToken: refreshToken,
@@ -80,27 +101,87 @@ func (c *oidcClient) getCodeExchangeCallback(callback TokenSetCallback) func(
 		err = callback(tokenSet)
 		if err != nil {
 			slog.Error("error when running tokens callback", "error", err, "requestID", r.Header.Get(echo.HeaderXRequestID))
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			errorCallback(err)
 			return
 		}
 	}
 }
 
+// userInfoSubject reads the subject of an access token from the user info endpoint of an oauth2 provider
+func (c *oidcClient) userInfoSubject(ctx context.Context, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.userInfoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	req.Header.Set(echo.HeaderAccept, echo.MIMEApplicationJSON)
+	res, err := c.client.HttpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the user info endpoint responded with status %d", res.StatusCode)
+	}
+	userInfo := map[string]any{}
+	decoder := json.NewDecoder(res.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(&userInfo)
+	if err != nil {
+		return "", err
+	}
+	switch subject := userInfo[c.subjectClaim].(type) {
+	case string:
+		if subject != "" {
+			return subject, nil
+		}
+	case json.Number:
+		return subject.String(), nil
+	}
+	return "", fmt.Errorf("the user info response does not contain the subject field %s", c.subjectClaim)
+}
+
 // authHandler returns a http handler that can start the login flow and redirect
 // to the identity provider /authorization page, setting all required parameters
-// like state, client ID, secret, etc. We store the oAuth state values in the session
-// in Redis so the function here just forwards the state that was provided from the session.
-func (c *oidcClient) authHandler(state string) http.HandlerFunc {
-	stateFunc := func() string {
-		return state
+// like state, client ID, secret, etc. We store the oAuth state values and the PKCE code
+// verifier in the login flows in Redis so the function here just forwards them.
+func (c *oidcClient) authHandler(state string, codeVerifier string, extraParams map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := []rp.AuthURLOpt{}
+		if c.usePKCE {
+			opts = append(opts, rp.WithCodeChallenge(oidc.NewSHACodeChallenge(codeVerifier)))
+		}
+		for key, value := range extraParams {
+			opts = append(opts, rp.AuthURLOpt(rp.WithURLParam(key, value)))
+		}
+		http.Redirect(w, r, rp.AuthURL(state, c.client, opts...), http.StatusFound)
 	}
-	return rp.AuthURLHandler(stateFunc, c.client)
 }
 
 // Returns a http handler that will receive the authorization code from the identity provider.
 // swap it for an access token and then pass the access and refresh token to the callback function.
-func (c *oidcClient) codeExchangeHandler(callback TokenSetCallback) http.HandlerFunc {
-	return rp.CodeExchangeHandler(c.getCodeExchangeCallback(callback), c.client)
+// The state has to be checked against the login flow before calling the handler.
+// Errors of the identity provider and failed exchanges are passed to the error callback.
+func (c *oidcClient) codeExchangeHandler(callback TokenSetCallback, errorCallback ErrorCallback, codeVerifier string) http.HandlerFunc {
+	exchangeCallback := c.getCodeExchangeCallback(callback, errorCallback)
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := r.FormValue("state")
+		if errValue := r.FormValue("error"); errValue != "" {
+			errorCallback(ProviderError{Code: errValue, Description: r.FormValue("error_description")})
+			return
+		}
+		opts := []rp.CodeExchangeOpt{}
+		if c.usePKCE {
+			opts = append(opts, rp.WithCodeVerifier(codeVerifier))
+		}
+		tokens, err := rp.CodeExchange[*oidc.IDTokenClaims](r.Context(), r.FormValue("code"), c.client, opts...)
+		if err != nil {
+			slog.Error("code exchange failed", "error", err, "providerID", c.getID(), "requestID", r.Header.Get(echo.HeaderXRequestID))
+			errorCallback(fmt.Errorf("failed to exchange token: %w", err))
+			return
+		}
+		exchangeCallback(w, r, tokens, state, c.client)
+	}
 }
 
 func (c *oidcClient) getID() string {
@@ -121,6 +202,7 @@ func (c *oidcClient) refreshAccessToken(ctx context.Context, refreshToken models
 		Type:       models.AccessTokenType,
 		Value:      oAuth2Token.AccessToken,
 		TokenURL:   c.client.OAuthConfig().Endpoint.TokenURL,
+		Subject:    refreshToken.Subject,
 		ExpiresAt:  oAuth2Token.Expiry,
 		ProviderID: c.getID(),
 	}
@@ -136,6 +218,7 @@ func (c *oidcClient) refreshAccessToken(ctx context.Context, refreshToken models
 			Type:       models.RefreshTokenType,
 			Value:      oAuth2Token.RefreshToken,
 			TokenURL:   c.client.OAuthConfig().Endpoint.TokenURL,
+			Subject:    refreshToken.Subject,
 			ExpiresAt:  refreshTokenClaims.Expiration.AsTime(),
 			ProviderID: c.getID(),
 		}
@@ -170,7 +253,73 @@ func (c *oidcClient) refreshAccessToken(ctx context.Context, refreshToken models
 	return tokenSet, err
 }
 
+// revokeToken revokes an access or refresh token at the revocation endpoint of the provider (RFC 7009)
+func (c *oidcClient) revokeToken(ctx context.Context, token models.AuthToken) error {
+	var tokenTypeHint string
+	switch token.Type {
+	case models.AccessTokenType:
+		tokenTypeHint = "access_token"
+	case models.RefreshTokenType:
+		tokenTypeHint = "refresh_token"
+	default:
+		return fmt.Errorf("tokens of type %s cannot be revoked", token.Type)
+	}
+	return rp.RevokeToken(ctx, c.client, token.Value, tokenTypeHint)
+}
+
+// exchangeToken exchanges an access token for an access token with the given audience and scopes
+// at the token endpoint of the provider (RFC 8693)
+func (c *oidcClient) exchangeToken(ctx context.Context, subjectToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error) {
+	oauthConfig := c.client.OAuthConfig()
+	exchanger, err := tokenexchange.NewTokenExchangerClientCredentials(
+		ctx,
+		c.client.Issuer(),
+		oauthConfig.ClientID,
+		oauthConfig.ClientSecret,
+		tokenexchange.WithStaticTokenEndpoint(c.client.Issuer(), oauthConfig.Endpoint.TokenURL),
+		tokenexchange.WithHTTPClient(c.client.HttpClient()),
+	)
+	if err != nil {
+		return models.AuthToken{}, err
+	}
+	var audiences []string
+	if audience != "" {
+		audiences = []string{audience}
+	}
+	res, err := tokenexchange.ExchangeToken(
+		ctx,
+		exchanger,
+		subjectToken.Value,
+		oidc.AccessTokenType,
+		"",
+		"",
+		nil,
+		audiences,
+		scopes,
+		oidc.AccessTokenType,
+	)
+	if err != nil {
+		return models.AuthToken{}, err
+	}
+	// The exchanged token cannot outlive the token it was exchanged for when its lifetime is not known
+	expiresAt := subjectToken.ExpiresAt
+	if res.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+	return models.AuthToken{
+		Type:       models.AccessTokenType,
+		Value:      res.AccessToken,
+		TokenURL:   oauthConfig.Endpoint.TokenURL,
+		Subject:    subjectToken.Subject,
+		ExpiresAt:  expiresAt,
+		ProviderID: c.getID(),
+	}, nil
+}
+
 func (c *oidcClient) userProfileURL() (*url.URL, error) {
+	if c.client.IsOAuth2Only() {
+		return nil, fmt.Errorf("the provider with ID %s does not have a user profile page", c.getID())
+	}
 	profileURL, err := url.Parse(c.client.Issuer())
 	if err != nil {
 		return nil, err
@@ -185,57 +334,53 @@ func (c *oidcClient) userProfileURL() (*url.URL, error) {
 type clientOption func(*oidcClient) error
 
 func withOIDCConfig(clientConfig config.OIDCClient) clientOption {
-	validateConfig := func(clientConfig config.OIDCClient) error {
-		cookieEncKey := []byte(clientConfig.CookieEncodingKey)
-		cookieHashKey := []byte(clientConfig.CookieHashKey)
-		if len(cookieEncKey) > 0 && !(len(cookieEncKey) == 16 || len(cookieEncKey) == 32) {
-			return fmt.Errorf(
-				"invalid length for oauth2 state cookie encryption key, got %d, but allowed sizes are 16 or 32",
-				len(cookieEncKey),
-			)
-		}
-		if len(cookieHashKey) > 0 && len(cookieHashKey) != 32 {
-			return fmt.Errorf(
-				"invalid length for oauth2 state cookie hash key, got %d, allowed size is 32",
-				len(cookieHashKey),
-			)
-		}
-		return nil
-	}
-	makeClient := func(clientConfig config.OIDCClient) (rp.RelyingParty, error) {
-		options := []rp.Option{}
-		if !clientConfig.UnsafeNoCookieHandler {
-			cookieEncKey := []byte(clientConfig.CookieEncodingKey)
-			cookieHashKey := []byte(clientConfig.CookieHashKey)
-			if len(cookieEncKey) == 0 {
-				cookieEncKey = nil
-			}
-			cookieHandler := httphelper.NewCookieHandler(cookieHashKey, cookieEncKey)
-			options = append(options, rp.WithCookieHandler(cookieHandler))
-			if clientConfig.UsePKCE {
-				options = append(options, rp.WithPKCE(cookieHandler))
-			}
-		}
-		return rp.NewRelyingPartyOIDC(
+	return func(c *oidcClient) error {
+		client, err := rp.NewRelyingPartyOIDC(
 			context.TODO(),
 			clientConfig.Issuer,
 			clientConfig.ClientID,
 			string(clientConfig.ClientSecret),
 			clientConfig.CallbackURI,
 			clientConfig.Scopes,
-			options...,
 		)
-	}
-	return func(c *oidcClient) error {
-		err := validateConfig(clientConfig)
 		if err != nil {
 			return err
 		}
-		client, err := makeClient(clientConfig)
+		c.client = client
+		c.usePKCE = clientConfig.UsePKCE
+		return nil
+	}
+}
+
+// withOAuth2Config sets up a client for a provider which only supports OAuth 2.0, its endpoints
+// cannot be discovered so they have to be configured explicitly
+func withOAuth2Config(clientConfig config.OIDCClient) clientOption {
+	return func(c *oidcClient) error {
+		if clientConfig.AuthorizationURL == "" || clientConfig.TokenURL == "" {
+			return fmt.Errorf("the authorization URL and the token URL are required for oauth2 providers")
+		}
+		client, err := rp.NewRelyingPartyOAuth(
+			&oauth2.Config{
+				ClientID:     clientConfig.ClientID,
+				ClientSecret: string(clientConfig.ClientSecret),
+				RedirectURL:  clientConfig.CallbackURI,
+				Scopes:       clientConfig.Scopes,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  clientConfig.AuthorizationURL,
+					TokenURL: clientConfig.TokenURL,
+				},
+			},
+		)
 		if err != nil {
 			return err
 		}
 		c.client = client
+		c.usePKCE = clientConfig.UsePKCE
+		c.userInfoURL = clientConfig.UserInfoURL
+		c.subjectClaim = clientConfig.SubjectClaim
+		if c.subjectClaim == "" {
+			c.subjectClaim = "sub"
+		}
 		return nil
 	}
 }
@@ -12,25 +12,27 @@ import (
 
 type ClientStore map[string]oidcClient
 
-func (c ClientStore) AuthHandler(providerID string, state string) (http.HandlerFunc, error) {
+// AuthHandler returns a http handler that redirects to the authorization page of a provider,
+// the extra parameters are added to the authorization request
+func (c ClientStore) AuthHandler(providerID string, state string, codeVerifier string, extraParams map[string]string) (http.HandlerFunc, error) {
 	client, clientFound := c[providerID]
 	if !clientFound {
 		return nil, fmt.Errorf("cannot find the provider with ID %s", providerID)
 	}
-	return client.authHandler(state), nil
+	return client.authHandler(state, codeVerifier, extraParams), nil
 }
 
-type CodeExchangeHandlerFunc func(callback TokenSetCallback) http.HandlerFunc
+type CodeExchangeHandlerFunc func(callback TokenSetCallback, errorCallback ErrorCallback) http.HandlerFunc
 
 // Returns a http handler that will receive the authorization code from the identity provider.
 // swap it for an access token and then pass the access and refresh token to the callback function.
-func (c ClientStore) CodeExchangeHandler(providerID string) (CodeExchangeHandlerFunc, error) {
+func (c ClientStore) CodeExchangeHandler(providerID string, codeVerifier string) (CodeExchangeHandlerFunc, error) {
 	client, clientFound := c[providerID]
 	if !clientFound {
 		return nil, fmt.Errorf("cannot find the provider with ID %s", providerID)
 	}
-	return func(callback TokenSetCallback) http.HandlerFunc {
-		return client.codeExchangeHandler(callback)
+	return func(callback TokenSetCallback, errorCallback ErrorCallback) http.HandlerFunc {
+		return client.codeExchangeHandler(callback, errorCallback, codeVerifier)
 	}, nil
 }
 
@@ -43,6 +45,26 @@ func (c ClientStore) RefreshAccessToken(ctx context.Context, refreshToken models
 	return client.refreshAccessToken(ctx, refreshToken)
 }
 
+// RevokeToken revokes an access or refresh token at the revocation endpoint discovered for its provider
+func (c ClientStore) RevokeToken(ctx context.Context, token models.AuthToken) error {
+	providerID := token.ProviderID
+	client, clientFound := c[providerID]
+	if !clientFound {
+		return fmt.Errorf("cannot find the provider with ID %s", providerID)
+	}
+	return client.revokeToken(ctx, token)
+}
+
+// ExchangeToken exchanges an access token at the provider which issued it for a token with the given audience and scopes
+func (c ClientStore) ExchangeToken(ctx context.Context, subjectToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error) {
+	providerID := subjectToken.ProviderID
+	client, clientFound := c[providerID]
+	if !clientFound {
+		return models.AuthToken{}, fmt.Errorf("cannot find the provider with ID %s", providerID)
+	}
+	return client.exchangeToken(ctx, subjectToken, audience, scopes)
+}
+
 func (c ClientStore) UserProfileURL(providerID string) (*url.URL, error) {
 	client, clientFound := c[providerID]
 	if !clientFound {
@@ -53,8 +75,12 @@ func (c ClientStore) UserProfileURL(providerID string) (*url.URL, error) {
 
 func NewClientStore(configs map[string]config.OIDCClient) (ClientStore, error) {
 	var clients = ClientStore{}
-	for id, config := range configs {
-		client, err := newClient(id, withOIDCConfig(config))
+	for id, clientConfig := range configs {
+		clientOption := withOIDCConfig(clientConfig)
+		if clientConfig.Type == config.ProviderTypeOAuth2 {
+			clientOption = withOAuth2Config(clientConfig)
+		}
+		client, err := newClient(id, clientOption)
 		if err != nil {
 			return ClientStore{}, err
 		}
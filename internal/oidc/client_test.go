@@ -9,9 +9,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/go-jose/go-jose/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zitadel/oidc/v3/pkg/client/rp"
 	httphelper "github.com/zitadel/oidc/v3/pkg/http"
 	"github.com/zitadel/oidc/v3/pkg/oidc"
@@ -160,14 +162,16 @@ func TestTokenCallback(t *testing.T) {
 				)
 				return nil
 			}
-			codeExchangeCallback := client.getCodeExchangeCallback(tokenCallback)
+			var callbackErr error
+			errorCallback := func(err error) {
+				callbackErr = err
+			}
+			codeExchangeCallback := client.getCodeExchangeCallback(tokenCallback, errorCallback)
 			rec := httptest.NewRecorder()
 			codeExchangeCallback(rec, httptest.NewRequest("GET", "/", nil), &tokens, "state", client.client)
-			if testCase.Error != nil {
-				assert.Equal(t, http.StatusInternalServerError, rec.Result().StatusCode)
-			} else {
-				assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
-			}
+			// The errors are left to the error callback, nothing is written to the response
+			assert.Equal(t, testCase.Error, callbackErr)
+			assert.Empty(t, rec.Body.String())
 		}
 	}
 
@@ -176,3 +180,97 @@ func TestTokenCallback(t *testing.T) {
 	}
 
 }
+
+func TestOAuth2TokenCallback(t *testing.T) {
+	userInfo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer accessToken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": 12345, "login": "octocat"}`)
+	}))
+	defer userInfo.Close()
+	client, err := newClient("github", withOAuth2Config(config.OIDCClient{
+		Type:             config.ProviderTypeOAuth2,
+		ClientID:         "client-id",
+		AuthorizationURL: "https://github.example.org/login/oauth/authorize",
+		TokenURL:         "https://github.example.org/login/oauth/access_token",
+		UserInfoURL:      userInfo.URL,
+		SubjectClaim:     "id",
+	}))
+	require.NoError(t, err)
+	assert.True(t, client.client.IsOAuth2Only())
+	tokens := oidc.Tokens[*oidc.IDTokenClaims]{
+		Token: &oauth2.Token{
+			AccessToken:  "accessToken",
+			RefreshToken: "refreshToken",
+			Expiry:       time.Now().Add(time.Hour),
+		},
+	}
+	var tokenSet models.AuthTokenSet
+	tokenCallback := func(ts models.AuthTokenSet) error {
+		tokenSet = ts
+		return nil
+	}
+
+	rec := httptest.NewRecorder()
+	client.getCodeExchangeCallback(tokenCallback, func(err error) { require.NoError(t, err) })(rec, httptest.NewRequest("GET", "/", nil), &tokens, "state", client.client)
+
+	assert.Equal(t, http.StatusOK, rec.Result().StatusCode)
+	assert.Equal(t, "accessToken", tokenSet.AccessToken.Value)
+	assert.Equal(t, "12345", tokenSet.AccessToken.Subject)
+	assert.Equal(t, "https://github.example.org/login/oauth/access_token", tokenSet.AccessToken.TokenURL)
+	assert.Equal(t, "refreshToken", tokenSet.RefreshToken.Value)
+	assert.False(t, tokenSet.HasIDToken())
+	assert.NoError(t, tokenSet.ValidateTokensType())
+	_, err = client.userProfileURL()
+	assert.Error(t, err)
+}
+
+func TestExchangeToken(t *testing.T) {
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok || clientID != "renku" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:token-exchange", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "subjectToken", r.PostForm.Get("subject_token"))
+		assert.Equal(t, "urn:ietf:params:oauth:token-type:access_token", r.PostForm.Get("subject_token_type"))
+		assert.Equal(t, "data-service", r.PostForm.Get("audience"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "exchangedToken", "issued_token_type": "urn:ietf:params:oauth:token-type:access_token", "token_type": "Bearer", "expires_in": 300}`)
+	}))
+	defer tokenEndpoint.Close()
+	client, err := newClient("renku", withOAuth2Config(config.OIDCClient{
+		Type:             config.ProviderTypeOAuth2,
+		ClientID:         "renku",
+		ClientSecret:     "secret",
+		AuthorizationURL: "https://keycloak.example.org/auth",
+		TokenURL:         tokenEndpoint.URL,
+	}))
+	require.NoError(t, err)
+	subjectToken := models.AuthToken{
+		ID:         "renku:token",
+		Type:       models.AccessTokenType,
+		Value:      "subjectToken",
+		Subject:    "user",
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ProviderID: "renku",
+	}
+
+	token, err := ClientStore{"renku": client}.ExchangeToken(context.Background(), subjectToken, "data-service", []string{"read", "write"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "exchangedToken", token.Value)
+	assert.Equal(t, models.AccessTokenType, token.Type)
+	assert.Equal(t, "user", token.Subject)
+	assert.Equal(t, "renku", token.ProviderID)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), token.ExpiresAt, 5*time.Second)
+
+	_, err = ClientStore{"renku": client}.ExchangeToken(context.Background(), models.AuthToken{ProviderID: "gitlab"}, "data-service", nil)
+	assert.ErrorContains(t, err, "cannot find the provider with ID gitlab")
+}
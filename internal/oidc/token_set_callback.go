@@ -1,7 +1,25 @@
 package oidc
 
 import (
+	"fmt"
+
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 )
 
 type TokenSetCallback func(tokenSet models.AuthTokenSet) error
+
+// ErrorCallback receives the errors of a code exchange, nothing is written to the response before it is called
+type ErrorCallback func(err error)
+
+// ProviderError is an error that the identity provider sent to the callback instead of an authorization code
+type ProviderError struct {
+	Code        string
+	Description string
+}
+
+func (e ProviderError) Error() string {
+	if e.Description == "" {
+		return fmt.Sprintf("the identity provider returned the error %s", e.Code)
+	}
+	return fmt.Sprintf("the identity provider returned the error %s: %s", e.Code, e.Description)
+}
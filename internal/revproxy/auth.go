@@ -13,7 +13,6 @@ import (
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
 	"github.com/labstack/echo/v4"
-	"github.com/zitadel/oidc/v3/pkg/oidc"
 )
 
 type AuthOption func(*Auth)
@@ -110,6 +109,13 @@ func WithTokenType(tokenType models.OauthTokenType) AuthOption {
 	}
 }
 
+// WithTokenExchange exchanges the access token for a token with the given audience and scopes before it is injected
+func WithTokenExchange(audience string, scopes []string) AuthOption {
+	return func(a *Auth) {
+		a.exchange = &tokenExchange{audience: audience, scopes: scopes}
+	}
+}
+
 func AuthWithSessionStore(sessions *sessions.SessionStore) AuthOption {
 	return func(a *Auth) {
 		a.sessions = sessions
@@ -122,6 +128,13 @@ type Auth struct {
 	tokenInjector TokenInjector
 	providerID    string
 	tokenType     models.OauthTokenType
+	exchange      *tokenExchange
+}
+
+// tokenExchange describes the token that an access token is exchanged for before it is injected
+type tokenExchange struct {
+	audience string
+	scopes   []string
 }
 
 func NewAuth(options ...AuthOption) (Auth, error) {
@@ -138,6 +151,9 @@ func NewAuth(options ...AuthOption) (Auth, error) {
 	if auth.tokenType != models.AccessTokenType && auth.tokenType != models.RefreshTokenType && auth.tokenType != models.IDTokenType {
 		return Auth{}, fmt.Errorf("unknown token type in authentication middleware %s", auth.tokenType)
 	}
+	if auth.exchange != nil && auth.tokenType != models.AccessTokenType {
+		return Auth{}, fmt.Errorf("only access tokens can be exchanged, got %s", auth.tokenType)
+	}
 	return auth, nil
 }
 
@@ -221,6 +237,28 @@ func (a *Auth) Middleware() echo.MiddlewareFunc {
 					return next(c)
 				}
 			}
+			if a.exchange != nil {
+				token, err = a.sessions.ExchangeAccessToken(c, token, a.exchange.audience, a.exchange.scopes)
+				if err != nil {
+					// The access token is not injected as it would give the upstream more privileges than intended
+					slog.Warn(
+						"PROXY AUTH MIDDLEWARE",
+						"message",
+						"token exchange failed, continuing with middleware chain",
+						"error",
+						err,
+						"sessionID",
+						session.ID,
+						"providerID",
+						a.providerID,
+						"audience",
+						a.exchange.audience,
+						"requestID",
+						utils.GetRequestID(c),
+					)
+					return next(c)
+				}
+			}
 			err = a.tokenInjector(c, token)
 			if err != nil {
 				return err
@@ -296,60 +334,6 @@ var notebooksGitlabAccessTokenInjector TokenInjector = func(c echo.Context, acce
 	return nil
 }
 
-var coreSvcRenkuIdTokenInjector TokenInjector = func(c echo.Context, idToken models.AuthToken) error {
-	headerKey := "Renku-User"
-	existingToken := c.Request().Header.Get(headerKey)
-	if existingToken != "" {
-		slog.Debug(
-			"PROXY AUTH MIDDLEWARE",
-			"message",
-			"token already present in header, skipping",
-			"header",
-			headerKey,
-			"token",
-			idToken.String(),
-			"requestID",
-			utils.GetRequestID(c),
-		)
-		return nil
-	}
-
-	var claims oidc.IDTokenClaims
-	_, err := oidc.ParseToken(idToken.Value, &claims)
-	if err != nil {
-		return err
-	}
-	userId := claims.Subject
-	email := claims.Email
-	name := claims.Name
-
-	slog.Debug(
-		"PROXY AUTH MIDDLEWARE",
-		"message",
-		"injected token in header",
-		"header",
-		headerKey,
-		"token",
-		idToken.String(),
-		"requestID",
-		utils.GetRequestID(c),
-	)
-	c.Request().Header.Set(headerKey, idToken.Value)
-	slog.Debug(
-		"PROXY AUTH MIDDLEWARE",
-		"message",
-		"injected user info in header",
-		"Renku-user-id",
-		userId,
-		"requestID",
-		utils.GetRequestID(c),
-	)
-	c.Request().Header.Set("Renku-user-id", userId)
-	c.Request().Header.Set("Renku-user-email", base64.StdEncoding.EncodeToString([]byte(email)))
-	c.Request().Header.Set("Renku-user-fullname", base64.StdEncoding.EncodeToString([]byte(name)))
-	return nil
-}
-
 // Sets up Basic Auth for Gitlab
 var gitlabCliTokenInjector TokenInjector = func(c echo.Context, accessToken models.AuthToken) error {
 	if accessToken.Value == "" {
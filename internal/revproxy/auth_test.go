@@ -0,0 +1,57 @@
+package revproxy
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenExchangeRequiresAccessTokens(t *testing.T) {
+	_, err := NewAuth(
+		AuthWithSessionStore(&sessions.SessionStore{}),
+		WithTokenType(models.RefreshTokenType),
+		InjectInHeader("Renku-Auth-Refresh-Token"),
+		WithTokenExchange("data-service", nil),
+	)
+	assert.ErrorContains(t, err, "only access tokens can be exchanged, got RefreshToken")
+
+	auth, err := NewAuth(
+		AuthWithSessionStore(&sessions.SessionStore{}),
+		InjectBearerToken(),
+		WithTokenExchange("data-service", []string{"read"}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, &tokenExchange{audience: "data-service", scopes: []string{"read"}}, auth.exchange)
+}
+
+func TestTokenExchangeRoutes(t *testing.T) {
+	upstream, err := url.Parse("http://search-service")
+	require.NoError(t, err)
+	rpConfig := config.RevproxyConfig{
+		RenkuBaseURL: upstream,
+		RenkuServices: config.RenkuServicesConfig{
+			DataService: upstream,
+			Keycloak:    upstream,
+			UIServer:    upstream,
+		},
+		Routes: []config.RouteConfig{{
+			PathPrefix:    "/api/search",
+			Upstream:      upstream,
+			Auth:          []string{renkuRefreshTokenAuthName},
+			TokenExchange: config.TokenExchangeConfig{Enabled: true},
+		}},
+	}
+	proxy := Revproxy{config: &rpConfig, sessions: &sessions.SessionStore{}}
+	require.NoError(t, proxy.initializeAuth())
+
+	err = proxy.initializeRoutes()
+	assert.ErrorContains(t, err, "the route /api/search exchanges tokens but it does not use the renkuAccessToken authentication middleware")
+
+	rpConfig.Routes[0].Auth = []string{renkuAccessTokenAuthName}
+	assert.NoError(t, proxy.initializeRoutes())
+}
@@ -0,0 +1,150 @@
+package revproxy
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// authorize rejects the requests which do not meet the authorization rules that match them before they are proxied.
+// The rules are checked after the rewrites of a route against both the requested and the rewritten path, so that
+// an alias of a protected path is protected as well. Requests from users who are not logged in get a 401, requests
+// from logged in users that are not allowed get a 403. Claims are read from the access token which authenticates
+// the request: the verified bearer token presented by the client, or else the access token of the session.
+func authorize(sessions *sessions.SessionStore, rules []config.AuthorizationRule) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, requestPath := range requestPaths(c) {
+				rule, found := matchAuthorizationRule(rules, c.Request().Method, requestPath)
+				if !found {
+					continue
+				}
+				err := checkAuthorizationRule(c, sessions, rule)
+				if err != nil {
+					return err
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// checkAuthorizationRule returns an HTTP error when the request does not meet the rule
+func checkAuthorizationRule(c echo.Context, sessions *sessions.SessionStore, rule config.AuthorizationRule) error {
+	authenticated := false
+	session, err := sessions.Get(c)
+	if err == nil && session.UserID != "" {
+		authenticated = true
+	}
+	switch rule.Require {
+	case config.RequireAnonymous:
+		if authenticated {
+			return authorizationDenied(c, rule, http.StatusForbidden, "the route is only available to anonymous users")
+		}
+		return nil
+	case config.RequireAuthenticated:
+		if !authenticated {
+			return authorizationDenied(c, rule, http.StatusUnauthorized, "authentication is required")
+		}
+		return nil
+	case config.RequireClaim:
+		if !authenticated {
+			return authorizationDenied(c, rule, http.StatusUnauthorized, "authentication is required")
+		}
+		accessToken, err := sessions.GetAccessToken(c, *session, models.PrimaryProviderID)
+		if err != nil {
+			return authorizationDenied(c, rule, http.StatusUnauthorized, "authentication is required")
+		}
+		var claims map[string]any
+		_, err = oidc.ParseToken(accessToken.Value, &claims)
+		if err != nil {
+			slog.Warn(
+				"AUTHORIZATION",
+				"message",
+				"could not parse the access token",
+				"error",
+				err,
+				"requestID",
+				utils.GetRequestID(c),
+			)
+			return authorizationDenied(c, rule, http.StatusUnauthorized, "authentication is required")
+		}
+		if !claimContainsAny(claims, rule.Claim, rule.Values) {
+			return authorizationDenied(c, rule, http.StatusForbidden, "you do not have the permissions to access this route")
+		}
+		return nil
+	default:
+		return authorizationDenied(c, rule, http.StatusForbidden, "access to this route is not allowed")
+	}
+}
+
+// matchAuthorizationRule returns the rule with the longest path prefix which matches the method and path
+func matchAuthorizationRule(rules []config.AuthorizationRule, method, path string) (config.AuthorizationRule, bool) {
+	var output config.AuthorizationRule
+	found := false
+	for _, rule := range rules {
+		if !matchesPathPrefix(path, rule.PathPrefix) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !slices.ContainsFunc(rule.Methods, func(m string) bool { return strings.EqualFold(m, method) }) {
+			continue
+		}
+		if !found || len(rule.PathPrefix) > len(output.PathPrefix) {
+			output = rule
+			found = true
+		}
+	}
+	return output, found
+}
+
+// claimContainsAny checks if a claim contains at least one of the values, the claim can be a string
+// or a list of strings and nested claims are separated by dots, i.e. realm_access.roles
+func claimContainsAny(claims map[string]any, claim string, values []string) bool {
+	var current any = claims
+	for _, key := range strings.Split(claim, ".") {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return false
+		}
+		current, ok = object[key]
+		if !ok {
+			return false
+		}
+	}
+	switch claimValue := current.(type) {
+	case string:
+		return slices.Contains(values, claimValue)
+	case []any:
+		for _, item := range claimValue {
+			if value, ok := item.(string); ok && slices.Contains(values, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func authorizationDenied(c echo.Context, rule config.AuthorizationRule, status int, message string) error {
+	slog.Info(
+		"AUTHORIZATION",
+		"message",
+		"request rejected",
+		"pathPrefix",
+		rule.PathPrefix,
+		"require",
+		rule.Require,
+		"status",
+		status,
+		"requestID",
+		utils.GetRequestID(c),
+	)
+	return echo.NewHTTPError(status, message)
+}
@@ -0,0 +1,208 @@
+package revproxy
+
+import (
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenMapClaimsValue(claims jwt.MapClaims) tokenOption {
+	return func(t *models.AuthToken) {
+		claims["exp"] = time.Now().Add(time.Hour * 5).Unix()
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+		signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		t.Value = signed
+	}
+}
+
+func TestMatchAuthorizationRule(t *testing.T) {
+	rules := []config.AuthorizationRule{
+		{PathPrefix: "/api/data", Methods: []string{"POST", "DELETE"}, Require: config.RequireAuthenticated},
+		{PathPrefix: "/api/data/admin", Require: config.RequireClaim, Claim: "groups", Values: []string{"admins"}},
+	}
+	rule, found := matchAuthorizationRule(rules, http.MethodPost, "/api/data/projects")
+	assert.True(t, found)
+	assert.Equal(t, config.RequireAuthenticated, rule.Require)
+	rule, found = matchAuthorizationRule(rules, http.MethodDelete, "/api/data/admin/users")
+	assert.True(t, found)
+	assert.Equal(t, config.RequireClaim, rule.Require)
+	_, found = matchAuthorizationRule(rules, http.MethodGet, "/api/data/projects")
+	assert.False(t, found)
+	_, found = matchAuthorizationRule(rules, http.MethodPost, "/api/database")
+	assert.False(t, found)
+}
+
+func TestClaimContainsAny(t *testing.T) {
+	claims := map[string]any{
+		"groups":       []any{"users", "admins"},
+		"realm_access": map[string]any{"roles": []any{"offline_access", "renku-admin"}},
+		"tier":         "gold",
+	}
+	assert.True(t, claimContainsAny(claims, "groups", []string{"admins"}))
+	assert.True(t, claimContainsAny(claims, "realm_access.roles", []string{"renku-admin", "other"}))
+	assert.True(t, claimContainsAny(claims, "tier", []string{"gold"}))
+	assert.False(t, claimContainsAny(claims, "groups", []string{"renku-admin"}))
+	assert.False(t, claimContainsAny(claims, "realm_access.groups", []string{"admins"}))
+	assert.False(t, claimContainsAny(claims, "tier.level", []string{"gold"}))
+	assert.False(t, claimContainsAny(claims, "missing", []string{"admins"}))
+}
+
+func TestAuthorizationRoutes(t *testing.T) {
+	authorization := []config.AuthorizationRule{
+		{PathPrefix: "/api/data/admin", Require: config.RequireClaim, Claim: "realm_access.roles", Values: []string{"renku-admin"}},
+		{PathPrefix: "/api/data/user", Require: config.RequireAuthenticated},
+		{PathPrefix: "/api/data/signup", Require: config.RequireAnonymous},
+		{PathPrefix: "/api/data/notebooks/admin", Require: config.RequireAuthenticated},
+		{PathPrefix: "/api/kc/admin", Require: config.RequireAuthenticated},
+	}
+	testSession := newTestSesssion(
+		sessionID("sessionID"),
+		withUserID("user"),
+		withTokenIDs(map[string]string{"renku": "renku:myToken"}),
+	)
+	sessionCookie := &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"}
+	adminToken := newTestToken(
+		models.AccessTokenType,
+		tokenID("renku:myToken"),
+		tokenMapClaimsValue(jwt.MapClaims{"realm_access": map[string]any{"roles": []string{"renku-admin"}}}),
+		tokenProviderID("renku"),
+	)
+	userToken := newTestToken(
+		models.AccessTokenType,
+		tokenID("renku:myToken"),
+		tokenMapClaimsValue(jwt.MapClaims{"realm_access": map[string]any{"roles": []string{"offline_access"}}}),
+		tokenProviderID("renku"),
+	)
+	// Clients which present a bearer token are authorized with the claims of that token,
+	// even when the user has other tokens stored by the gateway
+	issuer := newTestIssuer(t)
+	storedAdminToken := newTestToken(
+		models.AccessTokenType,
+		tokenID(models.TokenID(models.PrimaryProviderID, "user")),
+		tokenMapClaimsValue(jwt.MapClaims{"realm_access": map[string]any{"roles": []string{"renku-admin"}}}),
+		tokenProviderID("renku"),
+	)
+	testCases := []TestCase{
+		{
+			Path:          "/api/data/admin/users",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusUnauthorized},
+		},
+		{
+			Path:          "/api/data/admin/users",
+			Authorization: authorization,
+			TokenIssuer:   issuer,
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization: "Bearer " + issuer.accessToken(t, jwt.MapClaims{"realm_access": map[string]any{"roles": []string{"renku-admin"}}}),
+			},
+			Expected: TestResults{Path: "/api/data/admin/users", VisitedServerIDs: []string{"upstream"}},
+		},
+		{
+			Path:          "/api/data/admin/users",
+			Authorization: authorization,
+			TokenIssuer:   issuer,
+			Tokens:        []models.AuthToken{storedAdminToken},
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization: "Bearer " + issuer.accessToken(t, jwt.MapClaims{"realm_access": map[string]any{"roles": []string{"offline_access"}}}),
+			},
+			Expected: TestResults{Non200ResponseStatusCode: http.StatusForbidden},
+		},
+		{
+			Path:          "/api/data/admin/users",
+			Authorization: authorization,
+			Tokens:        []models.AuthToken{userToken},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: sessionCookie,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusForbidden},
+		},
+		{
+			Path:          "/api/data/admin/users",
+			Authorization: authorization,
+			Tokens:        []models.AuthToken{adminToken},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: sessionCookie,
+			Expected:      TestResults{Path: "/api/data/admin/users", VisitedServerIDs: []string{"upstream"}},
+		},
+		{
+			Path:          "/api/data/user",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusUnauthorized},
+		},
+		{
+			Path:          "/api/data/user",
+			Authorization: authorization,
+			Tokens:        []models.AuthToken{userToken},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: sessionCookie,
+			Expected:      TestResults{Path: "/api/data/user", VisitedServerIDs: []string{"upstream"}},
+		},
+		{
+			Path:          "/api/data/signup",
+			Authorization: authorization,
+			Expected:      TestResults{Path: "/api/data/signup", VisitedServerIDs: []string{"upstream"}},
+		},
+		{
+			Path:          "/api/data/signup",
+			Authorization: authorization,
+			Tokens:        []models.AuthToken{userToken},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: sessionCookie,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusForbidden},
+		},
+		{
+			Path:          "/api/data/projects",
+			Authorization: authorization,
+			Expected:      TestResults{Path: "/api/data/projects", VisitedServerIDs: []string{"upstream"}},
+		},
+		// The rules apply to the path that an alias is rewritten to
+		{
+			Path:          "/api/notebooks/admin/servers",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusUnauthorized},
+		},
+		{
+			Path:          "/api/notebooks/admin/servers",
+			Authorization: authorization,
+			Tokens:        []models.AuthToken{userToken},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: sessionCookie,
+			Expected:      TestResults{Path: "/api/data/notebooks/admin/servers", VisitedServerIDs: []string{"upstream"}},
+		},
+		// The rules also apply to the path requested by the client when a route strips its prefix
+		{
+			Path:          "/api/kc/admin/realms",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusUnauthorized},
+		},
+		// Non-normalized paths cannot get around the rules
+		{
+			Path:          "/api/data//admin/users",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusBadRequest},
+		},
+		{
+			Path:          "/api/data/./admin/users",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusBadRequest},
+		},
+		{
+			Path:          "/api/data/projects/../admin/users",
+			Authorization: authorization,
+			Expected:      TestResults{Non200ResponseStatusCode: http.StatusBadRequest},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Path, ParametrizedRouteTest(testCase))
+	}
+}
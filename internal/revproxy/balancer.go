@@ -0,0 +1,228 @@
+package revproxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	defaultHealthCheckInterval time.Duration = 10 * time.Second
+	defaultHealthCheckTimeout  time.Duration = 2 * time.Second
+)
+
+type upstreamTarget struct {
+	target *middleware.ProxyTarget
+	// Result of the active health checks
+	healthy              bool
+	consecutiveProbesOK  int
+	consecutiveProbesBad int
+	// Result of the passive checks on proxied requests
+	consecutiveFailures int
+	ejectedUntil        time.Time
+}
+
+// available returns true if the target can receive requests
+func (t *upstreamTarget) available(now time.Time) bool {
+	return t.healthy && !now.Before(t.ejectedUntil)
+}
+
+// upstreamBalancer implements middleware.ProxyBalancer. It balances requests in a round-robin fashion
+// between the targets of an upstream that pass the health checks and have not been ejected.
+type upstreamBalancer struct {
+	name                   string
+	targets                []*upstreamTarget
+	maxConsecutiveFailures int
+	ejectionDuration       time.Duration
+	healthCheck            config.HealthCheckConfig
+	httpClient             *http.Client
+	next                   int
+	lock                   sync.Mutex
+}
+
+func (b *upstreamBalancer) AddTarget(target *middleware.ProxyTarget) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, t := range b.targets {
+		if t.target.Name == target.Name {
+			return false
+		}
+	}
+	b.targets = append(b.targets, &upstreamTarget{target: target, healthy: true})
+	upstreamTargetHealthy.WithLabelValues(b.name, target.Name).Set(1)
+	upstreamTargetEjected.WithLabelValues(b.name, target.Name).Set(0)
+	return true
+}
+
+func (b *upstreamBalancer) RemoveTarget(name string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for i, t := range b.targets {
+		if t.target.Name == name {
+			b.targets = append(b.targets[:i], b.targets[i+1:]...)
+			upstreamTargetHealthy.DeleteLabelValues(b.name, name)
+			upstreamTargetEjected.DeleteLabelValues(b.name, name)
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the next available target. If no target is available all targets are
+// used, it is better to try an unhealthy target than to fail every request.
+func (b *upstreamBalancer) Next(c echo.Context) *middleware.ProxyTarget {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.targets) == 0 {
+		return nil
+	}
+	now := time.Now()
+	available := make([]*upstreamTarget, 0, len(b.targets))
+	for _, t := range b.targets {
+		if !t.ejectedUntil.IsZero() && !now.Before(t.ejectedUntil) {
+			// The ejection is over
+			t.ejectedUntil = time.Time{}
+			upstreamTargetEjected.WithLabelValues(b.name, t.target.Name).Set(0)
+		}
+		if t.available(now) {
+			available = append(available, t)
+		}
+	}
+	if len(available) == 0 {
+		slog.Warn("PROXY", "message", "no healthy targets are available, using all targets", "upstream", b.name, "requestID", c.Response().Header().Get(echo.HeaderXRequestID))
+		available = b.targets
+	}
+	b.next = (b.next + 1) % len(available)
+	return available[b.next].target
+}
+
+// reportResult records the outcome of a proxied request and ejects the target
+// after too many consecutive failures
+func (b *upstreamBalancer) reportResult(host string, failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t := b.findByHost(host)
+	if t == nil {
+		return
+	}
+	if !failed {
+		t.consecutiveFailures = 0
+		return
+	}
+	t.consecutiveFailures++
+	if b.maxConsecutiveFailures <= 0 || t.consecutiveFailures < b.maxConsecutiveFailures {
+		return
+	}
+	t.consecutiveFailures = 0
+	t.ejectedUntil = time.Now().Add(b.ejectionDuration)
+	upstreamTargetEjected.WithLabelValues(b.name, t.target.Name).Set(1)
+	upstreamTargetEjections.WithLabelValues(b.name, t.target.Name).Inc()
+	slog.Warn("PROXY", "message", "ejected upstream target after consecutive failures", "upstream", b.name, "target", t.target.Name, "ejectedUntil", t.ejectedUntil)
+}
+
+// reportProbe records the outcome of an active health check
+func (b *upstreamBalancer) reportProbe(t *upstreamTarget, ok bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if ok {
+		t.consecutiveProbesBad = 0
+		t.consecutiveProbesOK++
+		if !t.healthy && t.consecutiveProbesOK >= max(b.healthCheck.HealthyThreshold, 1) {
+			t.healthy = true
+			upstreamTargetHealthy.WithLabelValues(b.name, t.target.Name).Set(1)
+			slog.Info("PROXY", "message", "upstream target is healthy", "upstream", b.name, "target", t.target.Name)
+		}
+		return
+	}
+	t.consecutiveProbesOK = 0
+	t.consecutiveProbesBad++
+	if t.healthy && t.consecutiveProbesBad >= max(b.healthCheck.UnhealthyThreshold, 1) {
+		t.healthy = false
+		upstreamTargetHealthy.WithLabelValues(b.name, t.target.Name).Set(0)
+		slog.Warn("PROXY", "message", "upstream target is unhealthy", "upstream", b.name, "target", t.target.Name)
+	}
+}
+
+func (b *upstreamBalancer) findByHost(host string) *upstreamTarget {
+	for _, t := range b.targets {
+		if t.target.URL.Host == host {
+			return t
+		}
+	}
+	return nil
+}
+
+// probe sends a single health check request to a target
+func (b *upstreamBalancer) probe(ctx context.Context, t *upstreamTarget) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.target.URL.JoinPath(b.healthCheck.Path).String(), nil)
+	if err != nil {
+		return false
+	}
+	res, err := b.httpClient.Do(req)
+	if err != nil {
+		slog.Debug("PROXY", "message", "health check failed", "upstream", b.name, "target", t.target.Name, "error", err)
+		return false
+	}
+	defer res.Body.Close()
+	return res.StatusCode >= 200 && res.StatusCode < 400
+}
+
+// checkHealth probes all the targets of the upstream once
+func (b *upstreamBalancer) checkHealth(ctx context.Context) {
+	b.lock.Lock()
+	targets := make([]*upstreamTarget, len(b.targets))
+	copy(targets, b.targets)
+	b.lock.Unlock()
+	for _, t := range targets {
+		b.reportProbe(t, b.probe(ctx, t))
+	}
+}
+
+// startHealthChecks probes all the targets periodically until the context is cancelled
+func (b *upstreamBalancer) startHealthChecks(ctx context.Context) {
+	interval := time.Duration(b.healthCheck.IntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultHealthCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			b.checkHealth(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func newUpstreamBalancer(upstream config.UpstreamConfig) *upstreamBalancer {
+	timeout := time.Duration(upstream.HealthCheck.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	b := upstreamBalancer{
+		name:                   upstream.URL.String(),
+		maxConsecutiveFailures: upstream.MaxConsecutiveFailures,
+		ejectionDuration:       time.Duration(upstream.EjectionSeconds) * time.Second,
+		healthCheck:            upstream.HealthCheck,
+		httpClient:             &http.Client{Timeout: timeout},
+		next:                   -1,
+	}
+	targets := upstream.Targets
+	if len(targets) == 0 {
+		targets = append(targets, upstream.URL)
+	}
+	for _, target := range targets {
+		b.AddTarget(&middleware.ProxyTarget{Name: target.String(), URL: target})
+	}
+	return &b
+}
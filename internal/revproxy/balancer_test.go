@@ -0,0 +1,148 @@
+package revproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	parsed, err := url.Parse(raw)
+	require.NoError(t, err)
+	return parsed
+}
+
+func newTestBalancerContext() echo.Context {
+	e := echo.New()
+	return e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	balancer := newUpstreamBalancer(config.UpstreamConfig{
+		URL:     mustParseURL(t, "http://upstream"),
+		Targets: []*url.URL{mustParseURL(t, "http://target-1"), mustParseURL(t, "http://target-2")},
+	})
+	c := newTestBalancerContext()
+
+	assert.Equal(t, "http://target-1", balancer.Next(c).Name)
+	assert.Equal(t, "http://target-2", balancer.Next(c).Name)
+	assert.Equal(t, "http://target-1", balancer.Next(c).Name)
+}
+
+func TestBalancerDefaultsToUpstreamURL(t *testing.T) {
+	balancer := newUpstreamBalancer(config.UpstreamConfig{URL: mustParseURL(t, "http://upstream")})
+	c := newTestBalancerContext()
+
+	assert.Equal(t, "http://upstream", balancer.Next(c).Name)
+	assert.Equal(t, "http://upstream", balancer.Next(c).Name)
+}
+
+func TestBalancerEjectsFailingTarget(t *testing.T) {
+	balancer := newUpstreamBalancer(config.UpstreamConfig{
+		URL:                    mustParseURL(t, "http://upstream"),
+		Targets:                []*url.URL{mustParseURL(t, "http://target-1"), mustParseURL(t, "http://target-2")},
+		MaxConsecutiveFailures: 2,
+		EjectionSeconds:        60,
+	})
+	c := newTestBalancerContext()
+
+	balancer.reportResult("target-1", true)
+	balancer.reportResult("target-1", false)
+	balancer.reportResult("target-1", true)
+	assert.True(t, balancer.targets[0].available(time.Now()))
+	balancer.reportResult("target-1", true)
+	assert.False(t, balancer.targets[0].available(time.Now()))
+
+	for range 3 {
+		assert.Equal(t, "http://target-2", balancer.Next(c).Name)
+	}
+
+	// The target is used again once the ejection is over
+	balancer.targets[0].ejectedUntil = time.Now().Add(-time.Second)
+	names := []string{balancer.Next(c).Name, balancer.Next(c).Name}
+	assert.ElementsMatch(t, []string{"http://target-1", "http://target-2"}, names)
+}
+
+func TestBalancerUsesAllTargetsWhenNoneAvailable(t *testing.T) {
+	balancer := newUpstreamBalancer(config.UpstreamConfig{
+		URL:                    mustParseURL(t, "http://upstream"),
+		MaxConsecutiveFailures: 1,
+		EjectionSeconds:        60,
+	})
+	c := newTestBalancerContext()
+
+	balancer.reportResult("upstream", true)
+
+	assert.Equal(t, "http://upstream", balancer.Next(c).Name)
+}
+
+func TestBalancerHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy.Load() && r.URL.Path == "/health" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	balancer := newUpstreamBalancer(config.UpstreamConfig{
+		URL:     mustParseURL(t, "http://upstream"),
+		Targets: []*url.URL{mustParseURL(t, srv.URL), mustParseURL(t, "http://127.0.0.1:1")},
+		HealthCheck: config.HealthCheckConfig{
+			Enabled:            true,
+			Path:               "/health",
+			UnhealthyThreshold: 2,
+		},
+	})
+	c := newTestBalancerContext()
+
+	balancer.checkHealth(context.Background())
+	assert.True(t, balancer.targets[1].healthy)
+	balancer.checkHealth(context.Background())
+	assert.True(t, balancer.targets[0].healthy)
+	assert.False(t, balancer.targets[1].healthy)
+	for range 3 {
+		assert.Equal(t, srv.URL, balancer.Next(c).Name)
+	}
+
+	healthy.Store(false)
+	balancer.checkHealth(context.Background())
+	balancer.checkHealth(context.Background())
+	assert.False(t, balancer.targets[0].healthy)
+
+	healthy.Store(true)
+	balancer.checkHealth(context.Background())
+	assert.True(t, balancer.targets[0].healthy)
+}
+
+func TestBalancerHealthChecksStop(t *testing.T) {
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	balancer := newUpstreamBalancer(config.UpstreamConfig{
+		URL:         mustParseURL(t, srv.URL),
+		HealthCheck: config.HealthCheckConfig{Enabled: true, Path: "/health", IntervalSeconds: 1},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	balancer.startHealthChecks(ctx)
+	require.Eventually(t, func() bool { return probes.Load() == 1 }, time.Second, 10*time.Millisecond)
+	cancel()
+
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, int32(1), probes.Load())
+}
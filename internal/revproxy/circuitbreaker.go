@@ -0,0 +1,202 @@
+package revproxy
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultCircuitMinimumRequests int           = 20
+	defaultCircuitWindow          time.Duration = 30 * time.Second
+	defaultCircuitOpenDuration    time.Duration = 30 * time.Second
+)
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker stops sending requests to an upstream whose error rate is too high. Once open, the breaker
+// rejects all requests until the open duration is over, then it lets a limited number of probe requests
+// through and closes again only if all of them succeed.
+type circuitBreaker struct {
+	name               string
+	errorRateThreshold float64
+	minimumRequests    int
+	window             time.Duration
+	openDuration       time.Duration
+	halfOpenRequests   int
+	state              circuitState
+	windowStart        time.Time
+	requests           int
+	failures           int
+	openUntil          time.Time
+	halfOpenInFlight   int
+	halfOpenSuccesses  int
+	// generation is incremented on every transition so that requests allowed in a previous state are ignored
+	generation uint64
+	lock       sync.Mutex
+}
+
+// allow returns true and the current generation if a request can be sent to the upstream,
+// otherwise it returns how long the caller should wait before trying again
+func (b *circuitBreaker) allow(now time.Time) (bool, uint64, time.Duration) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case circuitOpen:
+		if now.Before(b.openUntil) {
+			return false, b.generation, b.openUntil.Sub(now)
+		}
+		b.transition(circuitHalfOpen, now)
+		fallthrough
+	case circuitHalfOpen:
+		if b.halfOpenInFlight+b.halfOpenSuccesses >= b.halfOpenRequests {
+			return false, b.generation, b.openDuration
+		}
+		b.halfOpenInFlight++
+		return true, b.generation, 0
+	default:
+		return true, b.generation, 0
+	}
+}
+
+// report records the outcome of a request that was allowed by the breaker
+func (b *circuitBreaker) report(now time.Time, generation uint64, failed bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case circuitHalfOpen:
+		b.halfOpenInFlight = max(b.halfOpenInFlight-1, 0)
+		if failed {
+			b.transition(circuitOpen, now)
+			return
+		}
+		b.halfOpenSuccesses++
+		if b.halfOpenSuccesses >= b.halfOpenRequests {
+			b.transition(circuitClosed, now)
+		}
+	case circuitClosed:
+		if now.Sub(b.windowStart) >= b.window {
+			b.resetWindow(now)
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.minimumRequests && float64(b.failures)/float64(b.requests) >= b.errorRateThreshold {
+			b.transition(circuitOpen, now)
+		}
+	}
+}
+
+func (b *circuitBreaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
+
+// transition changes the state of the breaker, the caller has to hold the lock
+func (b *circuitBreaker) transition(to circuitState, now time.Time) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.halfOpenInFlight = 0
+	b.halfOpenSuccesses = 0
+	switch to {
+	case circuitOpen:
+		b.openUntil = now.Add(b.openDuration)
+		slog.Warn("PROXY", "message", "circuit breaker opened", "upstream", b.name, "from", from.String(), "openUntil", b.openUntil)
+	case circuitHalfOpen:
+		slog.Info("PROXY", "message", "circuit breaker half-opened", "upstream", b.name, "from", from.String())
+	case circuitClosed:
+		b.resetWindow(now)
+		slog.Info("PROXY", "message", "circuit breaker closed", "upstream", b.name, "from", from.String())
+	}
+	upstreamCircuitState.WithLabelValues(b.name).Set(float64(to))
+	upstreamCircuitTransitions.WithLabelValues(b.name, from.String(), to.String()).Inc()
+}
+
+// middleware rejects requests with a 503 while the breaker is open and records the outcome
+// of the requests that are let through
+func (b *circuitBreaker) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			allowed, generation, retryAfter := b.allow(time.Now())
+			if !allowed {
+				upstreamCircuitRejections.WithLabelValues(b.name).Inc()
+				slog.Debug("PROXY", "message", "circuit breaker rejected the request", "upstream", b.name, "requestID", utils.GetRequestID(c))
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				return c.JSON(http.StatusServiceUnavailable, map[string]string{
+					"error":   "service_unavailable",
+					"message": "the upstream service is unavailable, please try again later",
+				})
+			}
+			err := next(c)
+			b.report(time.Now(), generation, requestFailed(c, err))
+			return err
+		}
+	}
+}
+
+// requestFailed returns true if the upstream failed to serve the request, requests cancelled by the client
+// are not counted as failures
+func requestFailed(c echo.Context, err error) bool {
+	if err != nil {
+		if httpErr, ok := err.(*echo.HTTPError); ok {
+			return httpErr.Code >= http.StatusInternalServerError
+		}
+		return true
+	}
+	return c.Response().Status >= http.StatusInternalServerError
+}
+
+func newCircuitBreaker(name string, breakerConfig config.CircuitBreakerConfig) *circuitBreaker {
+	b := circuitBreaker{
+		name:               name,
+		errorRateThreshold: breakerConfig.ErrorRateThreshold,
+		minimumRequests:    breakerConfig.MinimumRequests,
+		window:             time.Duration(breakerConfig.WindowSeconds) * time.Second,
+		openDuration:       time.Duration(breakerConfig.OpenSeconds) * time.Second,
+		halfOpenRequests:   max(breakerConfig.HalfOpenRequests, 1),
+		windowStart:        time.Now(),
+	}
+	if b.minimumRequests <= 0 {
+		b.minimumRequests = defaultCircuitMinimumRequests
+	}
+	if b.window <= 0 {
+		b.window = defaultCircuitWindow
+	}
+	if b.openDuration <= 0 {
+		b.openDuration = defaultCircuitOpenDuration
+	}
+	upstreamCircuitState.WithLabelValues(name).Set(float64(circuitClosed))
+	return &b
+}
@@ -0,0 +1,137 @@
+package revproxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCircuitBreaker() *circuitBreaker {
+	return newCircuitBreaker("http://upstream", config.CircuitBreakerConfig{
+		Enabled:            true,
+		ErrorRateThreshold: 0.5,
+		MinimumRequests:    4,
+		OpenSeconds:        10,
+	})
+}
+
+func reportRequests(b *circuitBreaker, now time.Time, failed ...bool) {
+	for _, f := range failed {
+		_, generation, _ := b.allow(now)
+		b.report(now, generation, f)
+	}
+}
+
+func TestCircuitBreakerOpensOnErrorRate(t *testing.T) {
+	b := newTestCircuitBreaker()
+	now := time.Now()
+
+	reportRequests(b, now, true, false, true)
+	assert.Equal(t, circuitClosed, b.state)
+	reportRequests(b, now, false)
+	assert.Equal(t, circuitOpen, b.state)
+
+	allowed, _, retryAfter := b.allow(now.Add(time.Second))
+	assert.False(t, allowed)
+	assert.Equal(t, 9*time.Second, retryAfter)
+}
+
+func TestCircuitBreakerIgnoresLowErrorRate(t *testing.T) {
+	b := newTestCircuitBreaker()
+	now := time.Now()
+
+	reportRequests(b, now, true, false, false, false, false)
+
+	assert.Equal(t, circuitClosed, b.state)
+}
+
+func TestCircuitBreakerHalfOpenCloses(t *testing.T) {
+	b := newTestCircuitBreaker()
+	now := time.Now()
+	reportRequests(b, now, true, true, true, true)
+	require.Equal(t, circuitOpen, b.state)
+
+	later := now.Add(11 * time.Second)
+	allowed, generation, _ := b.allow(later)
+	require.True(t, allowed)
+	assert.Equal(t, circuitHalfOpen, b.state)
+	// Only one probe is let through at a time
+	allowed, _, _ = b.allow(later)
+	assert.False(t, allowed)
+	b.report(later, generation, false)
+
+	assert.Equal(t, circuitClosed, b.state)
+	allowed, _, _ = b.allow(later)
+	assert.True(t, allowed)
+}
+
+func TestCircuitBreakerHalfOpenReopens(t *testing.T) {
+	b := newTestCircuitBreaker()
+	now := time.Now()
+	reportRequests(b, now, true, true, true, true)
+	require.Equal(t, circuitOpen, b.state)
+
+	later := now.Add(11 * time.Second)
+	allowed, generation, _ := b.allow(later)
+	require.True(t, allowed)
+	b.report(later, generation, true)
+
+	assert.Equal(t, circuitOpen, b.state)
+	allowed, _, _ = b.allow(later.Add(time.Second))
+	assert.False(t, allowed)
+}
+
+func TestCircuitBreakerIgnoresStaleResults(t *testing.T) {
+	b := newTestCircuitBreaker()
+	now := time.Now()
+	_, staleGeneration, _ := b.allow(now)
+	reportRequests(b, now, true, true, true, true)
+	require.Equal(t, circuitOpen, b.state)
+
+	later := now.Add(11 * time.Second)
+	allowed, _, _ := b.allow(later)
+	require.True(t, allowed)
+	b.report(later, staleGeneration, false)
+
+	assert.Equal(t, circuitHalfOpen, b.state)
+}
+
+func TestProxyCircuitBreakerFailsFast(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	upstream := config.UpstreamConfig{
+		URL: mustParseURL(t, server.URL),
+		CircuitBreaker: config.CircuitBreakerConfig{
+			Enabled:            true,
+			ErrorRateThreshold: 1,
+			MinimumRequests:    2,
+			OpenSeconds:        30,
+		},
+	}
+	e := newTestProxyServer(t, upstream)
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Equal(t, 2, requests)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "service_unavailable", body["error"])
+}
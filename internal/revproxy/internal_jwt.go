@@ -0,0 +1,68 @@
+package revproxy
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/internaljwt"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// injectInternalJWT sends a JWT signed by the gateway for the given audience as the bearer token, in place of the
+// Renku access token. The claims of the JWT are taken from the access token, which is refreshed when needed but
+// never leaves the gateway. A bearer token presented by the client is verified by the session store and replaced
+// by a JWT minted from it, so the upstream only ever receives tokens signed by the gateway. Requests without a
+// logged in user are proxied without a token.
+func injectInternalJWT(sessions *sessions.SessionStore, signer *internaljwt.Signer, audience string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			session, err := sessions.Get(c)
+			// The header is set again below when the request has a verified access token
+			c.Request().Header.Del(echo.HeaderAuthorization)
+			if err != nil || session.UserID == "" {
+				return next(c)
+			}
+			accessToken, err := sessions.GetAccessToken(c, *session, models.PrimaryProviderID)
+			if err != nil {
+				slog.Debug(
+					"PROXY AUTH MIDDLEWARE",
+					"message",
+					"access token not available, continuing without an internal JWT",
+					"error",
+					err,
+					"sessionID",
+					session.ID,
+					"requestID",
+					utils.GetRequestID(c),
+				)
+				return next(c)
+			}
+			var claims map[string]any
+			_, err = oidc.ParseToken(accessToken.Value, &claims)
+			if err != nil {
+				return err
+			}
+			internalJWT, err := signer.Sign(session.UserID, audience, claims)
+			if err != nil {
+				return err
+			}
+			slog.Debug(
+				"PROXY AUTH MIDDLEWARE",
+				"message",
+				"injected internal JWT in header",
+				"header",
+				echo.HeaderAuthorization,
+				"audience",
+				audience,
+				"requestID",
+				utils.GetRequestID(c),
+			)
+			c.Request().Header.Set(echo.HeaderAuthorization, fmt.Sprintf("Bearer %s", internalJWT))
+			return next(c)
+		}
+	}
+}
@@ -3,10 +3,14 @@
 package revproxy
 
 import (
+	"context"
 	"fmt"
+	"net/url"
 	"path"
+	"slices"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/internaljwt"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/redirects"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
@@ -17,6 +21,10 @@ type Revproxy struct {
 	config    *config.RevproxyConfig
 	sessions  *sessions.SessionStore
 	redirects *redirects.RedirectStore
+	// The login URL that users are sent to when a route requires a step-up authentication
+	loginURL *url.URL
+	// Signs the JWTs sent to the backend services instead of the access tokens of Keycloak
+	internalJWTs *internaljwt.Signer
 
 	// Auth instances
 
@@ -28,16 +36,33 @@ type Revproxy struct {
 	notebooksRenkuIDTokenAuth      Auth
 	notebooksGitlabAccessTokenAuth Auth
 	renkuAccessTokenAuth           Auth
+
+	// The routes for Renku services and any additional configured routes
+	routes []config.RouteConfig
+
+	// Stops the background health checks of the upstreams
+	ctx  context.Context
+	stop context.CancelFunc
 }
 
 func (r *Revproxy) RegisterHandlers(e *echo.Echo, commonMiddlewares ...echo.MiddlewareFunc) {
 	// Initialize common reverse proxy middlewares
-	fallbackProxy := proxyFromURL(r.config.RenkuBaseURL)
+	fallbackProxy := proxyFromUpstream(r.ctx, r.upstreamConfig(r.config.RenkuBaseURL))
 	renkuBaseProxyHost := setHost(r.config.RenkuBaseURL.Host)
-	keycloakProxy := proxyFromURL(r.config.RenkuServices.Keycloak)
-	keycloakProxyHost := setHost(r.config.RenkuServices.Keycloak.Host)
-	dataServiceProxy := proxyFromURL(r.config.RenkuServices.DataService)
-	uiServerProxy := proxyFromURL(r.config.RenkuServices.UIServer)
+	// Client supplied identity headers are removed from all proxied requests before any authentication runs
+	commonMiddlewares = append(slices.Clone(commonMiddlewares), stripIdentityHeaders(r.config.IdentityHeaders))
+	// The access rules are checked after the rewrites of each route, so that they also apply to the paths
+	// that a route rewrites to
+	accessMiddlewares := []echo.MiddlewareFunc{}
+	if len(r.config.Authorization) > 0 {
+		accessMiddlewares = append(accessMiddlewares, authorize(r.sessions, r.config.Authorization))
+	}
+	if len(r.config.StepUp) > 0 {
+		accessMiddlewares = append(accessMiddlewares, stepUpAuth(r.sessions, r.config.StepUp, r.loginURL))
+	}
+	if len(accessMiddlewares) > 0 {
+		commonMiddlewares = append(commonMiddlewares, rejectNonNormalizedPath())
+	}
 
 	// Deny rules
 	sk := e.Group("/api/data/user/secret_key", commonMiddlewares...)
@@ -47,48 +72,40 @@ func (r *Revproxy) RegisterHandlers(e *echo.Echo, commonMiddlewares ...echo.Midd
 	if r.redirects != nil {
 		redirectMiddleware := r.redirects.Middleware()
 		redirectPath := path.Join(r.redirects.PathPrefix, ":projectPath")
-		e.Group(redirectPath, append(commonMiddlewares, renkuBaseProxyHost, redirectMiddleware, fallbackProxy)...)
+		e.Group(redirectPath, slices.Concat(commonMiddlewares, accessMiddlewares, []echo.MiddlewareFunc{renkuBaseProxyHost, redirectMiddleware, fallbackProxy})...)
 	}
 
-	if r.config.EnableInternalGitlab {
-		// This whole branch of else-if should be removed when the Gitlab is retired.
-		// Initialize common authentication middleware
-		notebooksRenkuRefreshToken := r.notebooksRenkuRefreshTokenAuth.Middleware()
-		renkuAccessToken := r.renkuAccessTokenAuth.Middleware()
-		dataGitlabAccessToken := r.dataGitlabAccessTokenAuth.Middleware()
-
-		// Routing for Renku services
-		// Notebooks is being routed to data service now
-		e.Group("/api/notebooks", append(commonMiddlewares, renkuAccessToken, dataGitlabAccessToken, notebooksRenkuRefreshToken, notebooksAnonymousID(r.sessions), regexRewrite("^/api/notebooks(.*)", "/api/data/notebooks$1"), dataServiceProxy)...)
-		e.Group("/api/data", append(commonMiddlewares, renkuAccessToken, dataGitlabAccessToken, notebooksRenkuRefreshToken, notebooksAnonymousID(r.sessions), dataServiceProxy)...)
-		// /api/kc is used only by the ui and no one else, will be removed when the gateway is in charge of user sessions
-		e.Group("/api/kc", append(commonMiddlewares, stripPrefix("/api/kc"), renkuAccessToken, keycloakProxyHost, keycloakProxy)...)
-
-		// UI server websockets
-		e.Group("/ui-server/ws", append(commonMiddlewares, ensureSession(r.sessions), renkuAccessToken, uiServerProxy)...)
-		// Some routes need to go to the UI server before they go to the specific Renku service
-		e.Group("/ui-server/api/allows-iframe", append(commonMiddlewares, uiServerProxy)...)
-	} else {
-		// Both the v1 services and internal gitlab are disabled
-		// Initialize common authentication middleware
-		notebooksRenkuRefreshToken := r.notebooksRenkuRefreshTokenAuth.Middleware()
-		renkuAccessToken := r.renkuAccessTokenAuth.Middleware()
-
-		// Routing for Renku services
-		// Notebooks is being routed to data service now
-		e.Group("/api/notebooks", append(commonMiddlewares, renkuAccessToken, notebooksRenkuRefreshToken, notebooksAnonymousID(r.sessions), regexRewrite("^/api/notebooks(.*)", "/api/data/notebooks$1"), dataServiceProxy)...)
-		e.Group("/api/data", append(commonMiddlewares, renkuAccessToken, notebooksRenkuRefreshToken, notebooksAnonymousID(r.sessions), dataServiceProxy)...)
-		// /api/kc is used only by the ui and no one else, will be removed when the gateway is in charge of user sessions
-		e.Group("/api/kc", append(commonMiddlewares, stripPrefix("/api/kc"), renkuAccessToken, keycloakProxyHost, keycloakProxy)...)
-
-		// UI server websockets
-		e.Group("/ui-server/ws", append(commonMiddlewares, ensureSession(r.sessions), renkuAccessToken, uiServerProxy)...)
-		// Some routes need to go to the UI server before they go to the specific Renku service
-		e.Group("/ui-server/api/allows-iframe", append(commonMiddlewares, uiServerProxy)...)
+	// Routing for Renku services and any additional configured routes
+	proxies := map[string]echo.MiddlewareFunc{}
+	for _, route := range r.routes {
+		upstream := route.Upstream.String()
+		if _, found := proxies[upstream]; !found {
+			proxies[upstream] = proxyFromUpstream(r.ctx, r.upstreamConfig(route.Upstream))
+		}
+		auth := r.authMiddlewares(route)
+		e.Group(route.PathPrefix, append(commonMiddlewares, routeMiddlewares(route, accessMiddlewares, auth, proxies[upstream])...)...)
 	}
 
 	// If nothing is matched from any of the routes above then fall back to the UI
-	e.Group("/", append(commonMiddlewares, renkuBaseProxyHost, fallbackProxy)...)
+	e.Group("/", slices.Concat(commonMiddlewares, accessMiddlewares, []echo.MiddlewareFunc{renkuBaseProxyHost, fallbackProxy})...)
+}
+
+// Stop stops the background health checks of the upstreams, it should be called when the server shuts down
+func (r *Revproxy) Stop() {
+	if r.stop != nil {
+		r.stop()
+	}
+}
+
+// upstreamConfig returns the configuration for balancing requests to an upstream URL,
+// by default the URL is the only target of the upstream.
+func (r *Revproxy) upstreamConfig(url *url.URL) config.UpstreamConfig {
+	for _, upstream := range r.config.Upstreams {
+		if upstream.URL.String() == url.String() {
+			return upstream
+		}
+	}
+	return config.UpstreamConfig{URL: url}
 }
 
 func (r *Revproxy) initializeAuth() error {
@@ -134,6 +151,21 @@ func WithRedirectsStore(redirects *redirects.RedirectStore) RevproxyOption {
 	}
 }
 
+// WithLoginURL sets the login URL used for step-up authentication
+func WithLoginURL(loginURL *url.URL) RevproxyOption {
+	return func(l *Revproxy) {
+		l.loginURL = loginURL
+	}
+}
+
+// WithInternalJWTSigner sets the signer of the internal JWTs, the data service gets these JWTs
+// instead of the Renku access tokens when it is set
+func WithInternalJWTSigner(signer *internaljwt.Signer) RevproxyOption {
+	return func(l *Revproxy) {
+		l.internalJWTs = signer
+	}
+}
+
 func NewServer(options ...RevproxyOption) (*Revproxy, error) {
 	server := Revproxy{}
 	for _, opt := range options {
@@ -145,9 +177,20 @@ func NewServer(options ...RevproxyOption) (*Revproxy, error) {
 	if server.sessions == nil {
 		return &Revproxy{}, fmt.Errorf("session handler not initialized")
 	}
+	if len(server.config.StepUp) > 0 && server.loginURL == nil {
+		return &Revproxy{}, fmt.Errorf("the login url is required for step-up authentication")
+	}
+	if server.config.InternalJWT.Enabled && server.internalJWTs == nil {
+		return &Revproxy{}, fmt.Errorf("internal JWTs are enabled but the signer is not initialized")
+	}
 	err := server.initializeAuth()
 	if err != nil {
 		return &Revproxy{}, err
 	}
+	err = server.initializeRoutes()
+	if err != nil {
+		return &Revproxy{}, err
+	}
+	server.ctx, server.stop = context.WithCancel(context.Background())
 	return &server, nil
 }
@@ -2,6 +2,11 @@ package revproxy
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
@@ -19,9 +24,11 @@ import (
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/internaljwt"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
+	"github.com/go-jose/go-jose/v4"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -31,6 +38,15 @@ import (
 
 const serverIDHeader string = "Server-ID"
 
+const testAnonymousIDKey = "anonymous-id-key-used-only-in-tests"
+
+// testAnonymousID returns the anonymous ID that the gateway derives from a session ID with the test key
+func testAnonymousID(sessionID string) string {
+	mac := hmac.New(sha256.New, []byte(testAnonymousIDKey))
+	mac.Write([]byte(sessionID))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:20])
+}
+
 func withTokenIDs(tokenIDs map[string]string) sessionOption {
 	return func(s *models.Session) error {
 		s.TokenIDs = models.SerializableMap(tokenIDs)
@@ -154,11 +170,71 @@ func setupTestUpstream(ID string, requestTracker chan<- *http.Request) (*httptes
 	return srv, url
 }
 
-func setupTestRevproxy(rpConfig *config.RevproxyConfig, sessions *sessions.SessionStore) (*httptest.Server, *url.URL) {
-	proxy, err := NewServer(WithConfig(*rpConfig), WithSessionStore(sessions))
+// testIssuer is an identity provider which issues the bearer tokens that clients present to the gateway
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	issuer := &testIssuer{key: key}
+	e := echo.New()
+	e.GET("/.well-known/openid-configuration", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"issuer":   issuer.server.URL,
+			"jwks_uri": issuer.server.URL + "/jwks",
+		})
+	})
+	e.GET("/jwks", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+			Key:       &key.PublicKey,
+			KeyID:     "test-key",
+			Algorithm: string(jose.RS256),
+			Use:       "sig",
+		}}})
+	})
+	issuer.server = httptest.NewServer(e)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// verifier returns the configuration which lets the gateway verify the tokens of the issuer
+func (i *testIssuer) verifier() config.AuthorizationVerifier {
+	return config.AuthorizationVerifier{Issuer: i.server.URL, Audience: "renku", AuthorizedParty: "renku"}
+}
+
+// accessToken signs an access token for the user with the given additional claims
+func (i *testIssuer) accessToken(t *testing.T, claims jwt.MapClaims) string {
+	tokenClaims := jwt.MapClaims{
+		"iss": i.server.URL,
+		"aud": "renku",
+		"azp": "renku",
+		"sub": "user",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		tokenClaims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, tokenClaims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(i.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func setupTestRevproxy(t *testing.T, rpConfig *config.RevproxyConfig, sessions *sessions.SessionStore, options ...RevproxyOption) (*httptest.Server, *url.URL) {
+	proxy, err := NewServer(append([]RevproxyOption{
+		WithConfig(*rpConfig),
+		WithSessionStore(sessions),
+		WithLoginURL(rpConfig.RenkuBaseURL.JoinPath("/api/auth/login")),
+	}, options...)...)
 	if err != nil {
 		log.Fatal(err)
 	}
+	t.Cleanup(proxy.Stop)
 	e := echo.New()
 	e.Pre(middleware.RemoveTrailingSlash(), UiServerPathRewrite())
 	e.Use(middleware.Recover(), middleware.Logger())
@@ -178,6 +254,8 @@ type TestResults struct {
 	Non200ResponseStatusCode int
 	IgnoreErrors             bool
 	UpstreamRequestHeaders   []map[string]string
+	// Additional checks of the requests received by the upstreams
+	CheckUpstreamRequests func(t *testing.T, reqs []*http.Request)
 }
 
 type TestCase struct {
@@ -190,6 +268,13 @@ type TestCase struct {
 	Expected             TestResults
 	RequestHeader        map[string]string
 	RequestCookie        *http.Cookie
+	Routes               func(upstreamURL *url.URL) []config.RouteConfig
+	IdentityHeaders      config.IdentityHeadersConfig
+	StepUp               []config.StepUpRule
+	Authorization        []config.AuthorizationRule
+	InternalJWTs         *internaljwt.Signer
+	// The issuer of the bearer tokens that the gateway accepts
+	TokenIssuer *testIssuer
 }
 
 func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
@@ -210,6 +295,12 @@ func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
 				Keycloak:    upstreamURL,
 				UIServer:    upstreamURL,
 			},
+			IdentityHeaders: scenario.IdentityHeaders,
+			StepUp:          scenario.StepUp,
+			Authorization:   scenario.Authorization,
+		}
+		if scenario.Routes != nil {
+			rpConfig.Routes = scenario.Routes(upstreamURL)
 		}
 		dbAdapter, err := db.NewRedisAdapter(db.WithRedisConfig(config.RedisConfig{
 			Type: config.DBTypeRedisMock,
@@ -240,7 +331,11 @@ func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
 			tokenstore.WithTokenRepository(dbAdapter),
 		)
 		require.NoError(t, err)
-		authenticator, err := authentication.NewAuthenticator()
+		authenticatorOptions := []authentication.AuthenticatorOption{}
+		if scenario.TokenIssuer != nil {
+			authenticatorOptions = append(authenticatorOptions, authentication.WithConfig([]config.AuthorizationVerifier{scenario.TokenIssuer.verifier()}))
+		}
+		authenticator, err := authentication.NewAuthenticator(authenticatorOptions...)
 		require.NoError(t, err)
 		sessionStore, err := sessions.NewSessionStore(
 			sessions.WithAuthenticator(authenticator),
@@ -248,6 +343,7 @@ func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
 			sessions.WithTokenStore(tokenStore),
 			sessions.WithConfig(config.SessionConfig{
 				UnsafeNoCookieHandler: true,
+				AnonymousIDKey:        testAnonymousIDKey,
 			}),
 			sessions.WithCookieTemplate(func() http.Cookie {
 				return http.Cookie{
@@ -264,12 +360,18 @@ func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
 			defer gitlab.Close()
 			rpConfig.ExternalGitlabURL = gitlabURL
 		}
-		proxy, proxyURL := setupTestRevproxy(&rpConfig, sessionStore)
+		proxyOptions := []RevproxyOption{}
+		if scenario.InternalJWTs != nil {
+			proxyOptions = append(proxyOptions, WithInternalJWTSigner(scenario.InternalJWTs))
+		}
+		proxy, proxyURL := setupTestRevproxy(t, &rpConfig, sessionStore, proxyOptions...)
 		defer upstream.Close()
 		defer proxy.Close()
 
 		// Make request through proxy
-		reqURL := proxyURL.JoinPath(scenario.Path)
+		// The path is not cleaned so that requests with non-normalized paths can be tested
+		reqURL, err := url.Parse(proxyURL.String() + scenario.Path)
+		require.NoError(t, err)
 		reqURLQuery := reqURL.Query()
 		for k, v := range scenario.QueryParams {
 			reqURLQuery.Add(k, v)
@@ -342,6 +444,9 @@ func ParametrizedRouteTest(scenario TestCase) func(*testing.T) {
 				assert.Equal(t, v, req.Header.Get(k))
 			}
 		}
+		if scenario.Expected.CheckUpstreamRequests != nil {
+			scenario.Expected.CheckUpstreamRequests(t, reqs)
+		}
 	}
 }
 
@@ -358,7 +463,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 			Sessions: []models.Session{
@@ -467,7 +572,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 		},
@@ -494,7 +599,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 			Sessions: []models.Session{
@@ -603,7 +708,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 		},
@@ -658,7 +763,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 			Sessions: []models.Session{
@@ -767,7 +872,7 @@ func TestInternalSvcRoutes(t *testing.T) {
 					"Gitlab-Access-Token":            "",
 					"Gitlab-Access-Token-Expires-At": "",
 					"Renku-Auth-Refresh-Token":       "",
-					"Renku-Auth-Anon-Id":             "anon-sessionID",
+					"Renku-Auth-Anon-Id":             testAnonymousID("sessionID"),
 				}},
 			},
 		},
@@ -812,9 +917,120 @@ func TestInternalSvcRoutes(t *testing.T) {
 		v2TestCasesWithInternalGitlab[idx].EnableInternalGitlab = true
 	}
 
+	configuredRoutesTestCases := []TestCase{
+		{
+			Path: "/api/custom/test",
+			Routes: func(upstreamURL *url.URL) []config.RouteConfig {
+				return []config.RouteConfig{{
+					PathPrefix:  "/api/custom",
+					Upstream:    upstreamURL,
+					StripPrefix: true,
+					Auth:        []string{"renkuAccessToken"},
+				}}
+			},
+			Tokens: []models.AuthToken{
+				newTestToken(
+					models.AccessTokenType,
+					tokenID("renku:myToken"),
+					tokenPlainValue("accessTokenValue"),
+					tokenProviderID("renku"),
+				),
+			},
+			Sessions: []models.Session{
+				newTestSesssion(sessionID("sessionID"), withTokenIDs(map[string]string{"renku": "renku:myToken"})),
+			},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				Path:             "/test",
+				VisitedServerIDs: []string{"upstream"},
+				UpstreamRequestHeaders: []map[string]string{{
+					echo.HeaderAuthorization: "Bearer accessTokenValue",
+					"Renku-Auth-Anon-Id":     "",
+				}},
+			},
+		},
+		{
+			Path: "/api/data/sessions",
+			Routes: func(upstreamURL *url.URL) []config.RouteConfig {
+				return []config.RouteConfig{{
+					PathPrefix: "/api/data",
+					Upstream:   upstreamURL,
+					Rewrites:   []config.RewriteRule{{Match: "^/api/data(.*)", Replace: "/api/v2$1"}},
+				}}
+			},
+			Sessions:      []models.Session{newTestSesssion(sessionID("sessionID"))},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				Path:             "/api/v2/sessions",
+				VisitedServerIDs: []string{"upstream"},
+				UpstreamRequestHeaders: []map[string]string{{
+					"Renku-Auth-Anon-Id": "",
+				}},
+			},
+		},
+	}
+
+	identityHeadersTestCases := []TestCase{
+		{
+			Path: "/api/data/user/forgedHeaders",
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization:   "Bearer clientToken",
+				"Renku-Auth-Refresh-Token": "forgedRefreshToken",
+				"Gitlab-Access-Token":      "forgedGitlabToken",
+				"Renku-User-Id":            "forgedUserID",
+			},
+			Sessions:      []models.Session{newTestSesssion(sessionID("sessionID"))},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				VisitedServerIDs: []string{"upstream"},
+				UpstreamRequestHeaders: []map[string]string{{
+					echo.HeaderAuthorization:   "Bearer clientToken",
+					"Renku-Auth-Refresh-Token": "",
+					"Gitlab-Access-Token":      "",
+					"Renku-User-Id":            "",
+					"Renku-Auth-Anon-Id":       testAnonymousID("sessionID"),
+				}},
+			},
+		},
+		{
+			Path: "/api/data/user/allowedHeaders",
+			IdentityHeaders: config.IdentityHeadersConfig{
+				Strip: []string{echo.HeaderAuthorization, "Renku-Auth-*"},
+				Allow: []string{"Renku-Auth-Refresh-Token"},
+			},
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization:   "Bearer clientToken",
+				"Renku-Auth-Refresh-Token": "clientRefreshToken",
+				"Renku-User-Id":            "clientUserID",
+			},
+			Tokens: []models.AuthToken{
+				newTestToken(
+					models.AccessTokenType,
+					tokenID("renku:myToken"),
+					tokenPlainValue("accessTokenValue"),
+					tokenProviderID("renku"),
+				),
+			},
+			Sessions: []models.Session{
+				newTestSesssion(sessionID("sessionID"), withTokenIDs(map[string]string{"renku": "renku:myToken"})),
+			},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				VisitedServerIDs: []string{"upstream"},
+				UpstreamRequestHeaders: []map[string]string{{
+					echo.HeaderAuthorization:   "Bearer accessTokenValue",
+					"Renku-Auth-Refresh-Token": "clientRefreshToken",
+					"Renku-User-Id":            "clientUserID",
+				}},
+			},
+		},
+	}
+
 	// Combine all test cases
 	testCases := append(v1TestCases, v2TestCases...)
 	testCases = append(testCases, v2TestCasesWithInternalGitlab...)
+	testCases = append(testCases, configuredRoutesTestCases...)
+	testCases = append(testCases, identityHeadersTestCases...)
 
 	for _, testCase := range testCases {
 		// Test names show up poorly in vscode if the name contains "/"
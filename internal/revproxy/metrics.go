@@ -0,0 +1,42 @@
+package revproxy
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// NOTE: The metrics are registered with the default prometheus registry which is
+// the one exposed by the gateway metrics server.
+var (
+	upstreamTargetHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "upstream_target_healthy",
+		Help:      "Whether an upstream target passes its active health checks (1) or not (0).",
+	}, []string{"upstream", "target"})
+	upstreamTargetEjected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "upstream_target_ejected",
+		Help:      "Whether an upstream target is ejected after consecutive failures (1) or not (0).",
+	}, []string{"upstream", "target"})
+	upstreamTargetEjections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "upstream_target_ejections_total",
+		Help:      "The number of times an upstream target was ejected after consecutive failures.",
+	}, []string{"upstream", "target"})
+
+	upstreamCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "upstream_circuit_state",
+		Help:      "The state of the circuit breaker of an upstream: closed (0), open (1) or half-open (2).",
+	}, []string{"upstream"})
+	upstreamCircuitTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "upstream_circuit_transitions_total",
+		Help:      "The number of state transitions of the circuit breaker of an upstream.",
+	}, []string{"upstream", "from", "to"})
+	upstreamCircuitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "upstream_circuit_rejected_requests_total",
+		Help:      "The number of requests rejected because the circuit breaker of an upstream is open.",
+	}, []string{"upstream"})
+)
@@ -3,10 +3,14 @@ package revproxy
 import (
 	"fmt"
 	"log/slog"
+	"net/http"
 	"net/url"
+	"path"
 	"regexp"
+	"slices"
 	"strings"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
 	"github.com/labstack/echo/v4"
@@ -45,6 +49,51 @@ func setHost(host string) echo.MiddlewareFunc {
 	}
 }
 
+// matchesPathPrefix checks if a path is equal to a prefix or is below it, i.e. /api/data/user
+// matches the prefix /api/data but /api/database does not
+func matchesPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// rejectNonNormalizedPath middleware rejects the requests whose path has empty, "." or ".." segments. Rules
+// are matched by path prefix, such paths would reach a protected upstream path without matching its rule.
+func rejectNonNormalizedPath() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestPath := c.Request().URL.Path
+			cleaned := path.Clean(requestPath)
+			if requestPath != cleaned && requestPath != cleaned+"/" {
+				slog.Info(
+					"PROXY",
+					"message",
+					"rejected a request with a non-normalized path",
+					"path",
+					requestPath,
+					"requestID",
+					utils.GetRequestID(c),
+				)
+				return echo.NewHTTPError(http.StatusBadRequest, "the request path is not normalized")
+			}
+			return next(c)
+		}
+	}
+}
+
+// requestPaths returns the paths that the authorization and step-up rules are matched against: the path
+// requested by the client and the path sent to the upstream after the rewrites of the route
+func requestPaths(c echo.Context) []string {
+	output := []string{path.Clean(c.Request().URL.Path)}
+	requestURI, err := url.ParseRequestURI(c.Request().RequestURI)
+	if err == nil && requestURI.Path != "" {
+		original := path.Clean(requestURI.Path)
+		if original != output[0] {
+			output = append(output, original)
+		}
+	}
+	return output
+}
+
 // ensureSession middleware makes sure a session exists by creating a new one if none is found.
 func ensureSession(sessions *sessions.SessionStore) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -123,7 +172,7 @@ func UiServerPathRewrite() echo.MiddlewareFunc {
 	}
 }
 
-// Injects the sessionID as an identifier for an anonymous user. It will only do so if there are no
+// Injects an identifier derived from the session for an anonymous user. It will only do so if there are no
 // headers already injected for the keycloak tokens. Therefore this should always run in the middleware
 // chain after all other token injection middelwares have run.
 func notebooksAnonymousID(sessions *sessions.SessionStore) echo.MiddlewareFunc {
@@ -133,7 +182,7 @@ func notebooksAnonymousID(sessions *sessions.SessionStore) echo.MiddlewareFunc {
 			if c.Request().Header.Get("Renku-Auth-Access-Token") != "" || c.Request().Header.Get("Renku-Auth-Id-Token") != "" || c.Request().Header.Get("Renku-Auth-Refresh-Token") != "" {
 				return next(c)
 			}
-			// Use the session ID as the anonymous ID
+			// The anonymous ID is derived from the session ID
 			session, err := sessions.Get(c)
 			if err != nil || session.ID == "" {
 				session, err = sessions.Create(c)
@@ -141,10 +190,51 @@ func notebooksAnonymousID(sessions *sessions.SessionStore) echo.MiddlewareFunc {
 			if err != nil {
 				return err
 			}
-			// NOTE: The anonymous session ID must start with a letter, otherwise when we use it to create sessions in k8s
-			// things fail because a label value must start with a letter. That is why we add `anon-` here to the value.
-			// Note that valid values for a label in k8s are [a-zA-Z0-9], also -_. and it must start with a letter.
-			c.Request().Header.Set("Renku-Auth-Anon-Id", "anon-"+session.ID)
+			// NOTE: The raw session ID is never sent to the services, it would allow them to impersonate the user.
+			c.Request().Header.Set("Renku-Auth-Anon-Id", sessions.AnonymousID(session))
+			return next(c)
+		}
+	}
+}
+
+// The headers that the gateway injects and that clients therefore must not send
+var defaultStrippedIdentityHeaders = []string{
+	"Renku-Auth-*",
+	"Renku-User",
+	"Renku-User-*",
+	"Gitlab-Access-Token",
+	"Gitlab-Access-Token-*",
+}
+
+// matchesHeaderPattern checks if a canonical header name matches a header name or a prefix ending in '*'
+func matchesHeaderPattern(header string, pattern string) bool {
+	if prefix, isPrefix := strings.CutSuffix(pattern, "*"); isPrefix {
+		return strings.HasPrefix(header, http.CanonicalHeaderKey(prefix))
+	}
+	return header == http.CanonicalHeaderKey(pattern)
+}
+
+// stripIdentityHeaders removes the identity headers sent by the client so that the authentication
+// middlewares cannot forward them as if the gateway had injected them. This has to run before all
+// the authentication middlewares.
+func stripIdentityHeaders(headersConfig config.IdentityHeadersConfig) echo.MiddlewareFunc {
+	strip := headersConfig.Strip
+	if len(strip) == 0 {
+		strip = defaultStrippedIdentityHeaders
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header
+			for name := range header {
+				if !slices.ContainsFunc(strip, func(p string) bool { return matchesHeaderPattern(name, p) }) {
+					continue
+				}
+				if slices.ContainsFunc(headersConfig.Allow, func(p string) bool { return matchesHeaderPattern(name, p) }) {
+					continue
+				}
+				slog.Debug("PROXY", "message", "removed identity header sent by the client", "header", name, "requestID", utils.GetRequestID(c))
+				header.Del(name)
+			}
 			return next(c)
 		}
 	}
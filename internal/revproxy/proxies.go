@@ -1,32 +1,121 @@
 package revproxy
 
 import (
+	"context"
+	"errors"
 	"log/slog"
-	"net/url"
+	"net"
+	"net/http"
 	"os"
+	"time"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
-// proxyFromURL middleware creates a proxy that forwards requests to the specified URL
-func proxyFromURL(url *url.URL) echo.MiddlewareFunc {
-	if url == nil {
+const proxyTargetCtxKey string = "target"
+
+const defaultConnectTimeout time.Duration = 30 * time.Second
+
+// proxyFromUpstream middleware creates a proxy that balances requests between the targets of an upstream.
+// Targets failing their health checks or with too many consecutive failures do not receive requests.
+// When the circuit breaker of the upstream is open requests fail fast with a 503.
+// The active health checks run until the context is cancelled.
+func proxyFromUpstream(ctx context.Context, upstream config.UpstreamConfig) echo.MiddlewareFunc {
+	if upstream.URL == nil {
 		slog.Error("cannot create a proxy from a nil URL")
 		os.Exit(1)
 	}
+	url := upstream.URL
+	balancer := newUpstreamBalancer(upstream)
+	if upstream.HealthCheck.Enabled {
+		balancer.startHealthChecks(ctx)
+	}
+	retries := newRetryPolicy(upstream.Retry)
+	// reportError records connection failures for the passive health checks of the balancer
+	reportError := func(c echo.Context, err error) {
+		var httpErr *echo.HTTPError
+		target, ok := c.Get(proxyTargetCtxKey).(*middleware.ProxyTarget)
+		if !ok || target == nil || !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadGateway {
+			return
+		}
+		// Retryable responses are already recorded when they are received
+		if errors.Is(httpErr.Internal, errRetryableResponse) {
+			return
+		}
+		balancer.reportResult(target.URL.Host, true)
+	}
 	mwConfig := middleware.ProxyConfig{
 		// the skipper is used to log only
 		Skipper: func(c echo.Context) bool {
 			slog.Info("PROXY", "requestID", utils.GetRequestID(c), "destination", url.String())
 			return false
 		},
-		Balancer: middleware.NewRoundRobinBalancer([]*middleware.ProxyTarget{
-			{
-				Name: url.String(),
-				URL:  url,
-			}}),
+		Balancer:   balancer,
+		ContextKey: proxyTargetCtxKey,
+		Transport:  newTransport(upstream),
+		RetryCount: retries.maxRetries,
+		RetryFilter: func(c echo.Context, err error) bool {
+			reportError(c, err)
+			retry := retries.retryFilter(c, err)
+			if retry {
+				slog.Info("PROXY", "message", "retrying request", "requestID", utils.GetRequestID(c), "destination", url.String(), "error", err)
+			}
+			return retry
+		},
+		ModifyResponse: func(res *http.Response) error {
+			balancer.reportResult(res.Request.URL.Host, res.StatusCode >= http.StatusInternalServerError)
+			if retries.retryResponse(res) {
+				return errRetryableResponse
+			}
+			return nil
+		},
+		ErrorHandler: func(c echo.Context, err error) error {
+			reportError(c, err)
+			if errors.Is(c.Request().Context().Err(), context.DeadlineExceeded) {
+				return echo.NewHTTPError(http.StatusGatewayTimeout, "the upstream did not respond in time").SetInternal(context.DeadlineExceeded)
+			}
+			return err
+		},
+	}
+	proxy := middleware.ProxyWithConfig(mwConfig)
+	var breaker *circuitBreaker
+	if upstream.CircuitBreaker.Enabled {
+		breaker = newCircuitBreaker(url.String(), upstream.CircuitBreaker)
+	}
+	timeout := time.Duration(upstream.TimeoutSeconds) * time.Second
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		proxyHandler := proxy(next)
+		if breaker != nil {
+			// The breaker fails fast before any target is tried
+			proxyHandler = breaker.middleware()(proxyHandler)
+		}
+		return func(c echo.Context) error {
+			req := retries.withState(c.Request())
+			if timeout > 0 && !c.IsWebSocket() {
+				ctx, cancel := context.WithTimeout(req.Context(), timeout)
+				defer cancel()
+				req = req.WithContext(ctx)
+			}
+			c.SetRequest(req)
+			return proxyHandler(c)
+		}
+	}
+}
+
+// newTransport creates the transport used to send requests to the targets of an upstream
+func newTransport(upstream config.UpstreamConfig) *http.Transport {
+	connectTimeout := time.Duration(upstream.ConnectTimeoutSeconds) * time.Second
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
 	}
-	return middleware.ProxyWithConfig(mwConfig)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = time.Duration(upstream.ResponseHeaderTimeoutSeconds) * time.Second
+	return transport
 }
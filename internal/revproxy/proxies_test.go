@@ -0,0 +1,125 @@
+package revproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProxyServer(t *testing.T, upstream config.UpstreamConfig) *echo.Echo {
+	e := echo.New()
+	e.Any("/*", func(c echo.Context) error { return nil }, proxyFromUpstream(t.Context(), upstream))
+	return e
+}
+
+func serveThroughProxy(t *testing.T, upstream config.UpstreamConfig, method string) *httptest.ResponseRecorder {
+	e := newTestProxyServer(t, upstream)
+	req := httptest.NewRequest(method, "/", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProxyRetriesIdempotentRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	upstream := config.UpstreamConfig{
+		URL:   mustParseURL(t, server.URL),
+		Retry: config.RetryConfig{MaxRetries: 2, BaseDelayMilliseconds: 1, MaxDelayMilliseconds: 5},
+	}
+
+	rec := serveThroughProxy(t, upstream, http.MethodGet)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestProxyRetriesAreBounded(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	upstream := config.UpstreamConfig{
+		URL:   mustParseURL(t, server.URL),
+		Retry: config.RetryConfig{MaxRetries: 2, BaseDelayMilliseconds: 1, MaxDelayMilliseconds: 5},
+	}
+
+	rec := serveThroughProxy(t, upstream, http.MethodGet)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestProxyDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	upstream := config.UpstreamConfig{
+		URL:   mustParseURL(t, server.URL),
+		Retry: config.RetryConfig{MaxRetries: 2, BaseDelayMilliseconds: 1, MaxDelayMilliseconds: 5},
+	}
+
+	rec := serveThroughProxy(t, upstream, http.MethodPost)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, int32(1), requests.Load())
+}
+
+func TestProxyRetriesConnectionFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := mustParseURL(t, server.URL)
+	server.Close()
+	upstream := config.UpstreamConfig{
+		URL:   serverURL,
+		Retry: config.RetryConfig{MaxRetries: 1, BaseDelayMilliseconds: 1, MaxDelayMilliseconds: 5},
+	}
+
+	rec := serveThroughProxy(t, upstream, http.MethodGet)
+
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+}
+
+func TestProxyTotalTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	upstream := config.UpstreamConfig{URL: mustParseURL(t, server.URL), TimeoutSeconds: 1}
+
+	start := time.Now()
+	rec := serveThroughProxy(t, upstream, http.MethodGet)
+
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	require.Less(t, time.Since(start), 4*time.Second)
+}
+
+func TestRetryDelayIsBounded(t *testing.T) {
+	policy := newRetryPolicy(config.RetryConfig{MaxRetries: 10, BaseDelayMilliseconds: 10, MaxDelayMilliseconds: 50})
+	for attempt := range 10 {
+		delay := policy.delay(attempt)
+		assert.Greater(t, delay, time.Duration(0))
+		assert.LessOrEqual(t, delay, 50*time.Millisecond)
+	}
+}
@@ -0,0 +1,113 @@
+package revproxy
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultRetryBaseDelay time.Duration = 100 * time.Millisecond
+	defaultRetryMaxDelay  time.Duration = 2 * time.Second
+)
+
+// errRetryableResponse is returned when the response of a target is discarded so that the request can be retried
+var errRetryableResponse = errors.New("the upstream responded with a retryable status code")
+
+type retryStateCtxKeyType struct{}
+
+var retryStateCtxKey retryStateCtxKeyType
+
+// retryState keeps track of the retries of a single proxied request
+type retryState struct {
+	attempts int
+}
+
+// retryPolicy decides if and when a proxied request is retried
+type retryPolicy struct {
+	maxRetries int
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+}
+
+// withState adds a fresh retry state to the request context
+func (p retryPolicy) withState(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), retryStateCtxKey, &retryState{}))
+}
+
+// canRetry returns true if the request is idempotent and it has not been retried too many times
+func (p retryPolicy) canRetry(req *http.Request) bool {
+	if p.maxRetries <= 0 || !isIdempotent(req.Method) {
+		return false
+	}
+	state, ok := req.Context().Value(retryStateCtxKey).(*retryState)
+	return ok && state.attempts < p.maxRetries
+}
+
+// retryResponse returns true if the response should be discarded and the request retried
+func (p retryPolicy) retryResponse(res *http.Response) bool {
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return p.canRetry(res.Request)
+	default:
+		return false
+	}
+}
+
+// retryFilter is used as the RetryFilter of the proxy middleware, it waits before the request is retried.
+// Requests are retried only when they failed to reach the target or the target responded with a retryable status.
+func (p retryPolicy) retryFilter(c echo.Context, err error) bool {
+	req := c.Request()
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusBadGateway {
+		return false
+	}
+	if req.Context().Err() != nil || !p.canRetry(req) {
+		return false
+	}
+	state := req.Context().Value(retryStateCtxKey).(*retryState)
+	delay := p.delay(state.attempts)
+	state.attempts++
+	select {
+	case <-req.Context().Done():
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// delay returns an exponential backoff with full jitter for the given attempt
+func (p retryPolicy) delay(attempt int) time.Duration {
+	backoff := p.baseDelay << attempt
+	if backoff <= 0 || backoff > p.maxDelay {
+		backoff = p.maxDelay
+	}
+	return rand.N(backoff) + 1
+}
+
+func isIdempotent(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func newRetryPolicy(retryConfig config.RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxRetries: retryConfig.MaxRetries,
+		baseDelay:  time.Duration(retryConfig.BaseDelayMilliseconds) * time.Millisecond,
+		maxDelay:   time.Duration(retryConfig.MaxDelayMilliseconds) * time.Millisecond,
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = defaultRetryBaseDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = defaultRetryMaxDelay
+	}
+	if policy.maxDelay < policy.baseDelay {
+		policy.maxDelay = policy.baseDelay
+	}
+	return policy
+}
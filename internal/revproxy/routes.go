@@ -0,0 +1,154 @@
+package revproxy
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/labstack/echo/v4"
+)
+
+// Names of the authentication middlewares that can be referenced in the routes configuration
+const (
+	renkuAccessTokenAuthName      string = "renkuAccessToken"
+	renkuRefreshTokenAuthName     string = "renkuRefreshToken"
+	dataGitlabAccessTokenAuthName string = "dataGitlabAccessToken"
+	anonymousIDAuthName           string = "anonymousID"
+	ensureSessionAuthName         string = "ensureSession"
+	renkuInternalJWTAuthName      string = "renkuInternalJWT"
+)
+
+// defaultRoutes returns the routes for the Renku services that the gateway always proxies to
+func (r *Revproxy) defaultRoutes() []config.RouteConfig {
+	dataServiceAuth := []string{renkuAccessTokenAuthName}
+	uiServerWSAuth := []string{ensureSessionAuthName, renkuAccessTokenAuthName}
+	if r.internalJWTs != nil {
+		// The data service and the UI server get a JWT signed by the gateway instead of the access token of Keycloak
+		dataServiceAuth = []string{renkuInternalJWTAuthName}
+		uiServerWSAuth = []string{ensureSessionAuthName, renkuInternalJWTAuthName}
+	}
+	if r.config.EnableInternalGitlab {
+		// This should be removed when the Gitlab is retired.
+		dataServiceAuth = append(dataServiceAuth, dataGitlabAccessTokenAuthName)
+	}
+	if r.internalJWTs == nil {
+		// The Keycloak tokens do not leave the gateway when internal JWTs are enabled
+		dataServiceAuth = append(dataServiceAuth, renkuRefreshTokenAuthName)
+	}
+	dataServiceAuth = append(dataServiceAuth, anonymousIDAuthName)
+
+	return []config.RouteConfig{
+		// Notebooks is being routed to data service now
+		{
+			PathPrefix: "/api/notebooks",
+			Upstream:   r.config.RenkuServices.DataService,
+			Rewrites:   []config.RewriteRule{{Match: "^/api/notebooks(.*)", Replace: "/api/data/notebooks$1"}},
+			Auth:       dataServiceAuth,
+		},
+		{
+			PathPrefix: "/api/data",
+			Upstream:   r.config.RenkuServices.DataService,
+			Auth:       dataServiceAuth,
+		},
+		// /api/kc is used only by the ui and no one else, will be removed when the gateway is in charge of user sessions
+		{
+			PathPrefix:  "/api/kc",
+			Upstream:    r.config.RenkuServices.Keycloak,
+			StripPrefix: true,
+			SetHost:     true,
+			Auth:        []string{renkuAccessTokenAuthName},
+		},
+		// UI server websockets
+		{
+			PathPrefix: "/ui-server/ws",
+			Upstream:   r.config.RenkuServices.UIServer,
+			Auth:       uiServerWSAuth,
+		},
+		// Some routes need to go to the UI server before they go to the specific Renku service
+		{
+			PathPrefix: "/ui-server/api/allows-iframe",
+			Upstream:   r.config.RenkuServices.UIServer,
+		},
+	}
+}
+
+// initializeRoutes merges the configured routes into the default routes and checks that
+// all the authentication middlewares they reference are available.
+func (r *Revproxy) initializeRoutes() error {
+	routes := r.defaultRoutes()
+	for _, route := range r.config.Routes {
+		replaced := false
+		for i := range routes {
+			if routes[i].PathPrefix == route.PathPrefix {
+				routes[i] = route
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			routes = append(routes, route)
+		}
+	}
+	for _, route := range routes {
+		if route.TokenExchange.Enabled && !slices.Contains(route.Auth, renkuAccessTokenAuthName) {
+			return fmt.Errorf("the route %s exchanges tokens but it does not use the %s authentication middleware", route.PathPrefix, renkuAccessTokenAuthName)
+		}
+		auth := r.authMiddlewares(route)
+		for _, authName := range route.Auth {
+			if _, found := auth[authName]; !found {
+				return fmt.Errorf("the route %s uses an unknown or disabled authentication middleware %s", route.PathPrefix, authName)
+			}
+		}
+	}
+	r.routes = routes
+	return nil
+}
+
+// authMiddlewares returns the authentication middlewares that a route can use, keyed by their name
+func (r *Revproxy) authMiddlewares(route config.RouteConfig) map[string]echo.MiddlewareFunc {
+	accessTokenAuth := r.renkuAccessTokenAuth
+	if route.TokenExchange.Enabled {
+		WithTokenExchange(routeAudience(route), route.TokenExchange.Scopes)(&accessTokenAuth)
+	}
+	output := map[string]echo.MiddlewareFunc{
+		renkuAccessTokenAuthName:  accessTokenAuth.Middleware(),
+		renkuRefreshTokenAuthName: r.notebooksRenkuRefreshTokenAuth.Middleware(),
+		anonymousIDAuthName:       notebooksAnonymousID(r.sessions),
+		ensureSessionAuthName:     ensureSession(r.sessions),
+	}
+	if r.config.EnableInternalGitlab {
+		output[dataGitlabAccessTokenAuthName] = r.dataGitlabAccessTokenAuth.Middleware()
+	}
+	if r.internalJWTs != nil {
+		output[renkuInternalJWTAuthName] = injectInternalJWT(r.sessions, r.internalJWTs, routeAudience(route))
+	}
+	return output
+}
+
+// routeAudience returns the audience of the internal JWTs sent to the upstream of a route
+func routeAudience(route config.RouteConfig) string {
+	if route.Audience != "" {
+		return route.Audience
+	}
+	return route.Upstream.Hostname()
+}
+
+// routeMiddlewares returns the chain of middlewares that rewrites, checks the access rules, authenticates
+// and finally proxies the requests matching a route
+func routeMiddlewares(route config.RouteConfig, access []echo.MiddlewareFunc, auth map[string]echo.MiddlewareFunc, proxy echo.MiddlewareFunc) []echo.MiddlewareFunc {
+	output := []echo.MiddlewareFunc{}
+	if route.StripPrefix {
+		output = append(output, stripPrefix(route.PathPrefix))
+	}
+	for _, rule := range route.Rewrites {
+		output = append(output, regexRewrite(rule.Match, rule.Replace))
+	}
+	output = append(output, access...)
+	for _, authName := range route.Auth {
+		output = append(output, auth[authName])
+	}
+	if route.SetHost {
+		output = append(output, setHost(route.Upstream.Host))
+	}
+	return append(output, proxy)
+}
@@ -0,0 +1,132 @@
+package revproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/utils"
+	"github.com/labstack/echo/v4"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
+)
+
+// The error code of responses asking for a step-up authentication, as defined in RFC 9470
+const insufficientUserAuthentication string = "insufficient_user_authentication"
+
+// stepUpAuth requires a recent or a stronger authentication for the requests matching the step-up rules.
+// The requirements are checked against the claims of the ID token of the Renku provider, or of the verified
+// access token for requests authenticated with a bearer token since they have no ID token. Users who do not
+// meet them get a 401 response with a login URL that asks the identity provider for a new authentication.
+// Like the authorization rules, the step-up rules are checked after the rewrites of a route against both the
+// requested and the rewritten path.
+func stepUpAuth(sessions *sessions.SessionStore, rules []config.StepUpRule, loginURL *url.URL) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, requestPath := range requestPaths(c) {
+				rule, found := matchStepUpRule(rules, requestPath)
+				if found && !meetsStepUpRule(c, sessions, rule) {
+					slog.Info(
+						"STEP UP AUTH",
+						"message",
+						"the authentication does not meet the requirements of the route",
+						"pathPrefix",
+						rule.PathPrefix,
+						"requestID",
+						utils.GetRequestID(c),
+					)
+					return stepUpRequired(c, rule, loginURL)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// meetsStepUpRule checks if the user of the request is logged in and authenticated as required by the rule
+func meetsStepUpRule(c echo.Context, sessions *sessions.SessionStore, rule config.StepUpRule) bool {
+	session, err := sessions.Get(c)
+	if err != nil || session.UserID == "" {
+		return false
+	}
+	token, err := stepUpToken(c, sessions, session)
+	return err == nil && satisfiesStepUpRule(token, rule, time.Now())
+}
+
+// stepUpToken returns the token whose claims tell how the user of a session authenticated. Ephemeral sessions
+// come from a verified bearer token, the access token carries the same auth_time and acr claims as the ID token.
+func stepUpToken(c echo.Context, sessions *sessions.SessionStore, session *models.Session) (models.AuthToken, error) {
+	if session.ID == "" {
+		return sessions.GetAccessToken(c, *session, models.PrimaryProviderID)
+	}
+	return sessions.GetIDToken(c, *session, models.PrimaryProviderID)
+}
+
+// matchStepUpRule returns the rule with the longest path prefix which matches the path
+func matchStepUpRule(rules []config.StepUpRule, path string) (config.StepUpRule, bool) {
+	var output config.StepUpRule
+	found := false
+	for _, rule := range rules {
+		if !matchesPathPrefix(path, rule.PathPrefix) {
+			continue
+		}
+		if !found || len(rule.PathPrefix) > len(output.PathPrefix) {
+			output = rule
+			found = true
+		}
+	}
+	return output, found
+}
+
+// satisfiesStepUpRule checks the authentication time and context class of an ID or access token against a rule
+func satisfiesStepUpRule(token models.AuthToken, rule config.StepUpRule, now time.Time) bool {
+	var claims oidc.IDTokenClaims
+	_, err := oidc.ParseToken(token.Value, &claims)
+	if err != nil {
+		return false
+	}
+	if rule.MaxAgeSeconds > 0 {
+		if claims.AuthTime == 0 {
+			return false
+		}
+		if now.Sub(claims.AuthTime.AsTime()) > time.Duration(rule.MaxAgeSeconds)*time.Second {
+			return false
+		}
+	}
+	if rule.ACR != "" && claims.AuthenticationContextClassReference != rule.ACR {
+		return false
+	}
+	return true
+}
+
+// stepUpRequired responds with a 401 that tells the client how to log in again to meet the requirements of a rule.
+// The login redirects back to the page which made the request when it is known.
+func stepUpRequired(c echo.Context, rule config.StepUpRule, loginURL *url.URL) error {
+	stepUpURL := *loginURL
+	query := stepUpURL.Query()
+	challenge := []string{fmt.Sprintf(`Bearer error="%s"`, insufficientUserAuthentication)}
+	if rule.MaxAgeSeconds > 0 {
+		query.Set("max_age", strconv.Itoa(rule.MaxAgeSeconds))
+		challenge = append(challenge, fmt.Sprintf(`max_age=%d`, rule.MaxAgeSeconds))
+	}
+	if rule.ACR != "" {
+		query.Set("acr_values", rule.ACR)
+		challenge = append(challenge, fmt.Sprintf(`acr_values="%s"`, rule.ACR))
+	}
+	if referer := c.Request().Referer(); referer != "" {
+		query.Set("redirect_url", referer)
+	}
+	stepUpURL.RawQuery = query.Encode()
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, strings.Join(challenge, ", "))
+	return c.JSON(http.StatusUnauthorized, map[string]any{
+		"error":     insufficientUserAuthentication,
+		"message":   "a more recent or a stronger authentication is required",
+		"login_url": stepUpURL.String(),
+	})
+}
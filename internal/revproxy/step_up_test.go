@@ -0,0 +1,249 @@
+package revproxy
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/sessions"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withUserID(userID string) sessionOption {
+	return func(s *models.Session) error {
+		s.UserID = userID
+		return nil
+	}
+}
+
+func tokenIDTokenValue(authTime time.Time, acr string) tokenOption {
+	return func(t *models.AuthToken) {
+		claims := jwt.MapClaims{
+			"sub": "user",
+			"exp": time.Now().Add(time.Hour * 5).Unix(),
+		}
+		if !authTime.IsZero() {
+			claims["auth_time"] = authTime.Unix()
+		}
+		if acr != "" {
+			claims["acr"] = acr
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+		signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		t.Value = signed
+	}
+}
+
+func TestMatchStepUpRule(t *testing.T) {
+	rules := []config.StepUpRule{
+		{PathPrefix: "/api/data", ACR: "silver"},
+		{PathPrefix: "/api/data/user/secret_key/", MaxAgeSeconds: 300},
+	}
+	rule, found := matchStepUpRule(rules, "/api/data/projects")
+	assert.True(t, found)
+	assert.Equal(t, "/api/data", rule.PathPrefix)
+	rule, found = matchStepUpRule(rules, "/api/data/user/secret_key")
+	assert.True(t, found)
+	assert.Equal(t, 300, rule.MaxAgeSeconds)
+	rule, found = matchStepUpRule(rules, "/api/data/user/secret_key/rotate")
+	assert.True(t, found)
+	assert.Equal(t, 300, rule.MaxAgeSeconds)
+	_, found = matchStepUpRule(rules, "/api/database")
+	assert.False(t, found)
+	_, found = matchStepUpRule(rules, "/api/notebooks")
+	assert.False(t, found)
+}
+
+func TestSatisfiesStepUpRule(t *testing.T) {
+	now := time.Now()
+	maxAge := config.StepUpRule{PathPrefix: "/api", MaxAgeSeconds: 300}
+	acr := config.StepUpRule{PathPrefix: "/api", ACR: "gold"}
+
+	recent := newTestToken(models.IDTokenType, tokenIDTokenValue(now.Add(-time.Minute), "gold"))
+	assert.True(t, satisfiesStepUpRule(recent, maxAge, now))
+	assert.True(t, satisfiesStepUpRule(recent, acr, now))
+
+	old := newTestToken(models.IDTokenType, tokenIDTokenValue(now.Add(-time.Hour), "silver"))
+	assert.False(t, satisfiesStepUpRule(old, maxAge, now))
+	assert.False(t, satisfiesStepUpRule(old, acr, now))
+
+	noAuthTime := newTestToken(models.IDTokenType, tokenIDTokenValue(time.Time{}, ""))
+	assert.False(t, satisfiesStepUpRule(noAuthTime, maxAge, now))
+
+	notJWT := newTestToken(models.IDTokenType, tokenPlainValue("not-a-jwt"))
+	assert.False(t, satisfiesStepUpRule(notJWT, acr, now))
+}
+
+func TestStepUpRequired(t *testing.T) {
+	loginURL, err := url.Parse("https://renku.example.org/api/auth/login")
+	require.NoError(t, err)
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/data/user/secret_key", nil)
+	req.Header.Set("Referer", "https://renku.example.org/secrets")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	err = stepUpRequired(c, config.StepUpRule{PathPrefix: "/api/data", MaxAgeSeconds: 300, ACR: "gold"}, loginURL)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(
+		t,
+		`Bearer error="insufficient_user_authentication", max_age=300, acr_values="gold"`,
+		rec.Header().Get(echo.HeaderWWWAuthenticate),
+	)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, insufficientUserAuthentication, body["error"])
+	stepUpURL, err := url.Parse(body["login_url"])
+	require.NoError(t, err)
+	assert.Equal(t, "/api/auth/login", stepUpURL.Path)
+	assert.Equal(t, "300", stepUpURL.Query().Get("max_age"))
+	assert.Equal(t, "gold", stepUpURL.Query().Get("acr_values"))
+	assert.Equal(t, "https://renku.example.org/secrets", stepUpURL.Query().Get("redirect_url"))
+}
+
+func TestStepUpRoutes(t *testing.T) {
+	stepUp := []config.StepUpRule{
+		{PathPrefix: "/api/data/user", MaxAgeSeconds: 300},
+		{PathPrefix: "/api/data/notebooks/secrets", MaxAgeSeconds: 300},
+	}
+	// Requests authenticated with a bearer token are checked against the claims of the token
+	issuer := newTestIssuer(t)
+	testSession := newTestSesssion(
+		sessionID("sessionID"),
+		withUserID("user"),
+		withTokenIDs(map[string]string{"renku": "renku:myToken"}),
+	)
+	accessToken := newTestToken(
+		models.AccessTokenType,
+		tokenID("renku:myToken"),
+		tokenPlainValue("accessTokenValue"),
+		tokenProviderID("renku"),
+	)
+	testCases := []TestCase{
+		{
+			Path:   "/api/data/user",
+			StepUp: stepUp,
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusUnauthorized,
+				ResponseHeaders:          map[string]string{echo.HeaderWWWAuthenticate: `Bearer error="insufficient_user_authentication", max_age=300`},
+			},
+		},
+		{
+			Path:   "/api/data/user",
+			StepUp: stepUp,
+			Tokens: []models.AuthToken{
+				accessToken,
+				newTestToken(
+					models.IDTokenType,
+					tokenID("renku:myToken"),
+					tokenIDTokenValue(time.Now().Add(-time.Hour), ""),
+					tokenProviderID("renku"),
+				),
+			},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusUnauthorized,
+			},
+		},
+		{
+			Path:   "/api/data/user",
+			StepUp: stepUp,
+			Tokens: []models.AuthToken{
+				accessToken,
+				newTestToken(
+					models.IDTokenType,
+					tokenID("renku:myToken"),
+					tokenIDTokenValue(time.Now().Add(-time.Minute), ""),
+					tokenProviderID("renku"),
+				),
+			},
+			Sessions:      []models.Session{testSession},
+			RequestCookie: &http.Cookie{Name: sessions.SessionCookieName, Value: "sessionID"},
+			Expected: TestResults{
+				Path:             "/api/data/user",
+				VisitedServerIDs: []string{"upstream"},
+			},
+		},
+		{
+			Path:        "/api/data/user",
+			StepUp:      stepUp,
+			TokenIssuer: issuer,
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization: "Bearer " + issuer.accessToken(t, jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()}),
+			},
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusUnauthorized,
+			},
+		},
+		{
+			Path:        "/api/data/user",
+			StepUp:      stepUp,
+			TokenIssuer: issuer,
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization: "Bearer " + issuer.accessToken(t, jwt.MapClaims{"auth_time": time.Now().Add(-time.Minute).Unix()}),
+			},
+			Expected: TestResults{
+				Path:             "/api/data/user",
+				VisitedServerIDs: []string{"upstream"},
+			},
+		},
+		{
+			Path:     "/api/data/projects",
+			StepUp:   stepUp,
+			Expected: TestResults{Path: "/api/data/projects", VisitedServerIDs: []string{"upstream"}},
+		},
+		// The rules apply to the path that an alias is rewritten to
+		{
+			Path:   "/api/notebooks/secrets",
+			StepUp: stepUp,
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusUnauthorized,
+			},
+		},
+		{
+			Path:        "/api/notebooks/secrets",
+			StepUp:      stepUp,
+			TokenIssuer: issuer,
+			RequestHeader: map[string]string{
+				echo.HeaderAuthorization: "Bearer " + issuer.accessToken(t, jwt.MapClaims{"auth_time": time.Now().Add(-time.Minute).Unix()}),
+			},
+			Expected: TestResults{
+				Path:             "/api/data/notebooks/secrets",
+				VisitedServerIDs: []string{"upstream"},
+			},
+		},
+		// Non-normalized paths cannot get around the rules
+		{
+			Path:   "/api/data//user",
+			StepUp: stepUp,
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			Path:   "/api/data/./user",
+			StepUp: stepUp,
+			Expected: TestResults{
+				Non200ResponseStatusCode: http.StatusBadRequest,
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.Path, ParametrizedRouteTest(testCase))
+	}
+}
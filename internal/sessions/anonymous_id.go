@@ -0,0 +1,57 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+)
+
+// anonymousIDPrefix makes the anonymous ID start with a letter, k8s label values have to
+// start and end with an alphanumeric character and be at most 63 characters long
+const anonymousIDPrefix string = "anon-"
+
+// anonymousIDBytes is the number of bytes of the HMAC used in the anonymous ID, the hex
+// encoded ID is 5 + 40 characters long
+const anonymousIDBytes int = 20
+
+// anonymousIDKeyContext is used to derive the anonymous ID key from the cookie hash key
+// when no key is configured, so that the two keys are never the same
+const anonymousIDKeyContext string = "renku-gateway-anonymous-id"
+
+// AnonymousID returns the identifier of an anonymous user which is stable for the life of the session.
+// The identifier is a keyed hash of the session ID, it is safe to send to other services and it cannot be
+// used to recover the session ID. It is also a valid k8s label value.
+func (sessions *SessionStore) AnonymousID(session *models.Session) string {
+	return anonymousID(sessions.anonymousIDKey, session.ID)
+}
+
+func anonymousID(key []byte, sessionID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sessionID))
+	return anonymousIDPrefix + hex.EncodeToString(mac.Sum(nil)[:anonymousIDBytes])
+}
+
+// anonymousIDKeyFromConfig returns the configured anonymous ID key, if it is not set it is derived
+// from the cookie hash key. A random key is used when neither is set which is only allowed in development.
+func anonymousIDKeyFromConfig(c config.SessionConfig) ([]byte, error) {
+	if len(c.AnonymousIDKey) > 0 {
+		return []byte(c.AnonymousIDKey), nil
+	}
+	if len(c.CookieHashKey) > 0 {
+		mac := hmac.New(sha256.New, []byte(c.CookieHashKey))
+		mac.Write([]byte(anonymousIDKeyContext))
+		return mac.Sum(nil), nil
+	}
+	slog.Warn("SESSION STORE", "message", "the anonymous ID key is not set, using a random key, anonymous IDs will change when the gateway restarts")
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,48 @@
+package sessions
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var k8sLabelValue = regexp.MustCompile(`^[a-z0-9A-Z]([-_.a-z0-9A-Z]{0,61}[a-z0-9A-Z])?$`)
+
+func TestAnonymousIDIsStableAndValidLabel(t *testing.T) {
+	sessionStore := setupSessionStore(t)
+	session := models.Session{ID: "mySessionID"}
+
+	anonID := sessionStore.AnonymousID(&session)
+
+	assert.Equal(t, anonID, sessionStore.AnonymousID(&session))
+	assert.NotContains(t, anonID, session.ID)
+	assert.Regexp(t, k8sLabelValue, anonID)
+	assert.Regexp(t, "^anon-", anonID)
+}
+
+func TestAnonymousIDDependsOnKey(t *testing.T) {
+	session := models.Session{ID: "mySessionID"}
+
+	anonID1 := anonymousID([]byte("key-1"), session.ID)
+	anonID2 := anonymousID([]byte("key-2"), session.ID)
+
+	assert.NotEqual(t, anonID1, anonID2)
+	assert.NotEqual(t, anonID1, anonymousID([]byte("key-1"), "otherSessionID"))
+}
+
+func TestAnonymousIDKeyFromConfig(t *testing.T) {
+	configuredKey, err := anonymousIDKeyFromConfig(config.SessionConfig{AnonymousIDKey: "configured-key", CookieHashKey: "cookie-hash-key"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("configured-key"), configuredKey)
+
+	derivedKey, err := anonymousIDKeyFromConfig(config.SessionConfig{CookieHashKey: "cookie-hash-key"})
+	require.NoError(t, err)
+	assert.NotEqual(t, []byte("cookie-hash-key"), derivedKey)
+	derivedKeyAgain, err := anonymousIDKeyFromConfig(config.SessionConfig{CookieHashKey: "cookie-hash-key"})
+	require.NoError(t, err)
+	assert.Equal(t, derivedKey, derivedKeyAgain)
+}
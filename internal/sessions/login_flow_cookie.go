@@ -0,0 +1,59 @@
+package sessions
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// loginFlowCookieName returns the name of the cookie which binds a login flow to the browser that started it,
+// every flow has its own cookie so that several flows can run at the same time
+func loginFlowCookieName(state string) string {
+	return LoginFlowCookiePrefix + state
+}
+
+// SetLoginFlowCookie sets the cookie which the callback of the current step of a login flow has to present
+func (sessions *SessionStore) SetLoginFlowCookie(c echo.Context, flow models.LoginFlow) error {
+	cookie := sessions.cookieTemplate()
+	cookie.Name = loginFlowCookieName(flow.State)
+	cookie.MaxAge = max(int(time.Until(flow.ExpiresAt).Seconds()), 1)
+	cookie.Value = flow.Binding
+	if sessions.cookieHandler != nil {
+		encoded, err := sessions.cookieHandler.Encode(cookie.Name, flow.Binding)
+		if err != nil {
+			return err
+		}
+		cookie.Value = encoded
+	}
+	c.SetCookie(&cookie)
+	return nil
+}
+
+// CheckLoginFlowCookie checks that the request comes from the browser which started the login flow
+// and unsets the cookie of the flow
+func (sessions *SessionStore) CheckLoginFlowCookie(c echo.Context, flow models.LoginFlow) error {
+	name := loginFlowCookieName(flow.State)
+	cookie, err := c.Cookie(name)
+	if err != nil {
+		return fmt.Errorf("the login flow was not started by this client")
+	}
+	unset := sessions.cookieTemplate()
+	unset.Name = name
+	unset.MaxAge = -1
+	c.SetCookie(&unset)
+
+	binding := cookie.Value
+	if sessions.cookieHandler != nil {
+		err = sessions.cookieHandler.Decode(name, cookie.Value, &binding)
+		if err != nil {
+			return fmt.Errorf("the login flow cookie is invalid: %w", err)
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(binding), []byte(flow.Binding)) != 1 {
+		return fmt.Errorf("the login flow was not started by this client")
+	}
+	return nil
+}
@@ -2,8 +2,15 @@ package sessions
 
 // NOTE: The UI may depend on some of these values, changing them will cause breaking changes
 const (
-	SessionCookieName = "_renku_session"
-	SessionCtxKey     = "renku_session"
+	SessionCookieName     = "_renku_session"
+	SessionCtxKey         = "renku_session"
+	LoginFlowCookiePrefix = "_renku_login_"
+)
+
+// Response headers telling the browser when the current session expires, the values are RFC 3339 timestamps
+const (
+	SessionExpiresAtHeader    = "Renku-Session-Expires-At"
+	SessionMaxExpiresAtHeader = "Renku-Session-Max-Expires-At"
 )
 
 const (
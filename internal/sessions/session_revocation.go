@@ -0,0 +1,160 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+)
+
+// GetSessionIDsByUser returns the IDs of the sessions of a user
+func (sessions *SessionStore) GetSessionIDsByUser(ctx context.Context, userID string) ([]string, error) {
+	return sessions.sessionRepo.GetSessionIDsByUser(ctx, userID)
+}
+
+// GetSessionIDsByProviderSession returns the IDs of the sessions logged in with a session at an identity provider
+func (sessions *SessionStore) GetSessionIDsByProviderSession(ctx context.Context, providerID, providerSessionID string) ([]string, error) {
+	return sessions.sessionRepo.GetSessionIDsByProviderSession(ctx, providerID, providerSessionID)
+}
+
+// HasLiveSession checks if the tokens with the given ID are referenced by a session which has not expired
+func (sessions *SessionStore) HasLiveSession(ctx context.Context, tokenID string) (bool, error) {
+	sessionIDs, err := sessions.sessionRepo.GetSessionIDsByToken(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+	for _, sessionID := range sessionIDs {
+		session, err := sessions.sessionRepo.GetSession(ctx, sessionID)
+		if errors.Is(err, gwerrors.ErrSessionNotFound) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !session.Expired() && slices.Contains(slices.Collect(maps.Values(session.TokenIDs)), tokenID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListUserSessions returns the sessions of a user which have not expired
+func (sessions *SessionStore) ListUserSessions(ctx context.Context, userID string) ([]models.Session, error) {
+	userSessions, err := sessions.sessionRepo.GetSessionsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(userSessions, func(session models.Session) bool {
+		return session.Expired()
+	}), nil
+}
+
+// Revoke removes a session from storage together with its tokens. Tokens that are still
+// referenced by other live sessions of the same user are kept.
+func (sessions *SessionStore) Revoke(ctx context.Context, sessionID string) error {
+	session, err := sessions.sessionRepo.GetSession(ctx, sessionID)
+	if err != nil && !errors.Is(err, gwerrors.ErrSessionNotFound) {
+		return err
+	}
+	err = sessions.sessionRepo.RemoveSession(ctx, sessionID)
+	if err != nil {
+		return err
+	}
+	return sessions.removeUnusedTokens(ctx, session)
+}
+
+// RevokeUserSessions removes all the sessions of a user together with their tokens
+// and returns the number of removed sessions
+func (sessions *SessionStore) RevokeUserSessions(ctx context.Context, userID string) (int, error) {
+	userSessions, err := sessions.sessionRepo.GetSessionsByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	err = sessions.sessionRepo.RemoveSessionsByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	tokenIDs := map[string]bool{}
+	for _, session := range userSessions {
+		for _, tokenID := range session.TokenIDs {
+			tokenIDs[tokenID] = true
+		}
+	}
+	var errs []error
+	for tokenID := range tokenIDs {
+		errs = append(errs, sessions.removeTokens(ctx, tokenID)...)
+	}
+	slog.Info("SESSION STORE", "message", "revoked all sessions of a user", "userID", userID, "count", len(userSessions))
+	return len(userSessions), errors.Join(errs...)
+}
+
+// Disconnect removes the tokens of a provider from a session. The tokens are revoked and removed from storage
+// unless they are still used by other live sessions of the same user.
+func (sessions *SessionStore) Disconnect(ctx context.Context, session *models.Session, providerID string) error {
+	tokenID, found := session.TokenIDs[providerID]
+	if !found {
+		return nil
+	}
+	delete(session.TokenIDs, providerID)
+	delete(session.ProviderSessionIDs, providerID)
+	err := sessions.sessionRepo.SetSession(ctx, *session)
+	if err != nil {
+		return err
+	}
+	slog.Info("SESSION STORE", "message", "disconnected provider", "sessionID", session.ID, "providerID", providerID)
+	return sessions.removeUnusedTokens(ctx, models.Session{
+		ID:       session.ID,
+		UserID:   session.UserID,
+		TokenIDs: models.SerializableMap{providerID: tokenID},
+	})
+}
+
+// removeUnusedTokens removes the tokens of a removed session which are not used by other live sessions of the user
+func (sessions *SessionStore) removeUnusedTokens(ctx context.Context, session models.Session) error {
+	if len(session.TokenIDs) == 0 {
+		return nil
+	}
+	usedTokenIDs := map[string]bool{}
+	if session.UserID != "" {
+		otherSessions, err := sessions.ListUserSessions(ctx, session.UserID)
+		if err != nil {
+			return err
+		}
+		for _, otherSession := range otherSessions {
+			if otherSession.ID == session.ID {
+				continue
+			}
+			for _, tokenID := range otherSession.TokenIDs {
+				usedTokenIDs[tokenID] = true
+			}
+		}
+	}
+	var errs []error
+	for providerID, tokenID := range session.TokenIDs {
+		if usedTokenIDs[tokenID] {
+			continue
+		}
+		slog.Debug("SESSION STORE", "message", "removing tokens of revoked session", "sessionID", session.ID, "providerID", providerID, "tokenID", tokenID)
+		errs = append(errs, sessions.removeTokens(ctx, tokenID)...)
+	}
+	return errors.Join(errs...)
+}
+
+// removeTokens revokes the tokens at their identity provider and removes them from storage.
+// Failing to revoke the tokens is not fatal as they are removed from storage anyway.
+func (sessions *SessionStore) removeTokens(ctx context.Context, tokenID string) []error {
+	err := sessions.tokenStore.RevokeTokens(ctx, tokenID)
+	if err != nil {
+		slog.Warn("SESSION STORE", "message", "revoking tokens at the identity provider failed", "tokenID", tokenID, "error", err)
+	}
+	return []error{
+		sessions.tokenStore.RemoveAccessToken(ctx, tokenID),
+		sessions.tokenStore.RemoveRefreshToken(ctx, tokenID),
+		sessions.tokenStore.RemoveIDToken(ctx, tokenID),
+		sessions.tokenStore.RemoveExchangedTokens(ctx, tokenID),
+	}
+}
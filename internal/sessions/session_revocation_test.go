@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevokeKeepsTokensOfOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	sessionStore := setupSessionStore(t)
+	for _, tokenID := range []string{"renku:user-1", "gitlab:user-1"} {
+		require.NoError(t, sessionStore.tokenStore.SetRefreshToken(ctx, models.AuthToken{
+			ID:        tokenID,
+			Type:      models.RefreshTokenType,
+			Value:     "refresh-token-value",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+	}
+	session1, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	session1.UserID = "user-1"
+	session1.TokenIDs = models.SerializableMap{"renku": "renku:user-1", "gitlab": "gitlab:user-1"}
+	session2, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	session2.UserID = "user-1"
+	session2.TokenIDs = models.SerializableMap{"renku": "renku:user-1"}
+	require.NoError(t, sessionStore.sessionRepo.SetSession(ctx, session1))
+	require.NoError(t, sessionStore.sessionRepo.SetSession(ctx, session2))
+
+	require.NoError(t, sessionStore.Revoke(ctx, session1.ID))
+	_, err = sessionStore.sessionRepo.GetSession(ctx, session1.ID)
+	assert.ErrorIs(t, err, gwerrors.ErrSessionNotFound)
+	_, err = sessionStore.tokenStore.GetRefreshToken(ctx, "renku:user-1")
+	assert.NoError(t, err)
+	_, err = sessionStore.tokenStore.GetRefreshToken(ctx, "gitlab:user-1")
+	assert.Error(t, err)
+
+	require.NoError(t, sessionStore.Revoke(ctx, session2.ID))
+	_, err = sessionStore.tokenStore.GetRefreshToken(ctx, "renku:user-1")
+	assert.Error(t, err)
+	sessionIDs, err := sessionStore.GetSessionIDsByUser(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, sessionIDs)
+}
+
+func TestListAndRevokeUserSessions(t *testing.T) {
+	ctx := context.Background()
+	sessionStore := setupSessionStore(t)
+	require.NoError(t, sessionStore.tokenStore.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "renku:user-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-value",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	liveSession, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	liveSession.UserID = "user-1"
+	liveSession.TokenIDs = models.SerializableMap{"renku": "renku:user-1"}
+	expiredSession, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	expiredSession.UserID = "user-1"
+	expiredSession.ExpiresAt = time.Now().UTC().Add(-time.Second)
+	otherSession, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	otherSession.UserID = "user-2"
+	for _, session := range []models.Session{liveSession, expiredSession, otherSession} {
+		require.NoError(t, sessionStore.sessionRepo.SetSession(ctx, session))
+	}
+
+	userSessions, err := sessionStore.ListUserSessions(ctx, "user-1")
+	require.NoError(t, err)
+	require.Len(t, userSessions, 1)
+	assert.Equal(t, liveSession.ID, userSessions[0].ID)
+
+	count, err := sessionStore.RevokeUserSessions(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	userSessions, err = sessionStore.ListUserSessions(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Empty(t, userSessions)
+	_, err = sessionStore.tokenStore.GetRefreshToken(ctx, "renku:user-1")
+	assert.Error(t, err)
+	_, err = sessionStore.sessionRepo.GetSession(ctx, otherSession.ID)
+	assert.NoError(t, err)
+}
+
+func TestHasLiveSession(t *testing.T) {
+	ctx := context.Background()
+	sessionStore := setupSessionStore(t)
+	session, err := sessionStore.sessionMaker.NewSession()
+	require.NoError(t, err)
+	session.UserID = "user-1"
+	session.TokenIDs = models.SerializableMap{"renku": "renku:user-1"}
+	require.NoError(t, sessionStore.sessionRepo.SetSession(ctx, session))
+
+	live, err := sessionStore.HasLiveSession(ctx, "renku:user-1")
+	require.NoError(t, err)
+	assert.True(t, live)
+	live, err = sessionStore.HasLiveSession(ctx, "gitlab:user-1")
+	require.NoError(t, err)
+	assert.False(t, live)
+
+	require.NoError(t, sessionStore.sessionRepo.RemoveSession(ctx, session.ID))
+	live, err = sessionStore.HasLiveSession(ctx, "renku:user-1")
+	require.NoError(t, err)
+	assert.False(t, live)
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/gorilla/securecookie"
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
+	"github.com/zitadel/oidc/v3/pkg/oidc"
 )
 
 // SessionStore handles sessions for the login server and the revproxy server
@@ -29,6 +30,8 @@ type SessionStore struct {
 	sessionMaker   SessionMaker
 	sessionRepo    models.SessionRepository
 	tokenStore     models.TokenStoreInterface
+	anonymousIDKey []byte
+	providerIDs    []string
 }
 
 // Middleware returns the session middleware which injects the current session in the request context
@@ -51,6 +54,10 @@ func (sessions *SessionStore) Middleware() echo.MiddlewareFunc {
 			}
 
 			sessions.setSentryData(c, session)
+			// The session can change in the handler, the headers reflect the session at the time of the response
+			c.Response().Before(func() {
+				sessions.setExpiryHeaders(c)
+			})
 
 			err := next(c)
 			saveErr := sessions.Save(c)
@@ -135,6 +142,25 @@ func (sessions *SessionStore) Create(c echo.Context) (*models.Session, error) {
 	return &session, nil
 }
 
+// Resume makes an existing session the current session of the request and sets its cookie
+func (sessions *SessionStore) Resume(c echo.Context, sessionID string) (*models.Session, error) {
+	session, err := sessions.sessionRepo.GetSession(c.Request().Context(), sessionID)
+	if err != nil {
+		return &models.Session{}, err
+	}
+	if session.Expired() {
+		return &models.Session{}, gwerrors.ErrSessionExpired
+	}
+	session.Touch()
+	cookie, err := sessions.cookie(session)
+	if err != nil {
+		return &models.Session{}, err
+	}
+	c.Set(SessionCtxKey, &session)
+	c.SetCookie(&cookie)
+	return &session, nil
+}
+
 func (sessions *SessionStore) Save(c echo.Context) error {
 	session, err := sessions.Get(c)
 	if err != nil {
@@ -149,7 +175,8 @@ func (sessions *SessionStore) Save(c echo.Context) error {
 	return sessions.sessionRepo.SetSession(childCtx, *session)
 }
 
-// Delete removes the current session from storage and unsets the session cookie
+// Delete removes the current session from storage together with its tokens and unsets the session cookie.
+// Tokens that are still referenced by other live sessions of the same user are kept.
 func (sessions *SessionStore) Delete(c echo.Context) error {
 	sessionID, err := sessions.getSessionIDFromCookie(c)
 	if err != nil {
@@ -165,7 +192,23 @@ func (sessions *SessionStore) Delete(c echo.Context) error {
 	if sessionID == "" {
 		return nil
 	}
-	return sessions.sessionRepo.RemoveSession(c.Request().Context(), sessionID)
+	return sessions.Revoke(c.Request().Context(), sessionID)
+}
+
+// setExpiryHeaders tells the browser when the current session expires, ephemeral sessions and
+// sessions which never expire do not get the headers
+func (sessions *SessionStore) setExpiryHeaders(c echo.Context) {
+	session, err := sessions.getFromContext(c)
+	if err != nil || session.ID == "" {
+		return
+	}
+	header := c.Response().Header()
+	if !session.ExpiresAt.IsZero() {
+		header.Set(SessionExpiresAtHeader, session.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if maxExpiresAt := session.MaxExpiresAt(); !maxExpiresAt.IsZero() {
+		header.Set(SessionMaxExpiresAtHeader, maxExpiresAt.UTC().Format(time.RFC3339))
+	}
 }
 
 func (sessions *SessionStore) cookie(session models.Session) (http.Cookie, error) {
@@ -230,18 +273,10 @@ func (sessions *SessionStore) getFromHeaders(c echo.Context) (*models.Session, e
 	if accessToken != "" {
 		claims, err := sessions.authenticator.VerifyAccessToken(c.Request().Context(), accessToken)
 		if err == nil {
-			userID := claims.Subject
-			tokenIDs := map[string]string{"renku": "renku:" + userID, "gitlab": "gitlab:" + userID}
-			// make an ephemeral session
-			session := models.Session{
-				CreatedAt: time.Now().UTC(),
-				UserID:    userID,
-				TokenIDs:  tokenIDs,
-			}
-			c.Set(SessionCtxKey, &session)
+			session := sessions.ephemeralSession(c, accessToken, claims)
 			// Re-set the authorization header
 			c.Request().Header.Set(echo.HeaderAuthorization, accessToken)
-			return &session, nil
+			return session, nil
 		}
 	}
 	return &models.Session{}, gwerrors.ErrSessionNotFound
@@ -253,23 +288,47 @@ func (sessions *SessionStore) getFromBasicAuth(c echo.Context) (*models.Session,
 	if ok {
 		claims, err := sessions.authenticator.VerifyAccessToken(c.Request().Context(), basicAuthPwd)
 		if err == nil {
-			userID := claims.Subject
-			tokenIDs := map[string]string{"renku": "renku:" + userID, "gitlab": "gitlab:" + userID}
-			// make an ephemeral session
-			session := models.Session{
-				CreatedAt: time.Now().UTC(),
-				UserID:    userID,
-				TokenIDs:  tokenIDs,
-			}
-			c.Set(SessionCtxKey, &session)
+			session := sessions.ephemeralSession(c, basicAuthPwd, claims)
 			// Re-set the authorization header
 			c.Request().Header.Set(echo.HeaderAuthorization, basicAuthPwd)
-			return &session, nil
+			return session, nil
 		}
 	}
 	return &models.Session{}, gwerrors.ErrSessionNotFound
 }
 
+// ephemeralSession makes a session which is not saved for a request authenticated with a verified access token.
+// The access token is kept in the request context so that the claims of the token presented by the client are used
+// for the request instead of the stored tokens of the user.
+func (sessions *SessionStore) ephemeralSession(c echo.Context, accessToken string, claims oidc.TokenClaims) *models.Session {
+	userID := claims.Subject
+	session := models.Session{
+		CreatedAt: time.Now().UTC(),
+		UserID:    userID,
+		TokenIDs:  sessions.tokenIDs(userID),
+	}
+	tokenID := session.TokenIDs[models.PrimaryProviderID]
+	c.Set(sessions.accessTokenKey(tokenID), models.AuthToken{
+		ID:         tokenID,
+		Type:       models.AccessTokenType,
+		Value:      accessToken,
+		ExpiresAt:  claims.Expiration.AsTime(),
+		Subject:    userID,
+		ProviderID: models.PrimaryProviderID,
+	})
+	c.Set(SessionCtxKey, &session)
+	return &session
+}
+
+// tokenIDs returns the IDs of the tokens of a user for every login provider
+func (sessions *SessionStore) tokenIDs(userID string) models.SerializableMap {
+	tokenIDs := models.SerializableMap{models.PrimaryProviderID: models.TokenID(models.PrimaryProviderID, userID)}
+	for _, providerID := range sessions.providerIDs {
+		tokenIDs[providerID] = models.TokenID(providerID, userID)
+	}
+	return tokenIDs
+}
+
 // setSentryData adds request and session metadata for Sentry
 func (sessions *SessionStore) setSentryData(c echo.Context, session *models.Session) {
 	hub := sentryecho.GetHubFromContext(c)
@@ -342,12 +401,27 @@ func WithConfig(c config.SessionConfig) SessionStoreOption {
 			sessions.cookieHandler = securecookie.New(cookieHashKey, cookieEncKey)
 		}
 
+		anonymousIDKey, err := anonymousIDKeyFromConfig(c)
+		if err != nil {
+			return err
+		}
+		sessions.anonymousIDKey = anonymousIDKey
+
 		sessions.sessionMaker = NewSessionMaker(WithIdleSessionTTLSeconds(c.IdleSessionTTLSeconds), WithMaxSessionTTLSeconds(c.MaxSessionTTLSeconds))
 
 		return nil
 	}
 }
 
+// WithProviderIDs sets the login providers whose tokens are available in the sessions created from
+// the Authorization header, the primary provider is always included
+func WithProviderIDs(providerIDs ...string) SessionStoreOption {
+	return func(sessions *SessionStore) error {
+		sessions.providerIDs = providerIDs
+		return nil
+	}
+}
+
 func WithCookieTemplate(tpl func() http.Cookie) SessionStoreOption {
 	return func(sessions *SessionStore) error {
 		sessions.cookieTemplate = tpl
@@ -394,5 +468,8 @@ func NewSessionStore(options ...SessionStoreOption) (*SessionStore, error) {
 	if sessions.tokenStore == nil {
 		return &SessionStore{}, fmt.Errorf("token store is not initialized")
 	}
+	if len(sessions.anonymousIDKey) == 0 {
+		return &SessionStore{}, fmt.Errorf("anonymous ID key is not initialized")
+	}
 	return &sessions, nil
 }
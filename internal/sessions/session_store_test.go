@@ -9,6 +9,7 @@ import (
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/authentication"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/tokenstore"
 	"github.com/gorilla/securecookie"
 	"github.com/labstack/echo/v4"
@@ -149,3 +150,60 @@ func TestGetSessionIDFromCookieNoCookie(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "", sessionID)
 }
+
+func TestTokenIDsOfProviders(t *testing.T) {
+	sessionStore := setupSessionStore(t, WithProviderIDs("gitlab", "zenodo"))
+
+	tokenIDs := sessionStore.tokenIDs("user-1")
+
+	assert.Equal(t, models.SerializableMap{
+		"renku":  "renku:user-1",
+		"gitlab": "gitlab:user-1",
+		"zenodo": "zenodo:user-1",
+	}, tokenIDs)
+}
+
+func TestExpiryHeaders(t *testing.T) {
+	sessionStore := setupSessionStore(t, WithConfig(config.SessionConfig{
+		UnsafeNoCookieHandler: true,
+		IdleSessionTTLSeconds: 600,
+		MaxSessionTTLSeconds:  3600,
+	}))
+	e := echo.New()
+	e.Use(sessionStore.Middleware())
+	e.GET("/login", func(c echo.Context) error {
+		_, err := sessionStore.Create(c)
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, rec.Header().Get(SessionExpiresAtHeader))
+	assert.Empty(t, rec.Header().Get(SessionMaxExpiresAtHeader))
+
+	// The headers are set for a session created by the handler
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	expiresAt, err := time.Parse(time.RFC3339, rec.Header().Get(SessionExpiresAtHeader))
+	require.NoError(t, err)
+	maxExpiresAt, err := time.Parse(time.RFC3339, rec.Header().Get(SessionMaxExpiresAtHeader))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), expiresAt, 5*time.Second)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), maxExpiresAt, 5*time.Second)
+
+	// The headers are set for an existing session
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.NotEmpty(t, rec.Header().Get(SessionExpiresAtHeader))
+	assert.Equal(t, maxExpiresAt.Format(time.RFC3339), rec.Header().Get(SessionMaxExpiresAtHeader))
+}
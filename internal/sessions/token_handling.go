@@ -66,6 +66,12 @@ func (sessions *SessionStore) GetAccessToken(c echo.Context, session models.Sess
 	return token, nil
 }
 
+// ExchangeAccessToken exchanges an access token for a token with the given audience and scopes,
+// the token store reuses the exchanged tokens until they expire
+func (sessions *SessionStore) ExchangeAccessToken(c echo.Context, accessToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error) {
+	return sessions.tokenStore.ExchangeAccessToken(c.Request().Context(), accessToken, audience, scopes)
+}
+
 func (sessions *SessionStore) GetRefreshToken(c echo.Context, session models.Session, providerID string) (models.AuthToken, error) {
 	if session.TokenIDs == nil {
 		session.TokenIDs = models.SerializableMap{}
@@ -141,6 +147,9 @@ func (sessions *SessionStore) SaveTokens(c echo.Context, session *models.Session
 	if err != nil {
 		return err
 	}
+	if !tokens.HasIDToken() {
+		return nil
+	}
 	err = sessions.tokenStore.SetIDToken(c.Request().Context(), tokens.IDToken)
 	if err != nil {
 		return err
@@ -0,0 +1,145 @@
+package tokenstore
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+)
+
+const (
+	defaultRefresherInterval     time.Duration = 30 * time.Second
+	defaultRefresherRefreshAhead time.Duration = 5 * time.Minute
+	defaultRefresherConcurrency  int           = 4
+	defaultRefresherBatchSize    int           = 100
+)
+
+// SessionChecker checks if the tokens with the given ID are still used by a session which has not expired
+type SessionChecker func(ctx context.Context, tokenID string) (bool, error)
+
+// BackgroundRefresher refreshes the tokens of active sessions before they expire. The tokens are picked
+// from the refresh schedule of the token store, so every replica of the gateway can run a refresher.
+type BackgroundRefresher struct {
+	tokenStore     *TokenStore
+	hasLiveSession SessionChecker
+	interval       time.Duration
+	concurrency    int
+	batchSize      int
+}
+
+// Start refreshes the due tokens periodically until the context is cancelled
+func (r *BackgroundRefresher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			r.refreshDueTokens(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refreshDueTokens refreshes the tokens whose scheduled refresh time has passed
+func (r *BackgroundRefresher) refreshDueTokens(ctx context.Context) {
+	schedule := r.tokenStore.refreshSchedule
+	count, err := schedule.CountScheduledTokenRefreshes(ctx)
+	if err == nil {
+		scheduledRefreshes.Set(float64(count))
+	}
+	tokenIDs, err := schedule.GetDueTokenRefreshes(ctx, time.Now(), r.batchSize)
+	if err != nil {
+		slog.Error("TOKEN REFRESHER", "message", "GetDueTokenRefreshes failed", "error", err)
+		return
+	}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, r.concurrency)
+	for _, tokenID := range tokenIDs {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case slots <- struct{}{}:
+		}
+		wg.Go(func() {
+			defer func() { <-slots }()
+			r.refresh(ctx, tokenID)
+		})
+	}
+	wg.Wait()
+}
+
+func (r *BackgroundRefresher) refresh(ctx context.Context, tokenID string) {
+	schedule := r.tokenStore.refreshSchedule
+	live, err := r.hasLiveSession(ctx, tokenID)
+	if err != nil {
+		slog.Error("TOKEN REFRESHER", "message", "checking the sessions of tokens failed", "tokenID", tokenID, "error", err)
+		backgroundRefreshes.WithLabelValues("failed").Inc()
+		return
+	}
+	if !live {
+		slog.Debug("TOKEN REFRESHER", "message", "tokens are not used by any live session anymore", "tokenID", tokenID)
+		backgroundRefreshes.WithLabelValues("skipped").Inc()
+		err = schedule.UnscheduleTokenRefresh(ctx, tokenID)
+		if err != nil {
+			slog.Error("TOKEN REFRESHER", "message", "UnscheduleTokenRefresh failed", "tokenID", tokenID, "error", err)
+		}
+		return
+	}
+	start := time.Now()
+	_, err = r.tokenStore.refreshExpiringWithin(ctx, tokenID, r.tokenStore.refreshAhead)
+	backgroundRefreshDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		// The tokens are refreshed and scheduled again by the next request which needs them
+		slog.Warn("TOKEN REFRESHER", "message", "refreshing tokens failed", "tokenID", tokenID, "error", err)
+		backgroundRefreshes.WithLabelValues("failed").Inc()
+		err = schedule.UnscheduleTokenRefresh(ctx, tokenID)
+		if err != nil {
+			slog.Error("TOKEN REFRESHER", "message", "UnscheduleTokenRefresh failed", "tokenID", tokenID, "error", err)
+		}
+		return
+	}
+	slog.Debug("TOKEN REFRESHER", "message", "refreshed tokens", "tokenID", tokenID)
+	backgroundRefreshes.WithLabelValues("refreshed").Inc()
+}
+
+// refreshLead returns how long before the expiry of tokens with the given remaining lifetime their refresh
+// is scheduled. The lead is at most half of the lifetime, otherwise tokens issued with a lifetime shorter
+// than the refresh ahead duration would be due again as soon as they are refreshed.
+func refreshLead(refreshAhead, lifetime time.Duration) time.Duration {
+	return max(min(refreshAhead, lifetime/2), 0)
+}
+
+// NewBackgroundRefresher creates a refresher for the tokens of a token store which has a refresh schedule,
+// the tokens are refreshed ahead of their expiry as configured in the token store
+func NewBackgroundRefresher(tokenStore *TokenStore, refresherConfig config.TokenRefresherConfig, hasLiveSession SessionChecker) (*BackgroundRefresher, error) {
+	if tokenStore == nil || tokenStore.refreshSchedule == nil {
+		return nil, fmt.Errorf("the token store does not have a refresh schedule")
+	}
+	if hasLiveSession == nil {
+		return nil, fmt.Errorf("the session checker is not initialized")
+	}
+	r := BackgroundRefresher{
+		tokenStore:     tokenStore,
+		hasLiveSession: hasLiveSession,
+		interval:       time.Duration(refresherConfig.IntervalSeconds) * time.Second,
+		concurrency:    refresherConfig.Concurrency,
+		batchSize:      refresherConfig.BatchSize,
+	}
+	if r.interval <= 0 {
+		r.interval = defaultRefresherInterval
+	}
+	if r.concurrency <= 0 {
+		r.concurrency = defaultRefresherConcurrency
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultRefresherBatchSize
+	}
+	return &r, nil
+}
@@ -0,0 +1,137 @@
+package tokenstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackgroundRefresher(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+		WithRefreshLocker(adapter),
+		WithRefreshSchedule(adapter, 5*time.Minute),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	for _, tokenID := range []string{"token-1", "token-2"} {
+		require.NoError(t, ts.SetAccessToken(ctx, models.AuthToken{
+			ID:        tokenID,
+			Type:      models.AccessTokenType,
+			Value:     "access-token-0",
+			ExpiresAt: time.Now().Add(2 * time.Minute),
+		}))
+		require.NoError(t, ts.SetRefreshToken(ctx, models.AuthToken{
+			ID:        tokenID,
+			Type:      models.RefreshTokenType,
+			Value:     "refresh-token-0",
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+		// The tokens are scheduled a minute ahead of their expiry, make them due now
+		require.NoError(t, adapter.ScheduleTokenRefresh(ctx, tokenID, time.Now()))
+	}
+	hasLiveSession := func(_ context.Context, tokenID string) (bool, error) {
+		return tokenID == "token-1", nil
+	}
+	refresher, err := NewBackgroundRefresher(ts, config.TokenRefresherConfig{}, hasLiveSession)
+	require.NoError(t, err)
+
+	refresher.refreshDueTokens(ctx)
+
+	assert.Equal(t, int32(1), provider.refreshes.Load())
+	accessToken, err := adapter.GetAccessToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-1", accessToken.Value)
+	// The refreshed tokens are scheduled again and the tokens without a live session are dropped
+	tokenIDs, err := adapter.GetDueTokenRefreshes(ctx, time.Now().Add(24*time.Hour), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1"}, tokenIDs)
+	tokenIDs, err = adapter.GetDueTokenRefreshes(ctx, time.Now().Add(5*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, tokenIDs)
+	accessToken, err = adapter.GetAccessToken(ctx, "token-2")
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-0", accessToken.Value)
+}
+
+func TestBackgroundRefresherWithShortLivedTokens(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	// The access tokens live as long as the refresh ahead duration
+	provider := &countingProvider{accessTTL: 5 * time.Minute}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+		WithRefreshLocker(adapter),
+		WithRefreshSchedule(adapter, 5*time.Minute),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	require.NoError(t, ts.SetAccessToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.AccessTokenType,
+		Value:     "access-token-0",
+		ExpiresAt: time.Now().Add(5 * time.Minute),
+	}))
+	require.NoError(t, ts.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-0",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	refresher, err := NewBackgroundRefresher(ts, config.TokenRefresherConfig{}, func(context.Context, string) (bool, error) {
+		return true, nil
+	})
+	require.NoError(t, err)
+
+	// The refresh is scheduled half way through the lifetime of the tokens
+	refresher.refreshDueTokens(ctx)
+	assert.Equal(t, int32(0), provider.refreshes.Load())
+	tokenIDs, err := adapter.GetDueTokenRefreshes(ctx, time.Now().Add(2*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, tokenIDs)
+	tokenIDs, err = adapter.GetDueTokenRefreshes(ctx, time.Now().Add(3*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token-1"}, tokenIDs)
+
+	// Once due the tokens are refreshed a single time and not again on the following runs
+	require.NoError(t, adapter.ScheduleTokenRefresh(ctx, "token-1", time.Now()))
+	for range 10 {
+		refresher.refreshDueTokens(ctx)
+	}
+	assert.Equal(t, int32(1), provider.refreshes.Load())
+	tokenIDs, err = adapter.GetDueTokenRefreshes(ctx, time.Now().Add(2*time.Minute), 10)
+	require.NoError(t, err)
+	assert.Empty(t, tokenIDs)
+}
+
+func TestRefreshLead(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, refreshLead(5*time.Minute, time.Hour))
+	assert.Equal(t, 150*time.Second, refreshLead(5*time.Minute, 5*time.Minute))
+	assert.Equal(t, time.Duration(0), refreshLead(5*time.Minute, -time.Minute))
+}
+
+func TestBackgroundRefresherRequiresSchedule(t *testing.T) {
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(db.NewMockRedisAdapter()),
+	)
+	require.NoError(t, err)
+	_, err = NewBackgroundRefresher(ts, config.TokenRefresherConfig{}, func(context.Context, string) (bool, error) {
+		return true, nil
+	})
+	assert.Error(t, err)
+}
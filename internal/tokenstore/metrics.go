@@ -0,0 +1,26 @@
+package tokenstore
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the background token refresher
+var (
+	backgroundRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gateway",
+		Name:      "token_refresher_refreshes_total",
+		Help:      "The number of token sets handled by the background refresher by result (refreshed, failed or skipped).",
+	}, []string{"result"})
+	backgroundRefreshDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gateway",
+		Name:      "token_refresher_refresh_duration_seconds",
+		Help:      "The duration of the token refreshes done by the background refresher.",
+		Buckets:   prometheus.DefBuckets,
+	})
+	scheduledRefreshes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gateway",
+		Name:      "token_refresher_scheduled_tokens",
+		Help:      "The number of token sets with a scheduled background refresh.",
+	})
+)
@@ -0,0 +1,145 @@
+package tokenstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+)
+
+// defaultRefreshLockTTL bounds how long a replica can hold the lock for refreshing the tokens with an ID
+const defaultRefreshLockTTL time.Duration = 30 * time.Second
+
+// refreshLockPollInterval is how often a replica checks if the refresh by another replica is done
+const refreshLockPollInterval time.Duration = 100 * time.Millisecond
+
+// refreshAccessToken refreshes the tokens with the given ID. Concurrent calls in this replica share
+// a single refresh and the refresh lock makes other replicas wait for its result.
+func (ts *TokenStore) refreshAccessToken(ctx context.Context, tokenID string) (models.AuthTokenSet, error) {
+	return ts.refreshExpiringWithin(ctx, tokenID, ts.ExpiryMargin)
+}
+
+// refreshExpiringWithin refreshes the tokens with the given ID, tokens which were refreshed by another
+// replica in the meantime are only refreshed again if they expire within the margin. Only the callers
+// with the same margin share a refresh, a result that is fresh enough for one margin may not be for another.
+func (ts *TokenStore) refreshExpiringWithin(ctx context.Context, tokenID string, margin time.Duration) (models.AuthTokenSet, error) {
+	result, err, shared := ts.refreshGroup.Do(fmt.Sprintf("%s:%d", tokenID, margin), func() (any, error) {
+		// The refresh is shared by all the waiting callers, so none of them can cancel it
+		return ts.refreshWithLock(context.WithoutCancel(ctx), tokenID, margin)
+	})
+	if shared {
+		slog.Debug("TOKEN STORE", "message", "shared the refresh of tokens with concurrent requests", "tokenID", tokenID)
+	}
+	if err != nil {
+		return models.AuthTokenSet{}, err
+	}
+	return result.(models.AuthTokenSet), nil
+}
+
+func (ts *TokenStore) refreshWithLock(ctx context.Context, tokenID string, margin time.Duration) (models.AuthTokenSet, error) {
+	if ts.refreshLocker == nil {
+		return ts.refreshTokens(ctx, tokenID, nil)
+	}
+	deadline := time.Now().Add(ts.refreshLockTTL)
+	for {
+		fencingToken, acquired, err := ts.refreshLocker.AcquireRefreshLock(ctx, tokenID, ts.refreshLockTTL)
+		if err != nil {
+			return models.AuthTokenSet{}, err
+		}
+		if acquired {
+			return ts.refreshWhileLocked(ctx, tokenID, fencingToken, margin)
+		}
+		slog.Debug("TOKEN STORE", "message", "waiting for another replica to refresh the tokens", "tokenID", tokenID)
+		err = ts.waitForRefreshLock(ctx, tokenID, deadline)
+		if err != nil {
+			return models.AuthTokenSet{}, err
+		}
+		tokens, err := ts.loadTokenSet(ctx, tokenID)
+		if err == nil && !expiresWithin(tokens, margin) {
+			return tokens, nil
+		}
+		// The other replica did not manage to refresh the tokens, try to refresh them here
+		if time.Now().After(deadline) {
+			return models.AuthTokenSet{}, fmt.Errorf("timed out waiting for the refresh of the tokens %s", tokenID)
+		}
+	}
+}
+
+func (ts *TokenStore) refreshWhileLocked(ctx context.Context, tokenID string, fencingToken int64, margin time.Duration) (models.AuthTokenSet, error) {
+	defer func() {
+		err := ts.refreshLocker.ReleaseRefreshLock(ctx, tokenID, fencingToken)
+		if err != nil {
+			slog.Error("TOKEN STORE", "message", "ReleaseRefreshLock failed", "tokenID", tokenID, "error", err)
+		}
+	}()
+	// Another replica may have refreshed the tokens just before the lock was acquired
+	tokens, err := ts.loadTokenSet(ctx, tokenID)
+	if err == nil && !expiresWithin(tokens, margin) {
+		return tokens, nil
+	}
+	return ts.refreshTokens(ctx, tokenID, func(ctx context.Context, tokens models.AuthTokenSet) error {
+		saved, err := ts.refreshLocker.SetTokensWithRefreshLock(ctx, tokenID, fencingToken, tokenSetTokens(tokens)...)
+		if err != nil {
+			return err
+		}
+		if !saved {
+			return fmt.Errorf("the lock for refreshing the tokens %s expired while refreshing", tokenID)
+		}
+		return nil
+	})
+}
+
+// tokenSetTokens returns the tokens of a set that are saved, the ID token is optional
+func tokenSetTokens(tokens models.AuthTokenSet) []models.AuthToken {
+	output := []models.AuthToken{tokens.AccessToken, tokens.RefreshToken}
+	if tokens.IDToken.ID != "" {
+		output = append(output, tokens.IDToken)
+	}
+	return output
+}
+
+// waitForRefreshLock waits until the lock for refreshing the tokens is released or the deadline is reached
+func (ts *TokenStore) waitForRefreshLock(ctx context.Context, tokenID string, deadline time.Time) error {
+	for {
+		locked, err := ts.refreshLocker.IsRefreshLocked(ctx, tokenID)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the refresh of the tokens %s", tokenID)
+		}
+		time.Sleep(refreshLockPollInterval)
+	}
+}
+
+// loadTokenSet loads the stored tokens with the given ID, the ID token is optional
+func (ts *TokenStore) loadTokenSet(ctx context.Context, tokenID string) (models.AuthTokenSet, error) {
+	accessToken, err := ts.tokenRepo.GetAccessToken(ctx, tokenID)
+	if err != nil {
+		return models.AuthTokenSet{}, err
+	}
+	refreshToken, err := ts.tokenRepo.GetRefreshToken(ctx, tokenID)
+	if err != nil {
+		return models.AuthTokenSet{}, err
+	}
+	idToken, err := ts.tokenRepo.GetIDToken(ctx, tokenID)
+	if err != nil && !errors.Is(err, gwerrors.ErrTokenNotFound) {
+		return models.AuthTokenSet{}, err
+	}
+	return models.AuthTokenSet{AccessToken: accessToken, RefreshToken: refreshToken, IDToken: idToken}, nil
+}
+
+// expiresWithin checks if the access token or the refresh token of a set expires within the margin
+func expiresWithin(tokens models.AuthTokenSet, margin time.Duration) bool {
+	if tokens.AccessToken.ExpiresSoon(margin) {
+		return true
+	}
+	return !tokens.RefreshToken.ExpiresAt.IsZero() && tokens.RefreshToken.ExpiresSoon(margin)
+}
@@ -2,22 +2,40 @@ package tokenstore
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/oidc"
+	"golang.org/x/sync/singleflight"
 )
 
 type TokenStore struct {
 	ExpiryMargin time.Duration
 
-	providerStore oidc.ClientStore
-	tokenRepo     models.TokenRepository
+	providerStore   tokenProvider
+	tokenRepo       models.TokenRepository
+	refreshLocker   models.TokenRefreshLocker
+	refreshGroup    singleflight.Group
+	exchangeGroup   singleflight.Group
+	refreshLockTTL  time.Duration
+	refreshSchedule models.TokenRefreshSchedule
+	refreshAhead    time.Duration
+}
+
+// tokenProvider refreshes and revokes tokens at the identity providers, it is implemented by oidc.ClientStore
+type tokenProvider interface {
+	RefreshAccessToken(ctx context.Context, refreshToken models.AuthToken) (models.AuthTokenSet, error)
+	RevokeToken(ctx context.Context, token models.AuthToken) error
+	ExchangeToken(ctx context.Context, subjectToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error)
 }
 
 func (ts *TokenStore) GetFreshAccessToken(ctx context.Context, tokenID string) (models.AuthToken, error) {
@@ -116,7 +134,9 @@ func (ts *TokenStore) GetFreshIDToken(ctx context.Context, tokenID string) (mode
 	return token, nil
 }
 
-func (ts *TokenStore) refreshAccessToken(ctx context.Context, tokenID string) (models.AuthTokenSet, error) {
+// refreshTokens refreshes the tokens at the identity provider and saves them with saveTokens,
+// the tokens are saved directly in the token repository when it is nil
+func (ts *TokenStore) refreshTokens(ctx context.Context, tokenID string, saveTokens func(context.Context, models.AuthTokenSet) error) (models.AuthTokenSet, error) {
 	refreshToken, err := ts.tokenRepo.GetRefreshToken(ctx, tokenID)
 	if err != nil {
 		slog.Error("TOKEN STORE", "message", "GetRefreshToken failed", "error", err)
@@ -135,28 +155,68 @@ func (ts *TokenStore) refreshAccessToken(ctx context.Context, tokenID string) (m
 	if freshTokens.IDToken.ID != "" {
 		freshTokens.IDToken.ID = tokenID
 	}
-	err = ts.tokenRepo.SetAccessToken(childCtx, freshTokens.AccessToken)
+	if saveTokens == nil {
+		saveTokens = ts.saveTokenSet
+	}
+	err = saveTokens(childCtx, freshTokens)
 	if err != nil {
-		slog.Error("TOKEN STORE", "message", "SetAccessToken failed", "error", err)
+		slog.Error("TOKEN STORE", "message", "refreshed tokens are not saved", "error", err)
 		return models.AuthTokenSet{}, err
 	}
-	err = ts.tokenRepo.SetRefreshToken(childCtx, freshTokens.RefreshToken)
+	ts.scheduleRefresh(childCtx, freshTokens.AccessToken, freshTokens.RefreshToken)
+	ts.removeReplacedExchangedTokens(childCtx, tokenID)
+	return freshTokens, nil
+}
+
+// saveTokenSet saves the tokens of a set, the ID token is optional
+func (ts *TokenStore) saveTokenSet(ctx context.Context, tokens models.AuthTokenSet) error {
+	err := ts.tokenRepo.SetAccessToken(ctx, tokens.AccessToken)
 	if err != nil {
-		slog.Error("TOKEN STORE", "message", "SetRefreshToken failed", "error", err)
-		return models.AuthTokenSet{}, err
+		return err
 	}
-	if freshTokens.IDToken.ID != "" {
-		err = ts.tokenRepo.SetIDToken(childCtx, freshTokens.IDToken)
-		if err != nil {
-			slog.Error("TOKEN STORE", "message", "SetIDToken failed", "error", err)
-			return models.AuthTokenSet{}, err
-		}
+	err = ts.tokenRepo.SetRefreshToken(ctx, tokens.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if tokens.IDToken.ID != "" {
+		return ts.tokenRepo.SetIDToken(ctx, tokens.IDToken)
+	}
+	return nil
+}
+
+// scheduleRefresh schedules the background refresh of a token set ahead of when its access token or its
+// refresh token expires, whichever comes first
+func (ts *TokenStore) scheduleRefresh(ctx context.Context, accessToken, refreshToken models.AuthToken) {
+	if ts.refreshSchedule == nil || refreshToken.Value == "" {
+		return
+	}
+	expiresAt := accessToken.ExpiresAt
+	if !refreshToken.ExpiresAt.IsZero() && (expiresAt.IsZero() || refreshToken.ExpiresAt.Before(expiresAt)) {
+		expiresAt = refreshToken.ExpiresAt
+	}
+	if expiresAt.IsZero() {
+		return
+	}
+	refreshAt := expiresAt.Add(-refreshLead(ts.refreshAhead, time.Until(expiresAt)))
+	err := ts.refreshSchedule.ScheduleTokenRefresh(ctx, refreshToken.ID, refreshAt)
+	if err != nil {
+		slog.Error("TOKEN STORE", "message", "ScheduleTokenRefresh failed", "tokenID", refreshToken.ID, "error", err)
 	}
-	return freshTokens, nil
 }
 
 func (ts *TokenStore) SetAccessToken(ctx context.Context, token models.AuthToken) error {
-	return ts.tokenRepo.SetAccessToken(ctx, token)
+	err := ts.tokenRepo.SetAccessToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	ts.removeReplacedExchangedTokens(ctx, token.ID)
+	if ts.refreshSchedule != nil {
+		refreshToken, err := ts.tokenRepo.GetRefreshToken(ctx, token.ID)
+		if err == nil {
+			ts.scheduleRefresh(ctx, token, refreshToken)
+		}
+	}
+	return nil
 }
 
 func (ts *TokenStore) GetRefreshToken(ctx context.Context, tokenID string) (models.AuthToken, error) {
@@ -164,13 +224,136 @@ func (ts *TokenStore) GetRefreshToken(ctx context.Context, tokenID string) (mode
 }
 
 func (ts *TokenStore) SetRefreshToken(ctx context.Context, token models.AuthToken) error {
-	return ts.tokenRepo.SetRefreshToken(ctx, token)
+	err := ts.tokenRepo.SetRefreshToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if ts.refreshSchedule != nil {
+		accessToken, err := ts.tokenRepo.GetAccessToken(ctx, token.ID)
+		if err == nil {
+			ts.scheduleRefresh(ctx, accessToken, token)
+		}
+	}
+	return nil
 }
 
 func (ts *TokenStore) SetIDToken(ctx context.Context, token models.AuthToken) error {
 	return ts.tokenRepo.SetIDToken(ctx, token)
 }
 
+func (ts *TokenStore) RemoveAccessToken(ctx context.Context, tokenID string) error {
+	return ts.tokenRepo.RemoveAccessToken(ctx, tokenID)
+}
+
+func (ts *TokenStore) RemoveRefreshToken(ctx context.Context, tokenID string) error {
+	if ts.refreshSchedule != nil {
+		err := ts.refreshSchedule.UnscheduleTokenRefresh(ctx, tokenID)
+		if err != nil {
+			return err
+		}
+	}
+	return ts.tokenRepo.RemoveRefreshToken(ctx, tokenID)
+}
+
+func (ts *TokenStore) RemoveIDToken(ctx context.Context, tokenID string) error {
+	return ts.tokenRepo.RemoveIDToken(ctx, tokenID)
+}
+
+// removeReplacedExchangedTokens removes the tokens exchanged for the previous access token with the given ID
+// once it is replaced, they cannot be used anymore and they should not outlive it
+func (ts *TokenStore) removeReplacedExchangedTokens(ctx context.Context, tokenID string) {
+	err := ts.tokenRepo.RemoveExchangedTokens(ctx, tokenID)
+	if err != nil {
+		slog.Error("TOKEN STORE", "message", "RemoveExchangedTokens failed", "tokenID", tokenID, "error", err)
+	}
+}
+
+// RemoveExchangedTokens removes the tokens exchanged for the access token with the given ID
+func (ts *TokenStore) RemoveExchangedTokens(ctx context.Context, tokenID string) error {
+	return ts.tokenRepo.RemoveExchangedTokens(ctx, tokenID)
+}
+
+// RevokeTokens revokes the stored refresh and access tokens with the given ID at their identity provider.
+// Tokens which are missing or already expired are skipped.
+func (ts *TokenStore) RevokeTokens(ctx context.Context, tokenID string) error {
+	var errs []error
+	for _, getToken := range []func(context.Context, string) (models.AuthToken, error){
+		ts.tokenRepo.GetRefreshToken,
+		ts.tokenRepo.GetAccessToken,
+	} {
+		token, err := getToken(ctx, tokenID)
+		if errors.Is(err, gwerrors.ErrTokenNotFound) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if token.Value == "" || token.Expired() {
+			continue
+		}
+		err = ts.providerStore.RevokeToken(ctx, token)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("revoking the %s %s failed: %w", token.Type, tokenID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ExchangeAccessToken returns a token with the given audience and scopes which is exchanged for an access token
+// at its identity provider (RFC 8693). The exchanged token is saved under an ID derived from the ID and the value
+// of the access token and it is reused until it expires soon, so that the provider is not called on every request.
+// A cached token is only reused for the same access token and it does not outlive it. The exchanged tokens are
+// tracked per access token ID so that they are removed together with the access token.
+func (ts *TokenStore) ExchangeAccessToken(ctx context.Context, subjectToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error) {
+	tokenID := exchangedTokenID(subjectToken, audience, scopes)
+	token, err := ts.tokenRepo.GetAccessToken(ctx, tokenID)
+	if err != nil && !errors.Is(err, gwerrors.ErrTokenNotFound) {
+		return models.AuthToken{}, err
+	}
+	if err == nil && !token.ExpiresSoon(ts.ExpiryMargin) {
+		return token, nil
+	}
+	// Concurrent requests of this replica share a single exchange
+	result, err, _ := ts.exchangeGroup.Do(tokenID, func() (any, error) {
+		childCtx := context.WithoutCancel(ctx)
+		exchangedToken, err := ts.providerStore.ExchangeToken(childCtx, subjectToken, audience, scopes)
+		if err != nil {
+			slog.Error("TOKEN STORE", "message", "ExchangeToken failed", "tokenID", subjectToken.ID, "audience", audience, "error", err)
+			return models.AuthToken{}, err
+		}
+		exchangedToken.ID = tokenID
+		// The exchanged token is not reused once the token it was exchanged for has expired
+		if !subjectToken.ExpiresAt.IsZero() && (exchangedToken.ExpiresAt.IsZero() || subjectToken.ExpiresAt.Before(exchangedToken.ExpiresAt)) {
+			exchangedToken.ExpiresAt = subjectToken.ExpiresAt
+		}
+		err = ts.tokenRepo.SetExchangedToken(childCtx, subjectToken.ID, exchangedToken)
+		if err != nil {
+			slog.Error("TOKEN STORE", "message", "SetExchangedToken failed", "tokenID", tokenID, "error", err)
+			return models.AuthToken{}, err
+		}
+		return exchangedToken, nil
+	})
+	if err != nil {
+		return models.AuthToken{}, err
+	}
+	return result.(models.AuthToken), nil
+}
+
+// exchangedTokenID derives the ID of the token exchanged for an access token, the ID does not depend on the order
+// of the scopes. The hash of the value of the access token keeps apart the tokens exchanged for different access
+// tokens with the same ID, i.e. the stored access token of a user and a bearer token presented by one of their clients.
+func exchangedTokenID(subjectToken models.AuthToken, audience string, scopes []string) string {
+	hash := sha256.Sum256([]byte(subjectToken.Value))
+	return fmt.Sprintf(
+		"%s:exchanged:%s:%s:%s",
+		subjectToken.ID,
+		hex.EncodeToString(hash[:16]),
+		audience,
+		strings.Join(slices.Sorted(slices.Values(scopes)), "+"),
+	)
+}
+
 type TokenRefresherOption func(*TokenStore) error
 
 func WithExpiryMargin(expiresSoon time.Duration) TokenRefresherOption {
@@ -191,6 +374,27 @@ func WithConfig(loginConfig config.LoginConfig) TokenRefresherOption {
 	}
 }
 
+// WithRefreshLocker makes the replicas of the gateway coordinate token refreshes through the locker
+func WithRefreshLocker(locker models.TokenRefreshLocker) TokenRefresherOption {
+	return func(ts *TokenStore) error {
+		ts.refreshLocker = locker
+		return nil
+	}
+}
+
+// WithRefreshSchedule keeps track of when tokens expire so that they can be refreshed in the background,
+// the tokens are refreshed the given duration before they expire
+func WithRefreshSchedule(schedule models.TokenRefreshSchedule, refreshAhead time.Duration) TokenRefresherOption {
+	return func(ts *TokenStore) error {
+		ts.refreshSchedule = schedule
+		ts.refreshAhead = refreshAhead
+		if ts.refreshAhead <= 0 {
+			ts.refreshAhead = defaultRefresherRefreshAhead
+		}
+		return nil
+	}
+}
+
 func WithTokenRepository(tokenRepo models.TokenRepository) TokenRefresherOption {
 	return func(ts *TokenStore) error {
 		ts.tokenRepo = tokenRepo
@@ -200,7 +404,7 @@ func WithTokenRepository(tokenRepo models.TokenRepository) TokenRefresherOption
 
 // NewTokenStore creates a new TokenRefresher that handles refreshing access tokens which are expiring soon.
 func NewTokenStore(options ...TokenRefresherOption) (*TokenStore, error) {
-	ts := TokenStore{}
+	ts := TokenStore{refreshLockTTL: defaultRefreshLockTTL}
 	for _, opt := range options {
 		err := opt(&ts)
 		if err != nil {
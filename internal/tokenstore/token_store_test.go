@@ -1,9 +1,20 @@
 package tokenstore
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/config"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/db"
+	"github.com/SwissDataScienceCenter/renku-gateway/internal/gwerrors"
 	"github.com/SwissDataScienceCenter/renku-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Check that TokenStore implements TokenStoreInterface.
@@ -12,3 +23,306 @@ func TestTokenStoreImplementsInterface(t *testing.T) {
 	ts := TokenStore{}
 	_ = models.TokenStoreInterface(&ts)
 }
+
+// countingProvider is a token provider that counts the refreshes and exchanges and issues a new access token
+// for each of them
+type countingProvider struct {
+	refreshes atomic.Int32
+	exchanges atomic.Int32
+	delay     time.Duration
+	// The lifetime of the exchanged tokens
+	exchangedTTL time.Duration
+	// The lifetime of the refreshed access tokens, defaults to an hour
+	accessTTL time.Duration
+}
+
+func (p *countingProvider) RefreshAccessToken(ctx context.Context, refreshToken models.AuthToken) (models.AuthTokenSet, error) {
+	count := p.refreshes.Add(1)
+	time.Sleep(p.delay)
+	accessTTL := p.accessTTL
+	if accessTTL == 0 {
+		accessTTL = time.Hour
+	}
+	return models.AuthTokenSet{
+		AccessToken: models.AuthToken{
+			Type:      models.AccessTokenType,
+			Value:     fmt.Sprintf("access-token-%d", count),
+			ExpiresAt: time.Now().Add(accessTTL),
+		},
+		RefreshToken: models.AuthToken{
+			Type:      models.RefreshTokenType,
+			Value:     fmt.Sprintf("refresh-token-%d", count),
+			ExpiresAt: time.Now().Add(24 * time.Hour),
+		},
+	}, nil
+}
+
+func (*countingProvider) RevokeToken(context.Context, models.AuthToken) error {
+	return nil
+}
+
+func (p *countingProvider) ExchangeToken(ctx context.Context, subjectToken models.AuthToken, audience string, scopes []string) (models.AuthToken, error) {
+	count := p.exchanges.Add(1)
+	time.Sleep(p.delay)
+	return models.AuthToken{
+		Type:       models.AccessTokenType,
+		Value:      fmt.Sprintf("%s-token-%d", audience, count),
+		ExpiresAt:  time.Now().Add(p.exchangedTTL),
+		ProviderID: subjectToken.ProviderID,
+	}, nil
+}
+
+func TestConcurrentRefreshesAcrossReplicas(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{delay: 50 * time.Millisecond}
+	// Two token stores sharing the same Redis act as two replicas of the gateway
+	replicas := []*TokenStore{}
+	for range 2 {
+		ts, err := NewTokenStore(
+			WithExpiryMargin(time.Minute),
+			WithConfig(config.LoginConfig{}),
+			WithTokenRepository(adapter),
+			WithRefreshLocker(adapter),
+		)
+		require.NoError(t, err)
+		ts.providerStore = provider
+		replicas = append(replicas, ts)
+	}
+	require.NoError(t, adapter.SetAccessToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.AccessTokenType,
+		Value:     "access-token-0",
+		ExpiresAt: time.Now().Add(time.Second),
+	}))
+	require.NoError(t, adapter.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-0",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	var wg sync.WaitGroup
+	values := make([]string, 10)
+	for i := range values {
+		wg.Go(func() {
+			token, err := replicas[i%len(replicas)].GetFreshAccessToken(ctx, "token-1")
+			assert.NoError(t, err)
+			values[i] = token.Value
+		})
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), provider.refreshes.Load())
+	for _, value := range values {
+		assert.Equal(t, "access-token-1", value)
+	}
+	refreshToken, err := adapter.GetRefreshToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-token-1", refreshToken.Value)
+	locked, err := adapter.IsRefreshLocked(ctx, "token-1")
+	require.NoError(t, err)
+	assert.False(t, locked)
+}
+
+func TestRefreshNotSavedAfterLockExpired(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{delay: 50 * time.Millisecond}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+		WithRefreshLocker(adapter),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	// The lock expires while the provider refreshes the tokens
+	ts.refreshLockTTL = 10 * time.Millisecond
+	require.NoError(t, adapter.SetAccessToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.AccessTokenType,
+		Value:     "access-token-0",
+		ExpiresAt: time.Now().Add(time.Second),
+	}))
+	require.NoError(t, adapter.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-0",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+
+	// The refreshed tokens are not saved and the stored access token is returned
+	token, err := ts.GetFreshAccessToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "access-token-0", token.Value)
+	assert.Equal(t, int32(1), provider.refreshes.Load())
+	refreshToken, err := adapter.GetRefreshToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-token-0", refreshToken.Value)
+}
+
+func TestRefreshSharedOnlyWithTheSameMargin(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+		WithRefreshLocker(adapter),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	require.NoError(t, adapter.SetAccessToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.AccessTokenType,
+		Value:     "access-token-0",
+		ExpiresAt: time.Now().Add(3 * time.Minute),
+	}))
+	require.NoError(t, adapter.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "token-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-0",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	// Another replica holds the refresh lock so that both calls wait for it at the same time
+	fencingToken, acquired, err := adapter.AcquireRefreshLock(ctx, "token-1", time.Minute)
+	require.NoError(t, err)
+	require.True(t, acquired)
+
+	var wg sync.WaitGroup
+	var small, large models.AuthTokenSet
+	wg.Go(func() {
+		var err error
+		small, err = ts.refreshExpiringWithin(ctx, "token-1", time.Minute)
+		assert.NoError(t, err)
+	})
+	time.Sleep(20 * time.Millisecond)
+	wg.Go(func() {
+		var err error
+		large, err = ts.refreshExpiringWithin(ctx, "token-1", 5*time.Minute)
+		assert.NoError(t, err)
+	})
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, adapter.ReleaseRefreshLock(ctx, "token-1", fencingToken))
+	wg.Wait()
+
+	// The stored tokens are fresh enough for the small margin but not for the large one
+	assert.Equal(t, "access-token-0", small.AccessToken.Value)
+	assert.Equal(t, "access-token-1", large.AccessToken.Value)
+	assert.Equal(t, int32(1), provider.refreshes.Load())
+}
+
+func TestExchangeAccessToken(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{delay: 50 * time.Millisecond, exchangedTTL: time.Hour}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	subjectToken := models.AuthToken{
+		ID:         "renku:token-1",
+		Type:       models.AccessTokenType,
+		Value:      "access-token",
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ProviderID: "renku",
+	}
+
+	// Concurrent requests share one exchange and the exchanged token is cached
+	var wg sync.WaitGroup
+	values := make([]string, 5)
+	for i := range values {
+		wg.Go(func() {
+			token, err := ts.ExchangeAccessToken(ctx, subjectToken, "data-service", []string{"write", "read"})
+			assert.NoError(t, err)
+			values[i] = token.Value
+		})
+	}
+	wg.Wait()
+	token, err := ts.ExchangeAccessToken(ctx, subjectToken, "data-service", []string{"read", "write"})
+	require.NoError(t, err)
+	assert.Equal(t, "data-service-token-1", token.Value)
+	exchangedID := exchangedTokenID(subjectToken, "data-service", []string{"read", "write"})
+	assert.Equal(t, exchangedID, token.ID)
+	assert.True(t, strings.HasPrefix(exchangedID, "renku:token-1:exchanged:"))
+	assert.True(t, strings.HasSuffix(exchangedID, ":data-service:read+write"))
+	assert.Equal(t, int32(1), provider.exchanges.Load())
+	for _, value := range values {
+		assert.Equal(t, "data-service-token-1", value)
+	}
+	stored, err := adapter.GetAccessToken(ctx, exchangedID)
+	require.NoError(t, err)
+	assert.Equal(t, "data-service-token-1", stored.Value)
+
+	// Other audiences get their own tokens
+	token, err = ts.ExchangeAccessToken(ctx, subjectToken, "search", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "search-token-2", token.Value)
+
+	// Tokens which expire soon are exchanged again
+	provider.exchangedTTL = 30 * time.Second
+	token, err = ts.ExchangeAccessToken(ctx, subjectToken, "notebooks", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "notebooks-token-3", token.Value)
+	token, err = ts.ExchangeAccessToken(ctx, subjectToken, "notebooks", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "notebooks-token-4", token.Value)
+	assert.Equal(t, int32(4), provider.exchanges.Load())
+}
+
+func TestExchangedTokensAreTiedToTheSubjectToken(t *testing.T) {
+	ctx := context.Background()
+	adapter := db.NewMockRedisAdapter()
+	provider := &countingProvider{exchangedTTL: time.Hour}
+	ts, err := NewTokenStore(
+		WithExpiryMargin(time.Minute),
+		WithConfig(config.LoginConfig{}),
+		WithTokenRepository(adapter),
+	)
+	require.NoError(t, err)
+	ts.providerStore = provider
+	storedToken := models.AuthToken{
+		ID:         "renku:user-1",
+		Type:       models.AccessTokenType,
+		Value:      "stored-access-token",
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ProviderID: "renku",
+	}
+	require.NoError(t, ts.SetAccessToken(ctx, storedToken))
+	require.NoError(t, ts.SetRefreshToken(ctx, models.AuthToken{
+		ID:        "renku:user-1",
+		Type:      models.RefreshTokenType,
+		Value:     "refresh-token-0",
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+	}))
+	// A bearer token of the same user has the same ID as the stored access token
+	bearerToken := storedToken
+	bearerToken.Value = "bearer-access-token"
+	bearerToken.ExpiresAt = time.Now().Add(10 * time.Minute)
+
+	token, err := ts.ExchangeAccessToken(ctx, storedToken, "data-service", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "data-service-token-1", token.Value)
+	token, err = ts.ExchangeAccessToken(ctx, bearerToken, "data-service", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "data-service-token-2", token.Value)
+	// The token exchanged for the bearer token does not outlive it
+	assert.Equal(t, bearerToken.ExpiresAt, token.ExpiresAt)
+	token, err = ts.ExchangeAccessToken(ctx, storedToken, "data-service", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "data-service-token-1", token.Value)
+
+	// The tokens exchanged for the previous access token are removed when it is refreshed
+	_, err = ts.refreshAccessToken(ctx, "renku:user-1")
+	require.NoError(t, err)
+	_, err = adapter.GetAccessToken(ctx, exchangedTokenID(storedToken, "data-service", nil))
+	assert.ErrorIs(t, err, gwerrors.ErrTokenNotFound)
+	_, err = adapter.GetAccessToken(ctx, exchangedTokenID(bearerToken, "data-service", nil))
+	assert.ErrorIs(t, err, gwerrors.ErrTokenNotFound)
+	assert.Equal(t, int32(2), provider.exchanges.Load())
+}
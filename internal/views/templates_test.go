@@ -53,3 +53,21 @@ func TestGitlabLogoutTemplate(t *testing.T) {
 	assert.Contains(t, html, "<!DOCTYPE html>")
 	assert.Contains(t, html, "action=\"http://example.org/logout\"")
 }
+
+func TestLoginErrorTemplate(t *testing.T) {
+	templates, err := getTemplates()
+	require.NoError(t, err)
+	buf := new(bytes.Buffer)
+	data := map[string]any{
+		"renkuBaseURL": "http://renku.ch",
+		"title":        "Your login has expired",
+		"message":      "Please log in again.",
+		"retryURL":     "http://renku.ch/login?redirect_url=http%3A%2F%2Frenku.ch%2Fprojects",
+	}
+	err = templates.ExecuteTemplate(buf, "login_error", data)
+	require.NoError(t, err)
+	html := buf.String()
+	assert.Contains(t, html, "<!DOCTYPE html>")
+	assert.Contains(t, html, "<h1>Your login has expired</h1>")
+	assert.Contains(t, html, "<a class=\"btn-rk-green\" href=\"http://renku.ch/login?redirect_url=http%3A%2F%2Frenku.ch%2Fprojects\">")
+}